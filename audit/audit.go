@@ -0,0 +1,231 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/a0rana/UserAccountService/logger"
+)
+
+//actions recorded in the audit log
+const (
+	ActionCredit       = "credit"
+	ActionDebit        = "debit"
+	ActionReversal     = "reversal"
+	ActionExpiry       = "expiry"
+	ActionCorrection   = "correction"
+	ActionAPIKeyCreate = "apikey.create"
+	ActionAPIKeyRevoke = "apikey.revoke"
+	ActionLimitsSet    = "limits.set"
+	ActionSegmentSet   = "segment.set"
+	ActionStatusSet    = "status.set"
+	ActionOverdraftSet = "overdraft.set"
+)
+
+//entities the actions apply to
+const (
+	EntityUserCredit = "usercredit"
+	EntityActivity   = "activity"
+	EntityAPIKey     = "apikey"
+	EntityLimits     = "spendinglimits"
+	EntityUser       = "user"
+)
+
+//actor recorded when ctx tells nobody, e.g. with authentication disabled
+const anonymous = "anonymous"
+
+//Event is a change to record in the audit log. Before and After are the state of the entity around the change,
+//encoded as json, a nil state is recorded as NULL(nothing existed before a creation)
+type Event struct {
+	Action   string
+	UserId   string
+	Entity   string
+	EntityId uint64
+	Reason   string
+	Before   interface{}
+	After    interface{}
+	//replaces the actor of ctx, for changes made on behalf of someone else
+	Actor string
+}
+
+//Entry is a row of the audit log
+type Entry struct {
+	AuditId   uint64          `json:"auditid"`
+	Created   string          `json:"created"`
+	Action    string          `json:"action"`
+	Actor     string          `json:"actor"`
+	RequestId string          `json:"requestid,omitempty"`
+	ClientIP  string          `json:"clientip,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+	UserId    string          `json:"userid,omitempty"`
+	Entity    string          `json:"entity,omitempty"`
+	EntityId  uint64          `json:"entityid,omitempty"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+}
+
+type contextKey int
+
+const (
+	actorKey contextKey = iota
+	clientIPKey
+)
+
+//WithActor returns a copy of ctx carrying the actor recorded with the changes made with it, e.g. the authenticated
+//principal of a request or the operator running a tool
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+//WithClientIP returns a copy of ctx carrying the address of the client the changes made with it come from
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
+
+//Actor returns the actor carried by ctx
+func Actor(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey).(string); ok && len(actor) > 0 {
+		return actor
+	}
+	return anonymous
+}
+
+//Record writes the event in tx along with the actor, request id and client address carried by ctx, so that the
+//event is committed or rolled back with the change it describes
+func Record(ctx context.Context, tx *sql.Tx, e Event) (uint64, error) {
+	if len(e.Action) == 0 {
+		return 0, errors.New("an audit event needs an action")
+	}
+	actor := e.Actor
+	if len(actor) == 0 {
+		actor = Actor(ctx)
+	}
+	clientIP, _ := ctx.Value(clientIPKey).(string)
+	before, err := encode(e.Before)
+	if err != nil {
+		return 0, err
+	}
+	after, err := encode(e.After)
+	if err != nil {
+		return 0, err
+	}
+
+	var auditId uint64
+	err = tx.QueryRowContext(ctx, recordInsertStatement, e.Action, actor, nullString(logger.RequestID(ctx)),
+		nullString(clientIP), nullString(e.Reason), nullString(e.UserId), nullString(e.Entity), nullId(e.EntityId),
+		before, after).Scan(&auditId)
+	return auditId, err
+}
+
+//Filter selects the entries returned by List, every field left empty matches every entry. entries are returned newest
+//first, BeforeId continues a listing after its last entry
+type Filter struct {
+	UserId    string
+	Action    string
+	Actor     string
+	RequestId string
+	Since     time.Time
+	Until     time.Time
+	BeforeId  uint64
+	Limit     int
+}
+
+//List returns the entries of the audit log matching the filter, at most 500 at once
+func List(ctx context.Context, db *sql.DB, f Filter) ([]Entry, error) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if len(f.UserId) > 0 {
+		where("userid=$%d", f.UserId)
+	}
+	if len(f.Action) > 0 {
+		where("action=$%d", f.Action)
+	}
+	if len(f.Actor) > 0 {
+		where("actor=$%d", f.Actor)
+	}
+	if len(f.RequestId) > 0 {
+		where("requestid=$%d", f.RequestId)
+	}
+	if !f.Since.IsZero() {
+		where("created>=$%d", f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		where("created<$%d", f.Until.UTC())
+	}
+	if f.BeforeId > 0 {
+		where("auditid<$%d", f.BeforeId)
+	}
+	if f.Limit <= 0 || f.Limit > 500 {
+		f.Limit = 500
+	}
+	query := recordSelectStatement
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, f.Limit)
+	query += fmt.Sprintf(" ORDER BY auditid DESC LIMIT $%d", len(args))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]Entry, 0)
+	for rows.Next() {
+		var e Entry
+		var before, after []byte
+		if err := rows.Scan(&e.AuditId, &e.Created, &e.Action, &e.Actor, &e.RequestId, &e.ClientIP, &e.Reason, &e.UserId,
+			&e.Entity, &e.EntityId, &before, &after); err != nil {
+			return nil, err
+		}
+		e.Before, e.After = raw(before), raw(after)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+const (
+	recordInsertStatement = `INSERT INTO tbl_AuditLog(action, actor, requestid, clientip, reason, userid, entity, entityid, before, after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING auditid`
+	recordSelectStatement = `SELECT auditid, created, action, actor, COALESCE(requestid, ''), COALESCE(clientip, ''),
+		COALESCE(reason, ''), COALESCE(userid::TEXT, ''), COALESCE(entity, ''), COALESCE(entityid, 0), before, after
+		FROM tbl_AuditLog`
+)
+
+//function to encode a state as json, nil is stored as NULL
+func encode(state interface{}) (interface{}, error) {
+	if state == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode the audited state. %w", err)
+	}
+	return string(b), nil
+}
+
+//function to return a NULL state as json null
+func raw(b []byte) json.RawMessage {
+	if len(b) == 0 {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(b)
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: len(s) > 0}
+}
+
+func nullId(id uint64) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
@@ -0,0 +1,28 @@
+package audit
+
+import (
+	"context"
+	"testing"
+)
+
+//test case to verify the actor defaults to anonymous and states are encoded as json with nil kept as NULL
+func TestActorAndEncode(t *testing.T) {
+	if actor := Actor(context.Background()); actor != anonymous {
+		t.Errorf("Expected the anonymous actor. Got %s", actor)
+	}
+	if actor := Actor(WithActor(context.Background(), "apikey:ops")); actor != "apikey:ops" {
+		t.Errorf("Expected the actor of the context. Got %s", actor)
+	}
+	if state, err := encode(nil); state != nil || err != nil {
+		t.Errorf("Expected a nil state to be NULL. Got %v, %v", state, err)
+	}
+	state, err := encode(struct {
+		Amount float64 `json:"amount"`
+	}{12.5})
+	if err != nil || state != `{"amount":12.5}` {
+		t.Errorf("Expected the state as json. Got %v, %v", state, err)
+	}
+	if string(raw(nil)) != "null" {
+		t.Errorf("Expected a NULL state to be read as json null. Got %s", raw(nil))
+	}
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/a0rana/UserAccountService/audit"
+	"github.com/lib/pq"
+)
+
+//header carrying an api key, "Authorization: ApiKey <key>" is accepted as well
+const APIKeyHeader = "X-API-Key"
+
+//prefix of generated keys, makes them easy to spot in logs and secret scanners
+const apiKeyPrefix = "uas_"
+
+const (
+	apiKeySelectStatement = `SELECT name, scopes, COALESCE(userid::TEXT, '') FROM tbl_ApiKeys WHERE keyhash=$1 AND revoked IS NULL`
+	apiKeyInsertStatement = `INSERT INTO tbl_ApiKeys(name, keyhash, scopes, userid) VALUES ($1, $2, $3, $4) RETURNING keyid`
+	apiKeyRevokeStatement = `UPDATE tbl_ApiKeys SET revoked=(NOW() AT TIME ZONE 'UTC') WHERE keyid=$1 AND revoked IS NULL
+		RETURNING name, scopes, COALESCE(userid::TEXT, '')`
+)
+
+//APIKeys authenticates requests with the api keys stored in tbl_ApiKeys, only the sha256 hash of a key is stored
+type APIKeys struct {
+	DB *sql.DB
+}
+
+//Authenticate implements Authenticator
+func (a APIKeys) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if len(key) == 0 {
+		if scheme, credentials := authorization(r); strings.EqualFold(scheme, "ApiKey") {
+			key = credentials
+		}
+	}
+	if len(key) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	principal := &Principal{Method: MethodAPIKey}
+	err := a.DB.QueryRowContext(r.Context(), apiKeySelectStatement, hashAPIKey(key)).Scan(&principal.Subject,
+		pq.Array(&principal.Scopes), &principal.UserId)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	//keys stored before user-scoped admin keys were refused are rejected as well
+	if err = CheckUserScopes(principal.Scopes, principal.UserId); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	return principal, nil
+}
+
+//apiKeyState is the state of an api key recorded in the audit log, never its hash
+type apiKeyState struct {
+	Name    string   `json:"name"`
+	Scopes  []string `json:"scopes"`
+	UserId  string   `json:"userid,omitempty"`
+	Revoked bool     `json:"revoked"`
+}
+
+//CreateAPIKey generates a new api key with the given name and scopes and stores its hash, a non empty userid makes
+//the key user-scoped. the key itself is returned only here and cannot be recovered later. the creation is recorded
+//in the audit log with the actor of ctx
+func CreateAPIKey(ctx context.Context, db *sql.DB, name string, scopes []string, userid string) (uint64, string, error) {
+	if len(name) == 0 {
+		return 0, "", errors.New("an api key needs a name")
+	}
+	if len(scopes) == 0 {
+		return 0, "", errors.New("an api key needs at least one scope")
+	}
+	for _, scope := range scopes {
+		if !ValidScope(scope) {
+			return 0, "", fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	if err := CheckUserScopes(scopes, userid); err != nil {
+		return 0, "", err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return 0, "", err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	var id uint64
+	owner := sql.NullString{String: userid, Valid: len(userid) > 0}
+	if err := tx.QueryRowContext(ctx, apiKeyInsertStatement, name, hashAPIKey(key), pq.Array(scopes), owner).Scan(&id); err != nil {
+		return 0, "", err
+	}
+	_, err = audit.Record(ctx, tx, audit.Event{Action: audit.ActionAPIKeyCreate, UserId: userid, Entity: audit.EntityAPIKey,
+		EntityId: id, After: apiKeyState{Name: name, Scopes: scopes, UserId: userid}})
+	if err != nil {
+		return 0, "", err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, "", err
+	}
+	return id, key, nil
+}
+
+//RevokeAPIKey revokes the api key with the given id, requests using it are rejected from then on. the revocation is
+//recorded in the audit log with the actor of ctx and reason
+func RevokeAPIKey(ctx context.Context, db *sql.DB, id uint64, reason string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var state apiKeyState
+	err = tx.QueryRowContext(ctx, apiKeyRevokeStatement, id).Scan(&state.Name, pq.Array(&state.Scopes), &state.UserId)
+	if err == sql.ErrNoRows {
+		return errors.New("cannot find an active api key with the given id")
+	}
+	if err != nil {
+		return err
+	}
+	revoked := state
+	revoked.Revoked = true
+	_, err = audit.Record(ctx, tx, audit.Event{Action: audit.ActionAPIKeyRevoke, UserId: state.UserId, Entity: audit.EntityAPIKey,
+		EntityId: id, Reason: reason, Before: state, After: revoked})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//function to hash an api key the way it is stored
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+//function to split the Authorization header into its scheme and credentials
+func authorization(r *http.Request) (string, string) {
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+	if i := strings.IndexByte(header, ' '); i > 0 {
+		return header[:i], strings.TrimSpace(header[i+1:])
+	}
+	return header, ""
+}
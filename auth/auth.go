@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+//authentication methods of a principal
+const (
+	MethodAPIKey = "apikey"
+	MethodJWT    = "jwt"
+)
+
+//scopes granted to a principal, ScopeAdmin implies every other scope
+const (
+	ScopeRead   = "ledger:read"
+	ScopeDebit  = "ledger:debit"
+	ScopeCredit = "ledger:credit"
+	ScopeAdmin  = "ledger:admin"
+)
+
+var (
+	//returned when the request carries no credentials an authenticator understands
+	ErrNoCredentials = errors.New("no credentials provided")
+	//returned when the credentials are malformed, unknown, revoked or expired
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+//Principal is the authenticated caller of a request
+type Principal struct {
+	//name of the api key or subject of the token
+	Subject string
+	//how the caller authenticated, MethodAPIKey or MethodJWT
+	Method string
+	//operations the caller is allowed to perform
+	Scopes []string
+	//set for user-scoped credentials, e.g. those of a customer-facing app, which may only act on this user
+	UserId string
+}
+
+//HasScope reports whether the principal was granted scope, directly or through ScopeAdmin
+func (p *Principal) HasScope(scope string) bool {
+	for _, granted := range p.Scopes {
+		if granted == scope || granted == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+//CanAccessUser reports whether the principal may act on the given user, always true unless it is user-scoped
+func (p *Principal) CanAccessUser(userid string) bool {
+	return len(p.UserId) == 0 || p.UserId == userid
+}
+
+//ErrUserScopedAdmin is returned for credentials restricted to a user that grant ScopeAdmin, the admin routes act on
+//any user so the admin scope is never granted to user-scoped credentials
+var ErrUserScopedAdmin = errors.New("the ledger:admin scope cannot be granted to user-scoped credentials")
+
+//CheckUserScopes returns ErrUserScopedAdmin when scopes include ScopeAdmin and userid restricts them to a user
+func CheckUserScopes(scopes []string, userid string) error {
+	if len(userid) == 0 {
+		return nil
+	}
+	for _, scope := range scopes {
+		if scope == ScopeAdmin {
+			return ErrUserScopedAdmin
+		}
+	}
+	return nil
+}
+
+//ValidScope reports whether scope is one of the known scopes
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeRead, ScopeDebit, ScopeCredit, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+//Authenticator finds the principal of a request. it returns ErrNoCredentials when the request carries none of the
+//credentials it understands, ErrInvalidCredentials when they are rejected and any other error when they could not
+//be checked, e.g. the database is unreachable
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+//Chain tries the authenticators in order and returns the principal of the first one finding credentials
+type Chain []Authenticator
+
+//Authenticate implements Authenticator
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range c {
+		principal, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return principal, err
+	}
+	return nil, ErrNoCredentials
+}
+
+type contextKey int
+
+const principalKey contextKey = iota
+
+//WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+//FromContext returns the principal of the request ctx belongs to, if it was authenticated
+func FromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey).(*Principal)
+	return principal, ok && principal != nil
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+//function to build a request carrying the given Authorization header
+func request(authorization string) *http.Request {
+	r, _ := http.NewRequest("GET", "/transactions", nil)
+	if len(authorization) > 0 {
+		r.Header.Set("Authorization", authorization)
+	}
+	return r
+}
+
+//test case to verify that HS256 and RS256 tokens signed with the configured keys authenticate their subject
+func TestJWTAuthenticate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	publicKey, err := ParseRSAPublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := JWT{HMACSecret: secret, RSAPublicKey: publicKey, Issuer: "issuer", Audience: "ledger"}
+	claims := Claims{Subject: "ops", Issuer: "issuer", Audience: Audience{"ledger"}, ExpiresAt: time.Now().Add(time.Minute).Unix()}
+
+	hs, _ := SignHS256(claims, secret)
+	rs, _ := SignRS256(claims, key)
+	for _, token := range []string{hs, rs} {
+		principal, err := j.Authenticate(request("Bearer " + token))
+		if err != nil || principal.Subject != "ops" || principal.Method != MethodJWT {
+			t.Errorf("Expected token to authenticate ops. Got %+v, %v", principal, err)
+		}
+	}
+
+	//a token signed with HS256 using the public key as secret must not pass as RS256 key holder
+	onlyRSA := JWT{RSAPublicKey: publicKey}
+	confused, _ := SignHS256(claims, der)
+	if _, err := onlyRSA.Authenticate(request("Bearer " + confused)); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected HS256 token to be rejected when only RS256 is configured. Got %v", err)
+	}
+}
+
+//test case to verify that tokens with a bad signature, unsupported algorithm or invalid claims are rejected
+func TestJWTRejectsInvalidTokens(t *testing.T) {
+	now := time.Date(2021, 2, 4, 12, 0, 0, 0, time.UTC)
+	j := JWT{HMACSecret: secret, Audience: "ledger", Leeway: time.Second, Now: func() time.Time { return now }}
+	valid := Claims{Subject: "ops", Audience: Audience{"ledger"}, ExpiresAt: now.Add(time.Minute).Unix()}
+
+	sign := func(claims Claims, key []byte) string {
+		token, _ := SignHS256(claims, key)
+		return token
+	}
+	expired, noExpiry, future, otherAudience := valid, valid, valid, valid
+	expired.ExpiresAt = now.Add(-time.Minute).Unix()
+	noExpiry.ExpiresAt = 0
+	future.NotBefore = now.Add(time.Minute).Unix()
+	otherAudience.Audience = Audience{"billing"}
+	unsigned, _ := encodeUnsigned("none", valid)
+
+	for name, token := range map[string]string{
+		"bad signature": sign(valid, []byte("another secret of thirty two chars")),
+		"expired":       sign(expired, secret),
+		"no expiry":     sign(noExpiry, secret),
+		"not before":    sign(future, secret),
+		"audience":      sign(otherAudience, secret),
+		"unsigned":      unsigned + ".",
+		"malformed":     "not-a-token",
+	} {
+		if _, err := j.Authenticate(request("Bearer " + token)); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Expected %s token to be rejected. Got %v", name, err)
+		}
+	}
+	if principal, err := j.Authenticate(request("Bearer " + sign(valid, secret))); err != nil || principal.Subject != "ops" {
+		t.Errorf("Expected valid token to be accepted. Got %+v, %v", principal, err)
+	}
+}
+
+//test case to verify that a chain skips authenticators finding no credentials and reports when none found any
+func TestChainWithoutCredentials(t *testing.T) {
+	chain := Chain{APIKeys{}, JWT{HMACSecret: secret}}
+	if _, err := chain.Authenticate(request("")); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Expected ErrNoCredentials. Got %v", err)
+	}
+	if _, err := chain.Authenticate(request("Basic dXNlcjpwYXNz")); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Expected ErrNoCredentials for an unsupported scheme. Got %v", err)
+	}
+	if _, err := chain.Authenticate(request("Bearer x.y.z")); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials for a malformed token. Got %v", err)
+	}
+}
+
+//test case to verify that the admin scope implies the others and user-scoped principals only reach their own user
+func TestPrincipalScopes(t *testing.T) {
+	app := &Principal{Scopes: []string{ScopeRead, ScopeDebit}, UserId: "user-1"}
+	admin := &Principal{Scopes: []string{ScopeAdmin}}
+	if !app.HasScope(ScopeDebit) || app.HasScope(ScopeCredit) || !admin.HasScope(ScopeCredit) {
+		t.Errorf("Unexpected scopes. App %+v, admin %+v", app, admin)
+	}
+	if !app.CanAccessUser("user-1") || app.CanAccessUser("user-2") || !admin.CanAccessUser("user-2") {
+		t.Errorf("Unexpected user access. App %+v, admin %+v", app, admin)
+	}
+
+	j := JWT{HMACSecret: secret}
+	token, _ := SignHS256(Claims{Subject: "app", Scope: "ledger:read ledger:debit", UserId: "user-1", ExpiresAt: time.Now().Add(time.Minute).Unix()}, secret)
+	principal, err := j.Authenticate(request("Bearer " + token))
+	if err != nil || len(principal.Scopes) != 2 || principal.UserId != "user-1" {
+		t.Errorf("Expected scopes and user of the token. Got %+v, %v", principal, err)
+	}
+
+	//the admin routes act on any user, so a token restricted to a user cannot carry the admin scope
+	token, _ = SignHS256(Claims{Subject: "app", Scope: "ledger:read ledger:admin", UserId: "user-1", ExpiresAt: time.Now().Add(time.Minute).Unix()}, secret)
+	if _, err = j.Authenticate(request("Bearer " + token)); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected a user-scoped admin token to be rejected. Got %v", err)
+	}
+	if err = CheckUserScopes([]string{ScopeAdmin}, "user-1"); !errors.Is(err, ErrUserScopedAdmin) || CheckUserScopes([]string{ScopeAdmin}, "") != nil {
+		t.Errorf("Expected only user-scoped admin credentials to be refused. Got %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//signing algorithms supported for bearer tokens
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+)
+
+//Claims are the claims of a bearer token checked by JWT: the registered ones, the space separated scopes granted
+//to the token and, for user-scoped tokens, the user the token may act on
+type Claims struct {
+	Subject   string   `json:"sub,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	UserId    string   `json:"userid,omitempty"`
+}
+
+//Audience is the aud claim, a single string or a list of strings in the token
+type Audience []string
+
+//UnmarshalJSON accepts both forms of the aud claim
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+//JWT authenticates requests with "Authorization: Bearer <token>". a token is accepted when it is signed with
+//HS256 by HMACSecret or with RS256 by the private key of RSAPublicKey, a key that is not configured disables its
+//algorithm. tokens must expire and Issuer and Audience, when set, must match their claims
+type JWT struct {
+	HMACSecret   []byte
+	RSAPublicKey *rsa.PublicKey
+	Issuer       string
+	Audience     string
+	//tolerated clock skew when checking exp and nbf
+	Leeway time.Duration
+	//returns the current time, time.Now when nil
+	Now func() time.Time
+}
+
+//Authenticate implements Authenticator
+func (j JWT) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, token := authorization(r)
+	if !strings.EqualFold(scheme, "Bearer") || len(token) == 0 {
+		return nil, ErrNoCredentials
+	}
+	claims, err := j.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	scopes := strings.Fields(claims.Scope)
+	if err = CheckUserScopes(scopes, claims.UserId); err != nil {
+		return nil, invalid(err.Error())
+	}
+	return &Principal{Subject: claims.Subject, Method: MethodJWT, Scopes: scopes, UserId: claims.UserId}, nil
+}
+
+//Verify checks the signature and the claims of token and returns its claims
+func (j JWT) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalid("token must have three parts")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, invalid("malformed header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalid("malformed signature")
+	}
+
+	//the algorithm named by the token must be one a key is configured for, so an RS256 public key can never be
+	//used as an HS256 secret and unsigned tokens are never accepted
+	signed := []byte(parts[0] + "." + parts[1])
+	switch {
+	case header.Alg == AlgHS256 && len(j.HMACSecret) > 0:
+		if !hmac.Equal(signature, signHMAC(signed, j.HMACSecret)) {
+			return nil, invalid("signature does not match")
+		}
+	case header.Alg == AlgRS256 && j.RSAPublicKey != nil:
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(j.RSAPublicKey, crypto.SHA256, digest[:], signature) != nil {
+			return nil, invalid("signature does not match")
+		}
+	default:
+		return nil, invalid(fmt.Sprintf("unsupported algorithm %q", header.Alg))
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, invalid("malformed claims")
+	}
+	now := time.Now()
+	if j.Now != nil {
+		now = j.Now()
+	}
+	switch {
+	case claims.ExpiresAt == 0:
+		return nil, invalid("token does not expire")
+	case now.After(time.Unix(claims.ExpiresAt, 0).Add(j.Leeway)):
+		return nil, invalid("token has expired")
+	case claims.NotBefore != 0 && now.Add(j.Leeway).Before(time.Unix(claims.NotBefore, 0)):
+		return nil, invalid("token is not valid yet")
+	case len(j.Issuer) > 0 && claims.Issuer != j.Issuer:
+		return nil, invalid("unexpected issuer")
+	case len(j.Audience) > 0 && !claims.Audience.contains(j.Audience):
+		return nil, invalid("unexpected audience")
+	case len(claims.Subject) == 0:
+		return nil, invalid("token has no subject")
+	}
+	return &claims, nil
+}
+
+//SignHS256 issues a token with the given claims signed by secret, meant for tests and tooling
+func SignHS256(claims interface{}, secret []byte) (string, error) {
+	signed, err := encodeUnsigned(AlgHS256, claims)
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signHMAC([]byte(signed), secret)), nil
+}
+
+//SignRS256 issues a token with the given claims signed by key, meant for tests and tooling
+func SignRS256(claims interface{}, key *rsa.PrivateKey) (string, error) {
+	signed, err := encodeUnsigned(AlgRS256, claims)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+//ParseRSAPublicKey reads an RSA public key in PEM format, PKIX("PUBLIC KEY") or PKCS #1("RSA PUBLIC KEY")
+func ParseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+func (a Audience) contains(audience string) bool {
+	for _, aud := range a {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+//function to wrap the reason a token was rejected into ErrInvalidCredentials
+func invalid(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidCredentials, reason)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func encodeUnsigned(alg string, claims interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload), nil
+}
+
+func signHMAC(signed []byte, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(signed)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/allegro/bigcache"
+)
+
+//BigCache is a process local cache, suited for a single replica as invalidations are not shared across processes.
+//bigcache evicts every entry after the same life window, so shorter lifetimes are enforced by storing
+//the expiry time in front of each value
+type BigCache struct {
+	cache *bigcache.BigCache
+	ttl   time.Duration
+
+	//generations are kept outside of bigcache as they must not be evicted while entries of an older generation may
+	//still be read. a generation not bumped for ttl only has expired entries behind it, so it is forgotten
+	mu          sync.Mutex
+	generations map[string]generation
+	swept       time.Time
+	now         func() time.Time
+}
+
+//last generation handed out by any BigCache of the process. generations are drawn from it rather than counted per
+//namespace, so that a namespace forgotten by the sweep never gets back a generation whose entries may still be live
+var lastGeneration uint64
+
+//generation of a namespace and when it was last bumped
+type generation struct {
+	value       uint64
+	invalidated time.Time
+}
+
+//NewBigCache creates a process local cache whose entries live for ttl
+func NewBigCache(ttl time.Duration) (*BigCache, error) {
+	c, err := bigcache.NewBigCache(bigcache.DefaultConfig(ttl))
+	if err != nil {
+		return nil, err
+	}
+	return &BigCache{cache: c, ttl: ttl, generations: make(map[string]generation), swept: time.Now(), now: time.Now}, nil
+}
+
+func (b *BigCache) Get(key string) ([]byte, error) {
+	entry, err := b.cache.Get(key)
+	if err == bigcache.ErrEntryNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(entry) < 8 || time.Now().UnixNano() >= int64(binary.BigEndian.Uint64(entry)) {
+		return nil, ErrNotFound
+	}
+	return entry[8:], nil
+}
+
+func (b *BigCache) Set(key string, value []byte, ttl time.Duration) error {
+	if ttl <= 0 || ttl > b.ttl {
+		ttl = b.ttl
+	}
+	entry := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(entry, uint64(time.Now().Add(ttl).UnixNano()))
+	copy(entry[8:], value)
+	return b.cache.Set(key, entry)
+}
+
+func (b *BigCache) Delete(key string) error {
+	err := b.cache.Delete(key)
+	if err == bigcache.ErrEntryNotFound {
+		return nil
+	}
+	return err
+}
+
+func (b *BigCache) Generation(namespace string) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.generations[namespace].value, nil
+}
+
+func (b *BigCache) Invalidate(namespace string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	b.sweep(now)
+	b.generations[namespace] = generation{value: atomic.AddUint64(&lastGeneration, 1), invalidated: now}
+	return nil
+}
+
+//function to forget the generations not bumped for ttl, at most once per ttl, so that the map only holds the
+//namespaces invalidated lately. the namespace reads generation 0 again, whose entries were written before its first
+//invalidation and have all expired, and its next invalidation draws a generation never used before
+func (b *BigCache) sweep(now time.Time) {
+	if now.Sub(b.swept) < b.ttl {
+		return
+	}
+	for namespace, g := range b.generations {
+		if now.Sub(g.invalidated) >= b.ttl {
+			delete(b.generations, namespace)
+		}
+	}
+	b.swept = now
+}
+
+func (b *BigCache) Close() error {
+	return b.cache.Close()
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+//backends supported by New
+const (
+	BackendBigCache = "bigcache"
+	BackendRedis    = "redis"
+	BackendNone     = "none"
+)
+
+//ErrNotFound is returned by Get when the key is not present in the cache
+var ErrNotFound = errors.New("cache: key not found")
+
+//Cache is implemented by every cache backend used for caching responses.
+//entries are grouped in namespaces(the user id), invalidating a namespace bumps its generation so that
+//keys built with the previous generation are never read again.
+//Set stores the entry for ttl, a ttl of zero or above the backend TTL falls back to the backend TTL
+type Cache interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+	Generation(namespace string) (uint64, error)
+	Invalidate(namespace string) error
+	Close() error
+}
+
+//Options used to select and configure the cache backend, TTL is the longest lifetime of an entry
+type Options struct {
+	Backend       string
+	TTL           time.Duration
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+}
+
+//New creates the cache backend selected in options, bigcache is used when no backend is given
+func New(opts Options) (Cache, error) {
+	if opts.TTL <= 0 {
+		opts.TTL = 10 * time.Minute
+	}
+	switch opts.Backend {
+	case "", BackendBigCache:
+		return NewBigCache(opts.TTL)
+	case BackendRedis:
+		if len(opts.RedisAddr) == 0 {
+			return nil, errors.New("cache: redis backend requires an address")
+		}
+		return NewRedis(opts.RedisAddr, opts.RedisPassword, opts.RedisDB, opts.TTL), nil
+	case BackendNone:
+		return NewNoOp(), nil
+	}
+	return nil, fmt.Errorf("cache: unknown backend %q", opts.Backend)
+}
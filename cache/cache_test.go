@@ -0,0 +1,324 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//test case to verify backend selection from options
+func TestNewSelectsBackend(t *testing.T) {
+	if c, err := New(Options{}); err != nil {
+		t.Fatal(err)
+	} else if _, ok := c.(*BigCache); !ok {
+		t.Errorf("Expected bigcache to be the default backend. Got %T", c)
+	}
+	if c, _ := New(Options{Backend: BackendNone}); c != NewNoOp() {
+		t.Errorf("Expected no-op backend. Got %T", c)
+	}
+	if _, err := New(Options{Backend: BackendRedis}); err == nil {
+		t.Error("Expected an error for redis backend without address")
+	}
+	if _, err := New(Options{Backend: "memcached"}); err == nil {
+		t.Error("Expected an error for an unknown backend")
+	}
+}
+
+//test case to verify the behaviour shared by all backends storing entries
+func TestBackends(t *testing.T) {
+	local, err := NewBigCache(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newFakeRedis(t)
+	backends := map[string]Cache{
+		"bigcache": local,
+		"redis":    NewRedis(server.addr, "", 0, time.Minute),
+	}
+	for name, c := range backends {
+		t.Run(name, func(t *testing.T) {
+			defer c.Close()
+			if _, err := c.Get("missing"); err != ErrNotFound {
+				t.Errorf("Expected ErrNotFound. Got %v", err)
+			}
+			if err := c.Set("key", []byte("value"), 0); err != nil {
+				t.Fatal(err)
+			}
+			if entry, err := c.Get("key"); err != nil || string(entry) != "value" {
+				t.Errorf("Expected stored value. Got %q, %v", entry, err)
+			}
+			if err := c.Delete("key"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.Get("key"); err != ErrNotFound {
+				t.Errorf("Expected ErrNotFound after delete. Got %v", err)
+			}
+			if err := c.Delete("key"); err != nil {
+				t.Errorf("Expected deleting a missing key to succeed. Got %v", err)
+			}
+			if generation, err := c.Generation("user"); err != nil || generation != 0 {
+				t.Errorf("Expected generation 0 before any invalidation. Got %d, %v", generation, err)
+			}
+			var previous uint64
+			for i := 0; i < 3; i++ {
+				if err := c.Invalidate("user"); err != nil {
+					t.Fatal(err)
+				}
+				if generation, err := c.Generation("user"); err != nil || generation <= previous {
+					t.Errorf("Expected the generation to move past %d. Got %d, %v", previous, generation, err)
+				} else {
+					previous = generation
+				}
+			}
+		})
+	}
+}
+
+//test case to verify that bigcache honours a ttl shorter than its life window
+func TestBigCacheEntryTTL(t *testing.T) {
+	c, err := NewBigCache(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Set("short", []byte("value"), time.Millisecond)
+	c.Set("long", []byte("value"), time.Hour)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := c.Get("short"); err != ErrNotFound {
+		t.Errorf("Expected short lived entry to expire. Got %v", err)
+	}
+	if _, err := c.Get("long"); err != nil {
+		t.Errorf("Expected entry with ttl above the life window to be kept. Got %v", err)
+	}
+}
+
+//test case to verify that generations not bumped for the ttl are forgotten while the recent ones are kept
+func TestBigCacheForgetsGenerations(t *testing.T) {
+	c, err := NewBigCache(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 100; i++ {
+		c.Invalidate(fmt.Sprint("user-", i))
+	}
+	now = now.Add(50 * time.Second)
+	c.Invalidate("user-0")
+	now = now.Add(20 * time.Second)
+	c.Invalidate("user-100")
+	if len(c.generations) != 2 {
+		t.Errorf("Expected only the namespaces invalidated within the ttl to be kept. Got %d", len(c.generations))
+	}
+	if generation, _ := c.Generation("user-0"); generation != lastGeneration-1 {
+		t.Errorf("Expected the recent generation to be kept. Got %d", generation)
+	}
+	if generation, _ := c.Generation("user-1"); generation != 0 {
+		t.Errorf("Expected the old generation to be forgotten. Got %d", generation)
+	}
+}
+
+//test case to verify that an entry written under a generation is not served again once its namespace has been
+//forgotten by the sweep and invalidated anew
+func TestBigCacheSweptGenerationNotReused(t *testing.T) {
+	c, err := NewBigCache(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Invalidate("user")
+	stale, _ := c.Generation("user")
+	c.Set(fmt.Sprint("user:", stale), []byte("stale history"), 0)
+
+	//the entry was written just before the sweep and is still live after it
+	now = now.Add(time.Hour)
+	c.Invalidate("other")
+	if _, ok := c.generations["user"]; ok {
+		t.Fatal("Expected the namespace to be swept")
+	}
+	c.Invalidate("user")
+	generation, _ := c.Generation("user")
+	if generation == stale {
+		t.Errorf("Expected a new generation after the sweep. Got %d again", generation)
+	}
+	if entry, err := c.Get(fmt.Sprint("user:", generation)); err != ErrNotFound {
+		t.Errorf("Expected no entry under the new generation. Got %q, %v", entry, err)
+	}
+}
+
+//test case to verify that redis receives the entry ttl, capped to the backend ttl
+func TestRedisEntryTTL(t *testing.T) {
+	server := newFakeRedis(t)
+	c := NewRedis(server.addr, "", 0, time.Minute)
+	defer c.Close()
+
+	c.Set("short", []byte("value"), 30*time.Second)
+	c.Set("long", []byte("value"), time.Hour)
+	if server.ttl("short") != "30000" || server.ttl("long") != "60000" {
+		t.Errorf("Expected ttl of 30000ms and 60000ms. Got %s and %s", server.ttl("short"), server.ttl("long"))
+	}
+}
+
+//test case to verify that an invalidation made by one replica is seen by another one sharing the same redis
+func TestRedisCrossReplicaInvalidation(t *testing.T) {
+	server := newFakeRedis(t)
+	first := NewRedis(server.addr, "secret", 2, time.Minute)
+	second := NewRedis(server.addr, "secret", 2, time.Minute)
+	defer first.Close()
+	defer second.Close()
+
+	if err := first.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Invalidate("user"); err != nil {
+		t.Fatal(err)
+	}
+	if generation, err := first.Generation("user"); err != nil || generation != 1 {
+		t.Errorf("Expected generation bumped by the other replica. Got %d, %v", generation, err)
+	}
+	if server.commands["AUTH"] == 0 || server.commands["SELECT"] == 0 {
+		t.Errorf("Expected AUTH and SELECT on new connections. Got %v", server.commands)
+	}
+}
+
+//test case to verify that error replies are surfaced and the connection is reused afterwards
+func TestRedisErrorReply(t *testing.T) {
+	server := newFakeRedis(t)
+	c := NewRedis(server.addr, "", 0, time.Minute)
+	defer c.Close()
+
+	if _, err := c.do("UNKNOWN"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("Expected unknown command error. Got %v", err)
+	}
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if server.connections != 1 {
+		t.Errorf("Expected a single pooled connection. Got %d", server.connections)
+	}
+}
+
+//test case to verify that a reply of an unexpected type is returned as an error instead of panicking
+func TestRedisUnexpectedReply(t *testing.T) {
+	server := newFakeRedis(t)
+	c := NewRedis(server.addr, "", 0, time.Minute)
+	defer c.Close()
+
+	server.replies["entry"] = ":1\r\n"
+	server.replies[generationPrefix+"user"] = "+OK\r\n"
+	if _, err := c.Get("entry"); err == nil || err == ErrNotFound {
+		t.Errorf("Expected an error for an integer reply to GET. Got %v", err)
+	}
+	if _, err := c.Generation("user"); err == nil {
+		t.Error("Expected an error for a status reply to the generation GET")
+	}
+}
+
+//----------------------------- helper methods ------------------------------------
+//fakeRedis is an in-process server implementing the subset of the redis protocol used by the cache
+type fakeRedis struct {
+	addr        string
+	mu          sync.Mutex
+	data        map[string]string
+	ttls        map[string]string
+	replies     map[string]string
+	commands    map[string]int
+	connections int
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	f := &fakeRedis{addr: listener.Addr().String(), data: make(map[string]string), ttls: make(map[string]string), replies: make(map[string]string),
+		commands: make(map[string]int)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.connections++
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) ttl(key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ttls[key]
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		items := reply.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i] = string(item.([]byte))
+		}
+		io.WriteString(conn, f.execute(args))
+	}
+}
+
+func (f *fakeRedis) execute(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands[args[0]]++
+	switch args[0] {
+	case "PING":
+		return "+PONG\r\n"
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		if reply, ok := f.replies[args[1]]; ok {
+			return reply
+		}
+		value, ok := f.data[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	case "SET":
+		f.data[args[1]] = args[2]
+		if len(args) == 5 && args[3] == "PX" {
+			f.ttls[args[1]] = args[4]
+		}
+		return "+OK\r\n"
+	case "DEL":
+		_, ok := f.data[args[1]]
+		delete(f.data, args[1])
+		if ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "INCR":
+		value, _ := strconv.ParseInt(f.data[args[1]], 10, 64)
+		f.data[args[1]] = strconv.FormatInt(value+1, 10)
+		return fmt.Sprintf(":%d\r\n", value+1)
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
@@ -0,0 +1,35 @@
+package cache
+
+import "time"
+
+//NoOp disables caching, every read is a miss
+type NoOp struct{}
+
+//NewNoOp creates a cache that never stores anything
+func NewNoOp() NoOp {
+	return NoOp{}
+}
+
+func (NoOp) Get(key string) ([]byte, error) {
+	return nil, ErrNotFound
+}
+
+func (NoOp) Set(key string, value []byte, ttl time.Duration) error {
+	return nil
+}
+
+func (NoOp) Delete(key string) error {
+	return nil
+}
+
+func (NoOp) Generation(namespace string) (uint64, error) {
+	return 0, nil
+}
+
+func (NoOp) Invalidate(namespace string) error {
+	return nil
+}
+
+func (NoOp) Close() error {
+	return nil
+}
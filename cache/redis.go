@@ -0,0 +1,230 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//prefix of the keys holding namespace generations in redis
+const generationPrefix = "gen:"
+
+//Redis is a cache shared by all replicas speaking the redis protocol(RESP), generations live in redis as well
+//so an invalidation made by one replica is seen by every other replica on its next read
+type Redis struct {
+	addr     string
+	password string
+	db       int
+	ttl      time.Duration
+	timeout  time.Duration
+	pool     chan *redisConn
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+//redisError is an error reply sent by the server, the connection stays usable after it
+type redisError string
+
+func (e redisError) Error() string {
+	return "cache: redis: " + string(e)
+}
+
+//NewRedis creates a redis backed cache, connections are dialed lazily and reused
+func NewRedis(addr string, password string, db int, ttl time.Duration) *Redis {
+	return &Redis{
+		addr:     addr,
+		password: password,
+		db:       db,
+		ttl:      ttl,
+		timeout:  time.Second,
+		pool:     make(chan *redisConn, 16),
+	}
+}
+
+func (r *Redis) Get(key string) ([]byte, error) {
+	reply, err := r.do("GET", key)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, ErrNotFound
+	}
+	return bulkString("GET", reply)
+}
+
+func (r *Redis) Set(key string, value []byte, ttl time.Duration) error {
+	if ttl <= 0 || ttl > r.ttl {
+		ttl = r.ttl
+	}
+	_, err := r.do("SET", key, string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+func (r *Redis) Delete(key string) error {
+	_, err := r.do("DEL", key)
+	return err
+}
+
+func (r *Redis) Generation(namespace string) (uint64, error) {
+	reply, err := r.do("GET", generationPrefix+namespace)
+	if err != nil || reply == nil {
+		return 0, err
+	}
+	value, err := bulkString("GET", reply)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(value), 10, 64)
+}
+
+func (r *Redis) Invalidate(namespace string) error {
+	_, err := r.do("INCR", generationPrefix+namespace)
+	return err
+}
+
+//Ping checks that the redis server is reachable
+func (r *Redis) Ping() error {
+	_, err := r.do("PING")
+	return err
+}
+
+func (r *Redis) Close() error {
+	for {
+		select {
+		case c := <-r.pool:
+			c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+//function to send a single command and read its reply, broken connections are dropped instead of returned to the pool
+func (r *Redis) do(args ...string) (interface{}, error) {
+	c, err := r.get()
+	if err != nil {
+		return nil, err
+	}
+	c.conn.SetDeadline(time.Now().Add(r.timeout))
+	reply, err := c.command(args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		c.conn.Close()
+		return nil, err
+	}
+	r.put(c)
+	return reply, err
+}
+
+func (r *Redis) get() (*redisConn, error) {
+	select {
+	case c := <-r.pool:
+		return c, nil
+	default:
+	}
+	conn, err := net.DialTimeout("tcp", r.addr, r.timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	c.conn.SetDeadline(time.Now().Add(r.timeout))
+	if len(r.password) > 0 {
+		if _, err = c.command("AUTH", r.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if r.db != 0 {
+		if _, err = c.command("SELECT", strconv.Itoa(r.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (r *Redis) put(c *redisConn) {
+	select {
+	case r.pool <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+//function to write a command as an array of bulk strings and read the reply
+func (c *redisConn) command(args ...string) (interface{}, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+//function to check that the reply to command is a bulk string, any other reply type is an error rather than a panic
+func bulkString(command string, reply interface{}) ([]byte, error) {
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("cache: redis: unexpected %T reply to %s", reply, command)
+	}
+	return value, nil
+}
+
+//function to read a single RESP reply, bulk strings are returned as []byte and a nil bulk string as nil
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("cache: redis: malformed reply")
+	}
+	payload := line[1 : len(line)-2]
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:size], nil
+	case '*':
+		count, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("cache: redis: unexpected reply type %q", line[0])
+}
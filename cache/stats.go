@@ -0,0 +1,39 @@
+package cache
+
+import "sync/atomic"
+
+//Stats counts the outcome of cache reads, it is safe for concurrent use.
+//a corrupt entry is counted both as corrupt and as a miss since it is served from the database
+type Stats struct {
+	hits    uint64
+	misses  uint64
+	corrupt uint64
+}
+
+//StatsSnapshot is a point in time copy of Stats
+type StatsSnapshot struct {
+	Hits    uint64
+	Misses  uint64
+	Corrupt uint64
+}
+
+func (s *Stats) Hit() {
+	atomic.AddUint64(&s.hits, 1)
+}
+
+func (s *Stats) Miss() {
+	atomic.AddUint64(&s.misses, 1)
+}
+
+func (s *Stats) Corrupt() {
+	atomic.AddUint64(&s.corrupt, 1)
+	atomic.AddUint64(&s.misses, 1)
+}
+
+func (s *Stats) Snapshot() StatsSnapshot {
+	return StatsSnapshot{
+		Hits:    atomic.LoadUint64(&s.hits),
+		Misses:  atomic.LoadUint64(&s.misses),
+		Corrupt: atomic.LoadUint64(&s.corrupt),
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"github.com/a0rana/UserAccountService/config"
+	"github.com/a0rana/UserAccountService/database"
+	"github.com/a0rana/UserAccountService/migrations"
+	"github.com/a0rana/UserAccountService/scheduledjob"
+	"log"
+	"os"
+)
+
+//standalone credit expiry job, for deployments not running the job inside the service(EXPIRY_JOB_ENABLED=false)
+func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = cfg.InitLogger(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := database.Open(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if err = migrations.Check(context.Background(), db); err != nil {
+		log.Fatal(err)
+	}
+
+	<-scheduledjob.Start(db, cfg.ExpiryJob.Interval)
+}
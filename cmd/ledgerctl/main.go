@@ -0,0 +1,580 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/a0rana/UserAccountService/audit"
+	"github.com/a0rana/UserAccountService/auth"
+	"github.com/a0rana/UserAccountService/cache"
+	"github.com/a0rana/UserAccountService/config"
+	"github.com/a0rana/UserAccountService/database"
+	"github.com/a0rana/UserAccountService/journal"
+	"github.com/a0rana/UserAccountService/middleware"
+	"github.com/a0rana/UserAccountService/migrations"
+	"github.com/a0rana/UserAccountService/models"
+	"github.com/a0rana/UserAccountService/scheduledjob"
+)
+
+//usage of ledgerctl, configuration flags(see config) come before the command, ledgerctl flags after it
+const usage = `usage: ledgerctl [config flags] <command> [-json] [-dry-run] [command flags] [arguments]
+
+commands:
+  balance <userid>                                  show the balance and the credits of a user
+  activity [-limit n] [-offset n] <userid>          list the activity of a user
+  credit -expiry <rfc3339> [-type t] [-priority n] [-categories c1,c2] [-reason text] <userid> <amount>
+                                                    grant a credit to a user, -categories restricts where it can
+                                                    be spent
+  debit [-category c] [-merchant m] [-reason text] <userid> <amount>
+                                                    debit a user
+  reverse [-reason text] <userid> <tranid>          reverse a debit of a user
+  status -reason text <userid> <status>             set the account status of a user to active, frozen or closed
+  overdraft -reason text <userid> <limit>           set how far below zero a user may go, 0 removes the overdraft
+  expire                                            run the credit expiry job once
+  export <userid>                                   export the credits and activity of a user as json
+  reconcile [-correct -reason text [-actor name]]   report the credits whose amount does not match their activity,
+                                                    -correct sets them to the amount recomputed from the activity
+  trial-balance                                     show the total debits and credits of every journal account
+  verify-chain <userid>                             verify the hash chain of the activity of a user, exits with an
+                                                    error when the chain is broken
+  apikey create -scopes s1,s2 [-userid id] <name>   create an api key, the key is printed only once. scopes are
+                                                    ledger:read, ledger:debit, ledger:credit and ledger:admin,
+                                                    -userid restricts the key to a single user
+  apikey revoke [-reason text] <keyid>              revoke an api key
+  audit [-userid id] [-action a] [-limit n]         list the audit log, newest first
+
+flags:
+  -json      print the result as json
+  -dry-run   run the command in a transaction that is rolled back instead of committed
+
+changes are recorded in the audit log as made by ledgerctl:$USER`
+
+//admin tool running ledger operations through the data layer of the service, so operators don't have to use psql
+func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	//logs go to stderr so that they never mix with the command output
+	if err = cfg.InitLogger(os.Stderr); err != nil {
+		log.Fatal(err)
+	}
+	if len(cfg.Args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	db, err := database.Open(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := audit.WithActor(context.Background(), operator)
+	if err = migrations.Check(ctx, db); err != nil {
+		log.Fatal(err)
+	}
+	if err = middleware.Init(cfg, db); err != nil {
+		log.Fatal(err)
+	}
+
+	c := &ctl{db: db, out: os.Stdout, errOut: os.Stderr, localCache: cfg.Cache.Backend == cache.BackendBigCache}
+	if err = c.run(ctx, cfg.Args[0], cfg.Args[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		log.Fatal(err)
+	}
+}
+
+//actor of the changes made with ledgerctl in the audit log
+var operator = "ledgerctl:" + os.Getenv("USER")
+
+//returned for an unknown command or a missing or malformed command argument
+var errUsage = errors.New("invalid arguments")
+
+//ctl runs a single ledgerctl command, warnings go to errOut. localCache is set when the cache of the service is
+//local to its process(bigcache), which ledgerctl cannot invalidate
+type ctl struct {
+	db         *sql.DB
+	out        io.Writer
+	errOut     io.Writer
+	json       bool
+	dryRun     bool
+	localCache bool
+}
+
+//function to parse the flags of the command and run it, commands with flags of their own register them on fs
+//and return the function running the command
+func (c *ctl) run(ctx context.Context, command string, args []string) error {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&c.json, "json", false, "print the result as json")
+	fs.BoolVar(&c.dryRun, "dry-run", false, "run the command in a transaction that is rolled back instead of committed")
+
+	var runCommand func(ctx context.Context, args []string) error
+	switch command {
+	case "balance":
+		runCommand = c.balance
+	case "activity":
+		runCommand = c.activity(fs)
+	case "credit":
+		runCommand = c.credit(fs)
+	case "debit":
+		runCommand = c.debit(fs)
+	case "reverse":
+		runCommand = c.reverse(fs)
+	case "status":
+		runCommand = c.status(fs)
+	case "overdraft":
+		runCommand = c.overdraft(fs)
+	case "expire":
+		runCommand = c.expire
+	case "export":
+		runCommand = c.export
+	case "reconcile":
+		runCommand = c.reconcile(fs)
+	case "trial-balance":
+		runCommand = c.trialBalance
+	case "verify-chain":
+		runCommand = c.verifyChain
+	case "apikey":
+		runCommand = c.apikey
+	case "audit":
+		runCommand = c.audit(fs)
+	default:
+		return errUsage
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if c.dryRun {
+		ctx = database.WithDryRun(ctx)
+	}
+	return runCommand(ctx, fs.Args())
+}
+
+func (c *ctl) balance(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	balance, err := middleware.GetUserBalance(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return c.print(balance, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "userid:\t%s\navailable:\t%.2f\noriginal:\t%.2f\nconsumed:\t%.2f\nexpired:\t%.2f\noverdraft limit:\t%.2f\noverdrawn:\t%.2f\n\n",
+			balance.UserId, balance.Available, balance.Original, balance.Consumed, balance.Expired, balance.OverdraftLimit, balance.Overdrawn)
+		printCredits(w, balance.Credits)
+	})
+}
+
+func (c *ctl) activity(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	limit := fs.Int("limit", 20, "number of activities to list")
+	offset := fs.Int("offset", 0, "number of activities to skip")
+	return func(ctx context.Context, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		activities, err := middleware.GetAllActivities(ctx, models.User{UserId: args[0]}, strconv.Itoa(*limit), strconv.Itoa(*offset))
+		if err != nil {
+			return err
+		}
+		if activities == nil {
+			activities = make([]models.UserActivity, 0)
+		}
+		return c.print(activities, func(w *tabwriter.Writer) {
+			printActivities(w, activities)
+		})
+	}
+}
+
+func (c *ctl) credit(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	credit := models.UserCredit{}
+	fs.StringVar(&credit.Expiry, "expiry", "", "expiry of the credit in RFC 3339 format")
+	fs.StringVar(&credit.TransactionType, "type", "admin", "transaction type of the credit")
+	fs.IntVar(&credit.Priority, "priority", 0, "priority of the credit, credits with higher priority are consumed first")
+	fs.StringVar(&credit.Reason, "reason", "", "reason recorded in the audit log")
+	categories := fs.String("categories", "", "comma separated categories the credit may be spent in, every category by default")
+	return func(ctx context.Context, args []string) error {
+		if len(args) != 2 || len(credit.Expiry) == 0 {
+			return errUsage
+		}
+		if len(*categories) > 0 {
+			credit.AllowedCategories = strings.Split(*categories, ",")
+		}
+		amount, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return errUsage
+		}
+		credit.UserId, credit.Amount = args[0], amount
+
+		id, err := middleware.InsertUserCredit(ctx, credit)
+		if err != nil {
+			return err
+		}
+		c.invalidate(ctx, credit.UserId)
+		result := struct {
+			UserCreditId uint64 `json:"usercreditid"`
+			DryRun       bool   `json:"dryrun"`
+		}{id, c.dryRun}
+		return c.print(result, func(w *tabwriter.Writer) {
+			fmt.Fprintf(w, "created credit %d%s\n", id, c.dryRunNote())
+		})
+	}
+}
+
+func (c *ctl) debit(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	reason := fs.String("reason", "", "reason recorded in the audit log")
+	category := fs.String("category", "", "category the amount is spent in")
+	merchant := fs.String("merchant", "", "merchant the amount is spent at")
+	return func(ctx context.Context, args []string) error {
+		if len(args) != 2 {
+			return errUsage
+		}
+		amount, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return errUsage
+		}
+		debit := models.UserDebit{UserId: args[0], Amount: amount, Reason: *reason, Category: *category, Merchant: *merchant}
+		if err = middleware.InsertUserDebit(ctx, debit); err != nil {
+			return err
+		}
+		c.invalidate(ctx, args[0])
+		result := struct {
+			DryRun bool `json:"dryrun"`
+		}{c.dryRun}
+		return c.print(result, func(w *tabwriter.Writer) {
+			fmt.Fprintf(w, "debited %.2f%s\n", amount, c.dryRunNote())
+		})
+	}
+}
+
+func (c *ctl) reverse(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	reason := fs.String("reason", "", "reason recorded in the audit log")
+	return func(ctx context.Context, args []string) error {
+		if len(args) != 2 {
+			return errUsage
+		}
+		tranid, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return errUsage
+		}
+		id, err := middleware.ReverseDebit(ctx, args[0], tranid, *reason)
+		if err != nil {
+			return err
+		}
+		c.invalidate(ctx, args[0])
+		result := struct {
+			TranId uint64 `json:"tranid"`
+			DryRun bool   `json:"dryrun"`
+		}{id, c.dryRun}
+		return c.print(result, func(w *tabwriter.Writer) {
+			fmt.Fprintf(w, "reversed transaction %d with transaction %d%s\n", tranid, id, c.dryRunNote())
+		})
+	}
+}
+
+func (c *ctl) status(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	reason := fs.String("reason", "", "reason of the change, required")
+	return func(ctx context.Context, args []string) error {
+		if len(args) != 2 || len(*reason) == 0 {
+			return errUsage
+		}
+		id, err := middleware.SetAccountStatus(ctx, args[0], args[1], *reason)
+		if err != nil {
+			return err
+		}
+		c.invalidate(ctx, args[0])
+		result := struct {
+			TranId uint64 `json:"tranid,omitempty"`
+			DryRun bool   `json:"dryrun"`
+		}{id, c.dryRun}
+		return c.print(result, func(w *tabwriter.Writer) {
+			if id == 0 {
+				fmt.Fprintf(w, "account is already %s\n", args[1])
+				return
+			}
+			fmt.Fprintf(w, "account status set to %s with transaction %d%s\n", args[1], id, c.dryRunNote())
+		})
+	}
+}
+
+func (c *ctl) overdraft(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	reason := fs.String("reason", "", "reason of the change, required")
+	return func(ctx context.Context, args []string) error {
+		if len(args) != 2 || len(*reason) == 0 {
+			return errUsage
+		}
+		limit, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return errUsage
+		}
+		if err = middleware.SetOverdraftLimit(ctx, args[0], limit, *reason); err != nil {
+			return err
+		}
+		c.invalidate(ctx, args[0])
+		result := struct {
+			Limit  float64 `json:"limit"`
+			DryRun bool    `json:"dryrun"`
+		}{limit, c.dryRun}
+		return c.print(result, func(w *tabwriter.Writer) {
+			fmt.Fprintf(w, "overdraft limit set to %.2f%s\n", limit, c.dryRunNote())
+		})
+	}
+}
+
+//expiring credits writes no activity, the cached history stays valid and there is nothing to invalidate
+func (c *ctl) expire(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	expired, err := scheduledjob.UpdateExpiredCredits(ctx, c.db)
+	if err != nil {
+		return err
+	}
+	result := struct {
+		Expired int  `json:"expired"`
+		DryRun  bool `json:"dryrun"`
+	}{expired, c.dryRun}
+	return c.print(result, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "expired %d credit(s)%s\n", expired, c.dryRunNote())
+	})
+}
+
+//export always writes json, it is meant to be archived or processed by other tools
+func (c *ctl) export(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	ledger, err := middleware.GetLedger(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ledger)
+}
+
+func (c *ctl) reconcile(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	opts := scheduledjob.ReconcileOptions{}
+	fs.BoolVar(&opts.Correct, "correct", false, "correct the discrepancies found")
+	fs.StringVar(&opts.Reason, "reason", "", "reason recorded with the corrections")
+	fs.StringVar(&opts.Actor, "actor", operator, "actor recorded with the corrections")
+	return func(ctx context.Context, args []string) error {
+		if len(args) != 0 || (opts.Correct && len(opts.Reason) == 0) {
+			return errUsage
+		}
+		discrepancies, err := scheduledjob.Reconcile(ctx, c.db, opts)
+		if err != nil {
+			return err
+		}
+		return c.print(discrepancies, func(w *tabwriter.Writer) {
+			printDiscrepancies(w, discrepancies, c.dryRunNote())
+		})
+	}
+}
+
+func (c *ctl) trialBalance(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	balances, err := journal.TrialBalance(ctx, c.db)
+	if err != nil {
+		return err
+	}
+	return c.print(balances, func(w *tabwriter.Writer) {
+		var debit, credit float64
+		fmt.Fprintln(w, "ACCOUNT\tDEBIT\tCREDIT\tBALANCE")
+		for _, b := range balances {
+			fmt.Fprintf(w, "%s\t%.2f\t%.2f\t%.2f\n", b.Account, b.Debit, b.Credit, b.Balance)
+			debit, credit = debit+b.Debit, credit+b.Credit
+		}
+		fmt.Fprintf(w, "TOTAL\t%.2f\t%.2f\t%.2f\n", debit, credit, debit-credit)
+	})
+}
+
+func (c *ctl) verifyChain(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	report, err := middleware.VerifyChain(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	err = c.print(report, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "%d row(s), head tranid %d hash %s\n", report.Length, report.TranId, report.Hash)
+		for _, b := range report.Breaks {
+			fmt.Fprintf(w, "tranid %d: %s, expected %s got %s\n", b.TranId, b.Reason, b.Expected, b.Actual)
+		}
+	})
+	if err == nil && !report.Intact {
+		err = fmt.Errorf("hash chain of user %s is broken in %d place(s)", args[0], len(report.Breaks))
+	}
+	return err
+}
+
+//api keys are not written in a transaction, so they do not support -dry-run
+func (c *ctl) apikey(ctx context.Context, args []string) error {
+	if len(args) < 2 || c.dryRun {
+		return errUsage
+	}
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		scopes := fs.String("scopes", "", "comma separated scopes granted to the key")
+		userid := fs.String("userid", "", "user the key is restricted to")
+		if err := fs.Parse(args[1:]); err != nil || fs.NArg() != 1 || len(*scopes) == 0 {
+			return errUsage
+		}
+		id, key, err := auth.CreateAPIKey(ctx, c.db, fs.Arg(0), strings.Split(*scopes, ","), *userid)
+		if err != nil {
+			return err
+		}
+		result := struct {
+			KeyId uint64 `json:"keyid"`
+			Key   string `json:"key"`
+		}{id, key}
+		return c.print(result, func(w *tabwriter.Writer) {
+			fmt.Fprintf(w, "created api key %d, store it now as it cannot be shown again:\n%s\n", id, key)
+		})
+	case "revoke":
+		fs := flag.NewFlagSet("apikey revoke", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		reason := fs.String("reason", "", "reason recorded in the audit log")
+		if err := fs.Parse(args[1:]); err != nil || fs.NArg() != 1 {
+			return errUsage
+		}
+		id, err := strconv.ParseUint(fs.Arg(0), 10, 64)
+		if err != nil {
+			return errUsage
+		}
+		if err = auth.RevokeAPIKey(ctx, c.db, id, *reason); err != nil {
+			return err
+		}
+		result := struct {
+			KeyId uint64 `json:"keyid"`
+		}{id}
+		return c.print(result, func(w *tabwriter.Writer) {
+			fmt.Fprintf(w, "revoked api key %d\n", id)
+		})
+	}
+	return errUsage
+}
+
+func (c *ctl) audit(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	filter := audit.Filter{}
+	fs.StringVar(&filter.UserId, "userid", "", "only list the entries of the user")
+	fs.StringVar(&filter.Action, "action", "", "only list the entries of the action")
+	fs.IntVar(&filter.Limit, "limit", 50, "maximum number of entries listed")
+	return func(ctx context.Context, args []string) error {
+		if len(args) != 0 {
+			return errUsage
+		}
+		entries, err := audit.List(ctx, c.db, filter)
+		if err != nil {
+			return err
+		}
+		return c.print(entries, func(w *tabwriter.Writer) {
+			fmt.Fprintln(w, "AUDITID\tCREATED\tACTION\tACTOR\tUSERID\tENTITY\tREASON")
+			for _, e := range entries {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s %d\t%s\n", e.AuditId, e.Created, e.Action, e.Actor, e.UserId, e.Entity, e.EntityId, e.Reason)
+			}
+		})
+	}
+}
+
+//function to drop the cached history of the user once a mutation was committed, a dry run changed nothing. a cache
+//local to the service cannot be reached from here, the service keeps serving the history it cached until it expires
+func (c *ctl) invalidate(ctx context.Context, userid string) {
+	switch {
+	case c.dryRun:
+	case c.localCache:
+		fmt.Fprintf(c.errOut, "warning: the service caches history in its own process(CACHE_BACKEND=%s), it may serve the "+
+			"former history of user %s until CACHE_TTL has passed\n", cache.BackendBigCache, userid)
+	default:
+		middleware.InvalidateCache(ctx, userid)
+	}
+}
+
+func (c *ctl) dryRunNote() string {
+	if c.dryRun {
+		return " (dry run, rolled back)"
+	}
+	return ""
+}
+
+//function to write v as json or, by default, as the aligned text written by text
+func (c *ctl) print(v interface{}, text func(w *tabwriter.Writer)) error {
+	if c.json {
+		return json.NewEncoder(c.out).Encode(v)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	text(w)
+	return w.Flush()
+}
+
+func printCredits(w io.Writer, credits []models.UserCredit) {
+	fmt.Fprintln(w, "USERCREDITID\tAMOUNT\tORIGINAL\tCONSUMED\tTYPE\tPRIORITY\tEXPIRY\tEXPIRED\tCREATED\tCATEGORIES")
+	for _, credit := range credits {
+		fmt.Fprintf(w, "%d\t%.2f\t%.2f\t%.2f\t%s\t%d\t%s\t%t\t%s\t%s\n", credit.UserCreditId, credit.Amount, credit.OriginalAmount,
+			credit.ConsumedAmount, credit.TransactionType, credit.Priority, credit.Expiry, credit.IsExpired, credit.Created,
+			strings.Join(credit.AllowedCategories, ","))
+	}
+}
+
+func printActivities(w io.Writer, activities []models.UserActivity) {
+	fmt.Fprintln(w, "TRANID\tCREATED\tTYPE\tAMOUNT\tUSERCREDITID\tREVERSALOF\tOVERDRAFTID")
+	for _, activity := range activities {
+		kind := "debit"
+		if activity.IsCredit {
+			kind = "credit"
+		}
+		if len(activity.Event) > 0 {
+			kind = activity.Event
+		}
+		overdraft := ""
+		if activity.OverdraftId != 0 {
+			//a debit row with both a credit and an overdraft repays the overdraft
+			kind = "overdraft"
+			if activity.UserCreditId != 0 {
+				kind = "repayment"
+			}
+			overdraft = strconv.FormatUint(activity.OverdraftId, 10)
+		}
+		reversalOf := ""
+		if activity.ReversalOfTranId != 0 {
+			reversalOf = strconv.FormatUint(activity.ReversalOfTranId, 10)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%.2f\t%d\t%s\t%s\n", activity.TranId, activity.Created, kind, activity.Amount,
+			activity.UserCreditId, reversalOf, overdraft)
+	}
+}
+
+func printDiscrepancies(w io.Writer, discrepancies []models.CreditDiscrepancy, note string) {
+	if len(discrepancies) == 0 {
+		fmt.Fprintln(w, "no discrepancies found")
+		return
+	}
+	fmt.Fprintln(w, "USERID\tUSERCREDITID\tAMOUNT\tEXPECTED\tORIGINAL\tDEBITED\tREVERSED\tCORRECTIONID")
+	for _, d := range discrepancies {
+		correction := ""
+		if d.CorrectionId != 0 {
+			correction = strconv.FormatUint(d.CorrectionId, 10) + note
+		}
+		fmt.Fprintf(w, "%s\t%d\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%s\n", d.UserId, d.UserCreditId, d.Amount, d.Expected,
+			d.Original, d.Debited, d.Reversed, correction)
+	}
+}
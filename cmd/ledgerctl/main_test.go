@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"text/tabwriter"
+
+	"github.com/a0rana/UserAccountService/models"
+)
+
+//test case to verify malformed commands are rejected as a usage error before anything is run
+func TestRunUsage(t *testing.T) {
+	for _, tc := range []struct {
+		command string
+		args    []string
+	}{
+		{"unknown", nil},
+		{"balance", nil},
+		{"balance", []string{"-unknown", "u1"}},
+		{"activity", []string{"-limit", "ten", "u1"}},
+		{"credit", []string{"u1", "5"}},
+		{"credit", []string{"-expiry", "2099-01-01T00:00:00Z", "u1", "five"}},
+		{"debit", []string{"u1"}},
+		{"debit", []string{"u1", "five"}},
+		{"reverse", []string{"u1", "-1"}},
+		{"status", []string{"u1", "frozen"}},
+		{"overdraft", []string{"u1", "5"}},
+		{"overdraft", []string{"-reason", "agreement", "u1", "five"}},
+		{"expire", []string{"now"}},
+		{"export", nil},
+		{"reconcile", []string{"-correct"}},
+		{"reconcile", []string{"extra"}},
+		{"trial-balance", []string{"extra"}},
+		{"verify-chain", nil},
+		{"apikey", []string{"create"}},
+		{"apikey", []string{"create", "backoffice"}},
+		{"apikey", []string{"-dry-run", "create", "-scopes", "ledger:read", "backoffice"}},
+		{"apikey", []string{"-dry-run", "revoke", "1"}},
+		{"apikey", []string{"revoke", "one"}},
+		{"apikey", []string{"rotate", "1"}},
+		{"audit", []string{"extra"}},
+	} {
+		c := &ctl{out: &bytes.Buffer{}, errOut: &bytes.Buffer{}}
+		if err := c.run(context.Background(), tc.command, tc.args); !errors.Is(err, errUsage) {
+			t.Errorf("Expected %s %v to be a usage error. Got %v", tc.command, tc.args, err)
+		}
+	}
+}
+
+//test case to verify the flags shared by every command are parsed after the command
+func TestRunSharedFlags(t *testing.T) {
+	c := &ctl{out: &bytes.Buffer{}, errOut: &bytes.Buffer{}}
+	if err := c.run(context.Background(), "expire", []string{"-json", "-dry-run", "now"}); !errors.Is(err, errUsage) {
+		t.Fatalf("Expected a usage error. Got %v", err)
+	}
+	if !c.json || !c.dryRun || c.dryRunNote() == "" {
+		t.Errorf("Expected -json and -dry-run to be set. Got %+v", c)
+	}
+}
+
+//test case to verify activity rows are labelled by what they are, an overdraft row with a credit repays it
+func TestPrintActivities(t *testing.T) {
+	var out bytes.Buffer
+	printActivities(&out, []models.UserActivity{
+		{TranId: 1, IsCredit: true, Amount: 5, UserCreditId: 1},
+		{TranId: 2, Amount: 5, UserCreditId: 1},
+		{TranId: 3, Amount: 2, OverdraftId: 7},
+		{TranId: 4, IsCredit: true, Amount: 3, UserCreditId: 2},
+		{TranId: 5, Amount: 2, UserCreditId: 2, OverdraftId: 7},
+		{TranId: 6, IsCredit: true, Amount: 5, UserCreditId: 1, ReversalOfTranId: 2},
+		{TranId: 7, Event: "status:frozen"},
+	})
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	expected := []string{"credit", "debit", "overdraft", "credit", "repayment", "credit", "status:frozen"}
+	if len(lines) != len(expected)+1 {
+		t.Fatalf("Expected a header and %d rows. Got %q", len(expected), out.String())
+	}
+	for i, kind := range expected {
+		if fields := strings.Split(lines[i+1], "\t"); fields[2] != kind {
+			t.Errorf("Expected transaction %s to be a %s. Got %q", fields[0], kind, lines[i+1])
+		}
+	}
+	if fields := strings.Split(lines[5], "\t"); fields[6] != "7" {
+		t.Errorf("Expected the repayment to show its overdraft. Got %q", lines[5])
+	}
+	if fields := strings.Split(lines[6], "\t"); fields[5] != "2" {
+		t.Errorf("Expected the reversal to show the debit it reverses. Got %q", lines[6])
+	}
+}
+
+//test case to verify results are written as json with -json and as aligned text otherwise
+func TestPrint(t *testing.T) {
+	result := struct {
+		TranId uint64 `json:"tranid"`
+		DryRun bool   `json:"dryrun"`
+	}{42, true}
+	text := func(w *tabwriter.Writer) {
+		w.Write([]byte("tranid:\t42\ndry run:\ttrue\n"))
+	}
+
+	var out bytes.Buffer
+	c := &ctl{out: &out, json: true}
+	if err := c.print(result, text); err != nil || out.String() != "{\"tranid\":42,\"dryrun\":true}\n" {
+		t.Errorf("Expected the result as json. Got %q, %v", out.String(), err)
+	}
+
+	out.Reset()
+	c.json = false
+	if err := c.print(result, text); err != nil || out.String() != "tranid:   42\ndry run:  true\n" {
+		t.Errorf("Expected the result as aligned text. Got %q, %v", out.String(), err)
+	}
+}
+
+//test case to verify a change made with a cache local to the service warns it cannot be invalidated from here
+func TestInvalidateLocalCache(t *testing.T) {
+	var errOut bytes.Buffer
+	c := &ctl{out: &bytes.Buffer{}, errOut: &errOut, localCache: true, dryRun: true}
+	c.invalidate(context.Background(), "u1")
+	if errOut.Len() != 0 {
+		t.Errorf("Expected a dry run not to warn. Got %q", errOut.String())
+	}
+	c.dryRun = false
+	c.invalidate(context.Background(), "u1")
+	if !strings.Contains(errOut.String(), "warning:") || !strings.Contains(errOut.String(), "u1") {
+		t.Errorf("Expected a warning about the cache of the service. Got %q", errOut.String())
+	}
+}
@@ -0,0 +1,593 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a0rana/UserAccountService/logger"
+	"github.com/a0rana/UserAccountService/ratelimit"
+	"github.com/a0rana/UserAccountService/spending"
+	"github.com/joho/godotenv" // package used to read the .env file
+)
+
+//Config holds every setting of the service, it is loaded once at startup and passed to the packages needing it
+type Config struct {
+	Port         string
+	Server       ServerConfig
+	Log          LogConfig
+	Database     DatabaseConfig
+	Cache        CacheConfig
+	ExpiryJob    JobConfig
+	ReconcileJob ReconcileJobConfig
+	CORS         CORSConfig
+	Auth         AuthConfig
+	RateLimit    RateLimitConfig
+	Spending     spending.Limits
+	Account      AccountConfig
+
+	//positional arguments left after the flags, e.g. a subcommand
+	Args []string
+}
+
+//InitLogger configures the logger package from the log config, the config must have been validated
+func (c *Config) InitLogger(w io.Writer) error {
+	level, err := logger.ParseLevel(c.Log.Level)
+	if err != nil {
+		return err
+	}
+	return logger.Init(level, c.Log.Format, w)
+}
+
+//ServerConfig holds the http server timeouts. on shutdown readiness fails for DrainDelay so load balancers stop
+//routing traffic, then in-flight requests get up to ShutdownTimeout to complete
+type ServerConfig struct {
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	DrainDelay        time.Duration
+	ShutdownTimeout   time.Duration
+}
+
+//LogConfig holds the minimum level and the format(json or logfmt) of the log entries
+type LogConfig struct {
+	Level  string
+	Format string
+}
+
+//DatabaseConfig holds the postgres connection settings, DSN takes precedence over the individual params
+type DatabaseConfig struct {
+	DSN             string
+	Host            string
+	Port            string
+	User            string
+	Password        string
+	Name            string
+	SSLMode         string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	QueryTimeout    time.Duration
+	TxTimeout       time.Duration
+	MigrateOnStart  bool
+}
+
+//CacheConfig holds the cache backend settings
+type CacheConfig struct {
+	Backend       string
+	TTL           time.Duration
+	NegativeTTL   time.Duration
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+}
+
+//JobConfig holds the schedule of a periodic job, when enabled the service runs the job itself
+type JobConfig struct {
+	Enabled  bool
+	Interval time.Duration
+}
+
+//ReconcileJobConfig holds the schedule of the ledger reconciliation job and whether it corrects the discrepancies it finds
+type ReconcileJobConfig struct {
+	JobConfig
+	Correct bool
+}
+
+//CORSConfig holds what browsers on other origins are allowed to do with the api. no origin is allowed by default,
+// "*" allows every origin but cannot be combined with AllowCredentials
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+//AuthConfig holds the authentication settings of the api. requests must carry an api key or a bearer token signed
+//with HS256 by JWTHMACSecret or with RS256 by the key matching the public key in JWTRSAPublicKeyFile
+type AuthConfig struct {
+	Enabled             bool
+	APIKeys             bool
+	JWTHMACSecret       string
+	JWTRSAPublicKeyFile string
+	JWTIssuer           string
+	JWTAudience         string
+	JWTLeeway           time.Duration
+}
+
+//RateLimitConfig holds the rate limits of the api routes, keyed by route template. Client limits apply to each
+//api client(api key or token subject, the remote address without credentials) and User limits to each target user.
+//Address applies to every api request of a remote address before its credentials are checked, a zero rate disables it
+type RateLimitConfig struct {
+	Backend string
+	Address ratelimit.Rate
+	Client  map[string]ratelimit.Rate
+	User    map[string]ratelimit.Rate
+}
+
+//AccountConfig holds what a frozen account is kept from doing, it can never be debited and cannot be credited either
+//when FrozenBlocksCredits is set
+type AccountConfig struct {
+	FrozenBlocksCredits bool
+}
+
+//default values, used when a setting is neither in the config file, the environment nor the flags
+var defaults = map[string]string{
+	"PORT":                          "8080",
+	"SERVER_READ_TIMEOUT":           "15s",
+	"SERVER_READ_HEADER_TIMEOUT":    "5s",
+	"SERVER_WRITE_TIMEOUT":          "30s",
+	"SERVER_IDLE_TIMEOUT":           "60s",
+	"SHUTDOWN_DRAIN_DELAY":          "5s",
+	"SHUTDOWN_TIMEOUT":              "30s",
+	"LOG_LEVEL":                     "info",
+	"LOG_FORMAT":                    "json",
+	"POSTGRES_PORT":                 "5432",
+	"POSTGRES_SSLMODE":              "disable",
+	"DB_MAX_OPEN_CONNS":             "20",
+	"DB_MAX_IDLE_CONNS":             "5",
+	"DB_CONN_MAX_LIFETIME":          "30m",
+	"DB_QUERY_TIMEOUT":              "5s",
+	"DB_TX_TIMEOUT":                 "10s",
+	"MIGRATE_ON_START":              "false",
+	"CACHE_BACKEND":                 "bigcache",
+	"CACHE_TTL":                     "10m",
+	"CACHE_NEGATIVE_TTL":            "30s",
+	"REDIS_DB":                      "0",
+	"EXPIRY_JOB_ENABLED":            "false",
+	"EXPIRY_JOB_INTERVAL":           "12h",
+	"RECONCILE_JOB_ENABLED":         "false",
+	"RECONCILE_JOB_INTERVAL":        "24h",
+	"RECONCILE_JOB_CORRECT":         "false",
+	"CORS_ALLOWED_ORIGINS":          "",
+	"CORS_ALLOWED_METHODS":          "GET,POST",
+	"CORS_ALLOWED_HEADERS":          "Authorization,Content-Type,X-API-Key,X-Request-ID",
+	"CORS_EXPOSED_HEADERS":          "X-Request-ID,Retry-After",
+	"CORS_ALLOW_CREDENTIALS":        "false",
+	"CORS_MAX_AGE":                  "10m",
+	"AUTH_ENABLED":                  "true",
+	"AUTH_API_KEYS":                 "true",
+	"JWT_LEEWAY":                    "30s",
+	"RATE_LIMIT_BACKEND":            "memory",
+	"RATE_LIMIT_ADDRESS":            "200/s:400",
+	"RATE_LIMIT_CLIENT":             "/transactions=50/s:100,/balance=50/s:100,/credit=20/s:40,/debit=20/s:40",
+	"RATE_LIMIT_USER":               "/credit=5/s:10,/debit=5/s:10",
+	"SPENDING_MAX_SINGLE_DEBIT":     "0",
+	"SPENDING_MAX_DAILY_TOTAL":      "0",
+	"SPENDING_MAX_WEEKLY_TOTAL":     "0",
+	"SPENDING_MAX_DEBITS_PER_HOUR":  "0",
+	"ACCOUNT_FROZEN_BLOCKS_CREDITS": "false",
+}
+
+//flags overriding the environment, mapped to the env var they replace
+var flags = []struct {
+	name  string
+	key   string
+	usage string
+}{
+	{"port", "PORT", "port the api listens on"},
+	{"read-timeout", "SERVER_READ_TIMEOUT", "maximum duration for reading an entire request"},
+	{"write-timeout", "SERVER_WRITE_TIMEOUT", "maximum duration before timing out writes of the response"},
+	{"idle-timeout", "SERVER_IDLE_TIMEOUT", "maximum time to wait for the next request on a keep-alive connection"},
+	{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "maximum time given to in-flight requests on shutdown"},
+	{"log-level", "LOG_LEVEL", "minimum level of the log entries, one of debug, info, warn or error"},
+	{"log-format", "LOG_FORMAT", "format of the log entries, json or logfmt"},
+	{"db-dsn", "POSTGRES_DSN", "postgres connection string, overrides the individual POSTGRES_* params"},
+	{"db-max-open-conns", "DB_MAX_OPEN_CONNS", "maximum number of open database connections"},
+	{"db-max-idle-conns", "DB_MAX_IDLE_CONNS", "maximum number of idle database connections"},
+	{"db-conn-max-lifetime", "DB_CONN_MAX_LIFETIME", "maximum lifetime of a database connection"},
+	{"db-query-timeout", "DB_QUERY_TIMEOUT", "maximum duration of a read query"},
+	{"db-tx-timeout", "DB_TX_TIMEOUT", "maximum duration of a credit or debit transaction"},
+	{"migrate-on-start", "MIGRATE_ON_START", "apply pending schema migrations when the service starts"},
+	{"cache-backend", "CACHE_BACKEND", "cache backend, one of bigcache, redis or none"},
+	{"cache-ttl", "CACHE_TTL", "lifetime of cached transaction history"},
+	{"cache-negative-ttl", "CACHE_NEGATIVE_TTL", "lifetime of cached empty transaction history"},
+	{"redis-addr", "REDIS_ADDR", "address of the redis server used by the redis cache backend"},
+	{"expiry-job-enabled", "EXPIRY_JOB_ENABLED", "run the credit expiry job inside the service"},
+	{"expiry-job-interval", "EXPIRY_JOB_INTERVAL", "interval between two runs of the credit expiry job"},
+	{"reconcile-job-enabled", "RECONCILE_JOB_ENABLED", "run the ledger reconciliation job inside the service"},
+	{"reconcile-job-interval", "RECONCILE_JOB_INTERVAL", "interval between two runs of the ledger reconciliation job"},
+	{"reconcile-job-correct", "RECONCILE_JOB_CORRECT", "let the ledger reconciliation job correct the discrepancies it finds"},
+	{"cors-origins", "CORS_ALLOWED_ORIGINS", "comma separated list of origins allowed by CORS"},
+	{"cors-methods", "CORS_ALLOWED_METHODS", "comma separated list of methods allowed by CORS"},
+	{"cors-headers", "CORS_ALLOWED_HEADERS", "comma separated list of request headers allowed by CORS"},
+	{"cors-credentials", "CORS_ALLOW_CREDENTIALS", "let browsers send credentials with cross-origin requests"},
+	{"auth-enabled", "AUTH_ENABLED", "require api keys or bearer tokens on the api endpoints"},
+	{"auth-api-keys", "AUTH_API_KEYS", "accept the api keys stored in the database"},
+	{"jwt-rs256-public-key", "JWT_RS256_PUBLIC_KEY_FILE", "path of the PEM encoded RSA public key verifying RS256 bearer tokens"},
+	{"jwt-issuer", "JWT_ISSUER", "required iss claim of bearer tokens"},
+	{"jwt-audience", "JWT_AUDIENCE", "required aud claim of bearer tokens"},
+	{"jwt-leeway", "JWT_LEEWAY", "tolerated clock skew when checking the expiry of bearer tokens"},
+	{"rate-limit-backend", "RATE_LIMIT_BACKEND", "rate limiting backend, memory or none"},
+	{"rate-limit-address", "RATE_LIMIT_ADDRESS", "rate limit per remote address checked before the credentials, e.g. 200/s:400, empty to disable"},
+	{"rate-limit-client", "RATE_LIMIT_CLIENT", "comma separated route=rate limits per api client, e.g. /debit=20/s:40"},
+	{"rate-limit-user", "RATE_LIMIT_USER", "comma separated route=rate limits per target user, e.g. /debit=5/s:10"},
+	{"spending-max-single-debit", "SPENDING_MAX_SINGLE_DEBIT", "default maximum amount of a single debit, 0 for no limit"},
+	{"spending-max-daily-total", "SPENDING_MAX_DAILY_TOTAL", "default maximum amount debited from a user over 24 hours, 0 for no limit"},
+	{"spending-max-weekly-total", "SPENDING_MAX_WEEKLY_TOTAL", "default maximum amount debited from a user over 7 days, 0 for no limit"},
+	{"spending-max-debits-per-hour", "SPENDING_MAX_DEBITS_PER_HOUR", "default maximum number of debits of a user per hour, 0 for no limit"},
+	{"frozen-blocks-credits", "ACCOUNT_FROZEN_BLOCKS_CREDITS", "reject the credits of frozen accounts as well as their debits"},
+}
+
+//Load reads the configuration, later sources override earlier ones:
+//defaults, the .env file in the working directory(optional), the file given by -config or CONFIG_FILE,
+//the environment and finally the command line flags. args are the command line arguments without the program name,
+//flags must come before any positional argument
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path of a config file in .env format")
+	values := make(map[string]*string, len(flags))
+	for _, f := range flags {
+		values[f.key] = fs.String(f.name, "", f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]string)
+	for key, value := range defaults {
+		settings[key] = value
+	}
+	if err := readFile(".env", settings, true); err != nil {
+		return nil, err
+	}
+	if len(*configFile) > 0 {
+		if err := readFile(*configFile, settings, false); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range keys() {
+		if value, ok := os.LookupEnv(key); ok {
+			settings[key] = value
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		for _, known := range flags {
+			if known.name == f.Name {
+				settings[known.key] = *values[known.key]
+			}
+		}
+	})
+
+	cfg, err := parse(settings)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Args = fs.Args()
+	return cfg, nil
+}
+
+//function to merge a .env formatted file into settings
+func readFile(path string, settings map[string]string, optional bool) error {
+	file, err := godotenv.Read(path)
+	if err != nil {
+		if optional && os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to read config file %s: %v", path, err)
+	}
+	for key, value := range file {
+		settings[key] = value
+	}
+	return nil
+}
+
+//function to list every env var understood by the config
+func keys() []string {
+	keys := []string{"POSTGRES_HOST", "POSTGRES_USER", "POSTGRES_PASSWORD", "POSTGRES_DBNAME", "REDIS_PASSWORD", "JWT_HS256_SECRET"}
+	for key := range defaults {
+		keys = append(keys, key)
+	}
+	for _, f := range flags {
+		keys = append(keys, f.key)
+	}
+	return keys
+}
+
+//function to convert the raw settings into a Config, every invalid setting is reported at once
+func parse(settings map[string]string) (*Config, error) {
+	p := parser{settings: settings}
+	cfg := &Config{
+		Port: settings["PORT"],
+		Server: ServerConfig{
+			ReadTimeout:       p.duration("SERVER_READ_TIMEOUT"),
+			ReadHeaderTimeout: p.duration("SERVER_READ_HEADER_TIMEOUT"),
+			WriteTimeout:      p.duration("SERVER_WRITE_TIMEOUT"),
+			IdleTimeout:       p.duration("SERVER_IDLE_TIMEOUT"),
+			DrainDelay:        p.duration("SHUTDOWN_DRAIN_DELAY"),
+			ShutdownTimeout:   p.duration("SHUTDOWN_TIMEOUT"),
+		},
+		Log: LogConfig{
+			Level:  settings["LOG_LEVEL"],
+			Format: settings["LOG_FORMAT"],
+		},
+		Database: DatabaseConfig{
+			DSN:             settings["POSTGRES_DSN"],
+			Host:            settings["POSTGRES_HOST"],
+			Port:            settings["POSTGRES_PORT"],
+			User:            settings["POSTGRES_USER"],
+			Password:        settings["POSTGRES_PASSWORD"],
+			Name:            settings["POSTGRES_DBNAME"],
+			SSLMode:         settings["POSTGRES_SSLMODE"],
+			MaxOpenConns:    p.int("DB_MAX_OPEN_CONNS"),
+			MaxIdleConns:    p.int("DB_MAX_IDLE_CONNS"),
+			ConnMaxLifetime: p.duration("DB_CONN_MAX_LIFETIME"),
+			QueryTimeout:    p.duration("DB_QUERY_TIMEOUT"),
+			TxTimeout:       p.duration("DB_TX_TIMEOUT"),
+			MigrateOnStart:  p.bool("MIGRATE_ON_START"),
+		},
+		Cache: CacheConfig{
+			Backend:       settings["CACHE_BACKEND"],
+			TTL:           p.duration("CACHE_TTL"),
+			NegativeTTL:   p.duration("CACHE_NEGATIVE_TTL"),
+			RedisAddr:     settings["REDIS_ADDR"],
+			RedisPassword: settings["REDIS_PASSWORD"],
+			RedisDB:       p.int("REDIS_DB"),
+		},
+		ExpiryJob: JobConfig{
+			Enabled:  p.bool("EXPIRY_JOB_ENABLED"),
+			Interval: p.duration("EXPIRY_JOB_INTERVAL"),
+		},
+		ReconcileJob: ReconcileJobConfig{
+			JobConfig: JobConfig{
+				Enabled:  p.bool("RECONCILE_JOB_ENABLED"),
+				Interval: p.duration("RECONCILE_JOB_INTERVAL"),
+			},
+			Correct: p.bool("RECONCILE_JOB_CORRECT"),
+		},
+		CORS: CORSConfig{
+			AllowedOrigins:   p.list("CORS_ALLOWED_ORIGINS"),
+			AllowedMethods:   p.list("CORS_ALLOWED_METHODS"),
+			AllowedHeaders:   p.list("CORS_ALLOWED_HEADERS"),
+			ExposedHeaders:   p.list("CORS_EXPOSED_HEADERS"),
+			AllowCredentials: p.bool("CORS_ALLOW_CREDENTIALS"),
+			MaxAge:           p.duration("CORS_MAX_AGE"),
+		},
+		Auth: AuthConfig{
+			Enabled:             p.bool("AUTH_ENABLED"),
+			APIKeys:             p.bool("AUTH_API_KEYS"),
+			JWTHMACSecret:       settings["JWT_HS256_SECRET"],
+			JWTRSAPublicKeyFile: settings["JWT_RS256_PUBLIC_KEY_FILE"],
+			JWTIssuer:           settings["JWT_ISSUER"],
+			JWTAudience:         settings["JWT_AUDIENCE"],
+			JWTLeeway:           p.duration("JWT_LEEWAY"),
+		},
+		RateLimit: RateLimitConfig{
+			Backend: settings["RATE_LIMIT_BACKEND"],
+			Address: p.rate("RATE_LIMIT_ADDRESS"),
+			Client:  p.rates("RATE_LIMIT_CLIENT"),
+			User:    p.rates("RATE_LIMIT_USER"),
+		},
+		Spending: spending.Limits{
+			MaxSingleDebit:   p.float("SPENDING_MAX_SINGLE_DEBIT"),
+			MaxDailyTotal:    p.float("SPENDING_MAX_DAILY_TOTAL"),
+			MaxWeeklyTotal:   p.float("SPENDING_MAX_WEEKLY_TOTAL"),
+			MaxDebitsPerHour: p.int("SPENDING_MAX_DEBITS_PER_HOUR"),
+		},
+		Account: AccountConfig{
+			FrozenBlocksCredits: p.bool("ACCOUNT_FROZEN_BLOCKS_CREDITS"),
+		},
+	}
+	if len(p.errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration: %s", strings.Join(p.errs, "; "))
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+//Validate checks the consistency of the configuration and reports every problem found
+func (c *Config) Validate() error {
+	var errs []string
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Sprintf("PORT must be a number between 1 and 65535, got %q", c.Port))
+	}
+	if c.Server.ReadTimeout <= 0 || c.Server.ReadHeaderTimeout <= 0 || c.Server.WriteTimeout <= 0 || c.Server.IdleTimeout <= 0 {
+		errs = append(errs, "SERVER_READ_TIMEOUT, SERVER_READ_HEADER_TIMEOUT, SERVER_WRITE_TIMEOUT and SERVER_IDLE_TIMEOUT must be positive")
+	}
+	if c.Server.DrainDelay < 0 || c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, "SHUTDOWN_DRAIN_DELAY cannot be negative and SHUTDOWN_TIMEOUT must be positive")
+	}
+	if _, err := logger.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Sprintf("LOG_LEVEL must be one of debug, info, warn or error, got %q", c.Log.Level))
+	}
+	if c.Log.Format != logger.FormatJSON && c.Log.Format != logger.FormatLogfmt {
+		errs = append(errs, fmt.Sprintf("LOG_FORMAT must be json or logfmt, got %q", c.Log.Format))
+	}
+	if len(c.Database.DSN) == 0 {
+		required := []struct{ key, value string }{
+			{"POSTGRES_HOST", c.Database.Host}, {"POSTGRES_USER", c.Database.User}, {"POSTGRES_DBNAME", c.Database.Name},
+		}
+		for _, r := range required {
+			if len(r.value) == 0 {
+				errs = append(errs, fmt.Sprintf("%s is required when POSTGRES_DSN is not set", r.key))
+			}
+		}
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		errs = append(errs, "DB_MAX_OPEN_CONNS and DB_MAX_IDLE_CONNS cannot be negative")
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		errs = append(errs, "DB_MAX_IDLE_CONNS cannot be greater than DB_MAX_OPEN_CONNS")
+	}
+	if c.Database.QueryTimeout <= 0 || c.Database.TxTimeout <= 0 {
+		errs = append(errs, "DB_QUERY_TIMEOUT and DB_TX_TIMEOUT must be positive")
+	}
+	switch c.Cache.Backend {
+	case "bigcache", "none":
+	case "redis":
+		if len(c.Cache.RedisAddr) == 0 {
+			errs = append(errs, "REDIS_ADDR is required when CACHE_BACKEND is redis")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("CACHE_BACKEND must be one of bigcache, redis or none, got %q", c.Cache.Backend))
+	}
+	if c.Cache.TTL <= 0 || c.Cache.NegativeTTL <= 0 {
+		errs = append(errs, "CACHE_TTL and CACHE_NEGATIVE_TTL must be positive")
+	} else if c.Cache.NegativeTTL > c.Cache.TTL {
+		errs = append(errs, "CACHE_NEGATIVE_TTL cannot be greater than CACHE_TTL")
+	}
+	if c.ExpiryJob.Interval < time.Second {
+		errs = append(errs, "EXPIRY_JOB_INTERVAL must be at least one second")
+	}
+	if c.ReconcileJob.Interval < time.Second {
+		errs = append(errs, "RECONCILE_JOB_INTERVAL must be at least one second")
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			if c.CORS.AllowCredentials {
+				errs = append(errs, "CORS_ALLOWED_ORIGINS cannot be * when CORS_ALLOW_CREDENTIALS is true")
+			}
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 || len(u.Path) > 0 {
+			errs = append(errs, fmt.Sprintf("CORS_ALLOWED_ORIGINS entry %q must be * or an origin like https://example.com", origin))
+		}
+	}
+	for _, method := range c.CORS.AllowedMethods {
+		if method != strings.ToUpper(method) || strings.ContainsAny(method, " /") {
+			errs = append(errs, fmt.Sprintf("CORS_ALLOWED_METHODS entry %q must be an upper case method like POST", method))
+		}
+	}
+	if c.CORS.MaxAge < 0 {
+		errs = append(errs, "CORS_MAX_AGE cannot be negative")
+	}
+	if c.Auth.Enabled && !c.Auth.APIKeys && len(c.Auth.JWTHMACSecret) == 0 && len(c.Auth.JWTRSAPublicKeyFile) == 0 {
+		errs = append(errs, "AUTH_ENABLED needs AUTH_API_KEYS, JWT_HS256_SECRET or JWT_RS256_PUBLIC_KEY_FILE to accept any credentials")
+	}
+	if len(c.Auth.JWTHMACSecret) > 0 && len(c.Auth.JWTHMACSecret) < 32 {
+		errs = append(errs, "JWT_HS256_SECRET must be at least 32 characters long")
+	}
+	if c.Auth.JWTLeeway < 0 {
+		errs = append(errs, "JWT_LEEWAY cannot be negative")
+	}
+	if c.RateLimit.Backend != ratelimit.BackendMemory && c.RateLimit.Backend != ratelimit.BackendNone {
+		errs = append(errs, fmt.Sprintf("RATE_LIMIT_BACKEND must be memory or none, got %q", c.RateLimit.Backend))
+	}
+	if err := c.Spending.Validate(); err != nil {
+		errs = append(errs, "SPENDING_MAX_SINGLE_DEBIT, SPENDING_MAX_DAILY_TOTAL, SPENDING_MAX_WEEKLY_TOTAL and SPENDING_MAX_DEBITS_PER_HOUR cannot be negative")
+	}
+	if len(errs) > 0 {
+		return errors.New(fmt.Sprint("invalid configuration: ", strings.Join(errs, "; ")))
+	}
+	return nil
+}
+
+//ConnectionString returns the postgres connection string, built from the individual params when no DSN is set
+func (d DatabaseConfig) ConnectionString() string {
+	if len(d.DSN) > 0 {
+		return d.DSN
+	}
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		d.Host, d.Port, d.User, d.Password, d.Name, d.SSLMode)
+}
+
+//parser converts raw settings and collects the conversion errors
+type parser struct {
+	settings map[string]string
+	errs     []string
+}
+
+func (p *parser) int(key string) int {
+	value, err := strconv.Atoi(p.settings[key])
+	if err != nil {
+		p.errs = append(p.errs, fmt.Sprintf("%s must be a number, got %q", key, p.settings[key]))
+	}
+	return value
+}
+
+func (p *parser) float(key string) float64 {
+	value, err := strconv.ParseFloat(p.settings[key], 64)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Sprintf("%s must be a number, got %q", key, p.settings[key]))
+	}
+	return value
+}
+
+func (p *parser) bool(key string) bool {
+	value, err := strconv.ParseBool(p.settings[key])
+	if err != nil {
+		p.errs = append(p.errs, fmt.Sprintf("%s must be true or false, got %q", key, p.settings[key]))
+	}
+	return value
+}
+
+func (p *parser) duration(key string) time.Duration {
+	value, err := time.ParseDuration(p.settings[key])
+	if err != nil {
+		p.errs = append(p.errs, fmt.Sprintf("%s must be a duration like 30s or 10m, got %q", key, p.settings[key]))
+	}
+	return value
+}
+
+func (p *parser) list(key string) []string {
+	var values []string
+	for _, value := range strings.Split(p.settings[key], ",") {
+		if value = strings.TrimSpace(value); len(value) > 0 {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+//function to read a single rate, e.g. "200/s:400", an empty setting is the zero rate
+func (p *parser) rate(key string) ratelimit.Rate {
+	value := strings.TrimSpace(p.settings[key])
+	if len(value) == 0 {
+		return ratelimit.Rate{}
+	}
+	rate, err := ratelimit.ParseRate(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Sprintf("%s is invalid: %v", key, err))
+	}
+	return rate
+}
+
+//function to read a list of route=rate pairs, e.g. "/debit=20/s:40,/credit=10/s"
+func (p *parser) rates(key string) map[string]ratelimit.Rate {
+	rates := make(map[string]ratelimit.Rate)
+	for _, value := range p.list(key) {
+		i := strings.IndexByte(value, '=')
+		if i <= 0 || !strings.HasPrefix(value, "/") {
+			p.errs = append(p.errs, fmt.Sprintf("%s entry %q must be a route and a rate like /debit=20/s:40", key, value))
+			continue
+		}
+		rate, err := ratelimit.ParseRate(value[i+1:])
+		if err != nil {
+			p.errs = append(p.errs, fmt.Sprintf("%s entry %q is invalid: %v", key, value, err))
+			continue
+		}
+		rates[strings.TrimSpace(value[:i])] = rate
+	}
+	return rates
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//test case to verify that flags override the environment, which overrides the config file, which overrides defaults
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "service.env")
+	ioutil.WriteFile(file, []byte("POSTGRES_HOST=file-host\nPOSTGRES_USER=file-user\nPOSTGRES_DBNAME=UserAccount\nPORT=9000\nCACHE_TTL=5m\n"), 0600)
+	t.Setenv("CONFIG_FILE", file)
+	t.Setenv("POSTGRES_HOST", "env-host")
+	t.Setenv("PORT", "9001")
+
+	cfg, err := Load([]string{"-port", "9002", "-cors-origins", "https://a.example.com, https://b.example.com", "migrate", "up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != "9002" || cfg.Database.Host != "env-host" || cfg.Database.User != "file-user" {
+		t.Errorf("Expected flag port, env host and file user. Got %s, %s, %s", cfg.Port, cfg.Database.Host, cfg.Database.User)
+	}
+	if cfg.Cache.TTL != 5*time.Minute || cfg.Cache.NegativeTTL != 30*time.Second || cfg.Database.MaxOpenConns != 20 {
+		t.Errorf("Expected file ttl and default negative ttl and pool size. Got %+v, %+v", cfg.Cache, cfg.Database)
+	}
+	if len(cfg.Args) != 2 || cfg.Args[0] != "migrate" {
+		t.Errorf("Expected positional arguments to be kept. Got %v", cfg.Args)
+	}
+	if len(cfg.CORS.AllowedOrigins) != 2 || cfg.CORS.AllowedOrigins[1] != "https://b.example.com" {
+		t.Errorf("Expected two CORS origins. Got %v", cfg.CORS.AllowedOrigins)
+	}
+	if rate := cfg.RateLimit.User["/debit"]; rate.Limit != 5 || rate.Period != time.Second || rate.Burst != 10 {
+		t.Errorf("Expected the default debit limit per user. Got %+v", rate)
+	}
+	if rate := cfg.RateLimit.Address; rate.Limit != 200 || rate.Burst != 400 {
+		t.Errorf("Expected the default limit per address. Got %+v", rate)
+	}
+	expected := "host=env-host port=5432 user=file-user password= dbname=UserAccount sslmode=disable"
+	if dsn := cfg.Database.ConnectionString(); dsn != expected {
+		t.Errorf("Expected connection string %q. Got %q", expected, dsn)
+	}
+}
+
+//test case to verify that every invalid setting is reported with the name of the setting
+func TestLoadValidation(t *testing.T) {
+	t.Setenv("POSTGRES_DSN", "")
+	t.Setenv("POSTGRES_HOST", "")
+	t.Setenv("CACHE_BACKEND", "redis")
+	t.Setenv("CACHE_NEGATIVE_TTL", "1h")
+	t.Setenv("DB_MAX_OPEN_CONNS", "2")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "example.com,*")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	t.Setenv("RECONCILE_JOB_INTERVAL", "10ms")
+	t.Setenv("RATE_LIMIT_BACKEND", "redis")
+	t.Setenv("SPENDING_MAX_DAILY_TOTAL", "-100")
+
+	_, err := Load([]string{"-port", "http"})
+	if err == nil {
+		t.Fatal("Expected validation error")
+	}
+	for _, key := range []string{"PORT", "POSTGRES_HOST", "REDIS_ADDR", "CACHE_NEGATIVE_TTL", "DB_MAX_IDLE_CONNS", "CORS_ALLOWED_ORIGINS", "CORS_ALLOW_CREDENTIALS", "RECONCILE_JOB_INTERVAL", "RATE_LIMIT_BACKEND", "SPENDING_MAX_DAILY_TOTAL"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("Expected %s to be reported. Got %v", key, err)
+		}
+	}
+
+	t.Setenv("DB_MAX_OPEN_CONNS", "many")
+	if _, err = Load(nil); err == nil || !strings.Contains(err.Error(), "DB_MAX_OPEN_CONNS must be a number") {
+		t.Errorf("Expected parse error for DB_MAX_OPEN_CONNS. Got %v", err)
+	}
+}
+
+//test case to verify that a missing config file given explicitly is an error
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := Load([]string{"-config", filepath.Join(t.TempDir(), "missing.env")}); err == nil {
+		t.Error("Expected an error for a missing config file")
+	}
+}
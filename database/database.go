@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/a0rana/UserAccountService/config"
+	"github.com/a0rana/UserAccountService/logger"
+	"github.com/a0rana/UserAccountService/metrics"
+	_ "github.com/lib/pq" // postgres golang driver
+)
+
+//Open creates the postgres connection pool shared by the whole process and checks that the database is reachable
+func Open(cfg config.DatabaseConfig) (*sql.DB, error) {
+	db, err := sql.Open("postgres", cfg.ConnectionString())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	// check the connection
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to connect to the database: %v", err)
+	}
+
+	logger.Info(context.Background(), "successfully connected to the database")
+	return db, nil
+}
+
+//RegisterMetrics exposes the connection pool statistics of db as metrics, it must be called once per process
+func RegisterMetrics(db *sql.DB) {
+	metrics.NewGaugeFunc("db_open_connections", "Number of established database connections, in use or idle.",
+		func() float64 { return float64(db.Stats().OpenConnections) })
+	metrics.NewGaugeFunc("db_in_use_connections", "Number of database connections currently in use.",
+		func() float64 { return float64(db.Stats().InUse) })
+	metrics.NewGaugeFunc("db_idle_connections", "Number of idle database connections.",
+		func() float64 { return float64(db.Stats().Idle) })
+	metrics.NewGaugeFunc("db_max_open_connections", "Maximum number of open database connections.",
+		func() float64 { return float64(db.Stats().MaxOpenConnections) })
+	metrics.NewCounterFunc("db_wait_count_total", "Number of times a request waited for a database connection.",
+		func() float64 { return float64(db.Stats().WaitCount) })
+	metrics.NewCounterFunc("db_wait_duration_seconds_total", "Total time spent waiting for a database connection.",
+		func() float64 { return db.Stats().WaitDuration.Seconds() })
+}
+
+type contextKey int
+
+const dryRunKey contextKey = iota
+
+//WithDryRun returns a copy of ctx asking data access functions to roll back their transaction instead of committing it
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey, true)
+}
+
+//IsDryRun reports whether ctx was created by WithDryRun
+func IsDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey).(bool)
+	return dryRun
+}
+
+//Commit commits tx, or rolls it back when ctx asks for a dry run so that every check and statement ran but nothing is kept
+func Commit(ctx context.Context, tx *sql.Tx) error {
+	if IsDryRun(ctx) {
+		return tx.Rollback()
+	}
+	return tx.Commit()
+}
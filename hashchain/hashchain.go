@@ -0,0 +1,150 @@
+package hashchain
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Genesis is the previous hash of the first activity row of every user
+var Genesis = strings.Repeat("0", 64)
+
+//Link is the content of an activity row covered by its hash, along with the hash of the previous row of the user
+type Link struct {
+	UserId           string
+	TranId           uint64
+	IsCredit         bool
+	Amount           float64
+	UserCreditId     uint64
+	ReversalOfTranId uint64
+	Event            string
+	Merchant         string
+	Category         string
+	OverdraftId      uint64
+	Created          time.Time
+	PrevHash         string
+}
+
+//Hash returns the hex encoded sha256 of the canonical form of the link, every field written as its length in bytes, a
+//colon and its value so that no value can pass for another field. amounts are hashed in cents so that the hash does
+//not depend on how a float is printed, the user id in the form postgres prints a uuid and created to the microsecond
+//postgres keeps. migration 0015 computes the same hash in SQL, both must be changed together
+func (l Link) Hash() string {
+	var canonical strings.Builder
+	for _, field := range []string{canonicalUUID(l.UserId), strconv.FormatUint(l.TranId, 10), strconv.FormatBool(l.IsCredit),
+		strconv.FormatInt(int64(math.Round(l.Amount*100)), 10), strconv.FormatUint(l.UserCreditId, 10),
+		strconv.FormatUint(l.ReversalOfTranId, 10), l.Event, l.Category, l.Merchant, strconv.FormatUint(l.OverdraftId, 10),
+		canonicalTime(l.Created), l.PrevHash} {
+		fmt.Fprintf(&canonical, "%d:%s", len(field), field)
+	}
+	sum := sha256.Sum256([]byte(canonical.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+//Querier runs the queries of the package, both *sql.DB and *sql.Tx implement it
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+//ChainHead is the last link of the chain of a user, it can be published to anchor the history up to it.
+//a user without activity has a head of length 0 whose hash is Genesis
+type ChainHead struct {
+	UserId string `json:"userid"`
+	TranId uint64 `json:"tranid"`
+	Hash   string `json:"hash"`
+	Length int    `json:"length"`
+}
+
+//Head returns the head of the chain of the user, inside a transaction appending to the chain the head it returns is
+//the one the next row must be chained to
+func Head(ctx context.Context, q Querier, userid string) (ChainHead, error) {
+	head := ChainHead{UserId: userid, Hash: Genesis}
+	err := q.QueryRowContext(ctx, headStatement, userid).Scan(&head.TranId, &head.Hash, &head.Length)
+	if err == sql.ErrNoRows {
+		return head, nil
+	}
+	return head, err
+}
+
+//Break is a row of a chain that does not match its hash or is not chained to the previous row
+type Break struct {
+	TranId   uint64 `json:"tranid"`
+	Reason   string `json:"reason"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+//Report is the result of the verification of the chain of a user, the chain is intact when it has no break
+type Report struct {
+	ChainHead
+	Intact bool    `json:"intact"`
+	Breaks []Break `json:"breaks"`
+}
+
+//Verify walks the chain of the user in transaction order, recomputing the hash of every row and checking it is
+//chained to the previous one. a row edited, inserted or deleted by direct SQL shows up as a break
+func Verify(ctx context.Context, q Querier, userid string) (Report, error) {
+	report := Report{ChainHead: ChainHead{UserId: userid, Hash: Genesis}, Breaks: make([]Break, 0)}
+	rows, err := q.QueryContext(ctx, chainStatement, userid)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	previous := Genesis
+	for rows.Next() {
+		l := Link{UserId: userid}
+		var hash string
+		var created sql.NullTime
+		if err := rows.Scan(&l.TranId, &l.IsCredit, &l.Amount, &l.UserCreditId, &l.ReversalOfTranId, &l.Event, &l.Merchant, &l.Category, &l.OverdraftId, &created, &l.PrevHash, &hash); err != nil {
+			return report, err
+		}
+		l.Created = created.Time
+		if l.PrevHash != previous {
+			report.Breaks = append(report.Breaks, Break{TranId: l.TranId, Reason: "not chained to the previous row", Expected: previous, Actual: l.PrevHash})
+		}
+		if expected := l.Hash(); expected != hash {
+			report.Breaks = append(report.Breaks, Break{TranId: l.TranId, Reason: "content does not match the hash", Expected: expected, Actual: hash})
+		}
+		//the stored hash is what the next row was chained to
+		previous = hash
+		report.TranId, report.Hash = l.TranId, hash
+		report.Length++
+	}
+	report.Intact = len(report.Breaks) == 0
+	return report, rows.Err()
+}
+
+//function to write a uuid the way postgres prints it, lower case with dashes, postgres accepts other forms as input.
+//anything else is returned unchanged
+func canonicalUUID(id string) string {
+	s := strings.ToLower(strings.TrimSpace(id))
+	s = strings.TrimPrefix(strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}"), "urn:uuid:")
+	s = strings.ReplaceAll(s, "-", "")
+	if _, err := hex.DecodeString(s); err != nil || len(s) != 32 {
+		return id
+	}
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:32]
+}
+
+//function to write a timestamp the way migration 0015 does, to the microsecond in UTC. a missing one is empty
+func canonicalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02 15:04:05.000000")
+}
+
+const (
+	headStatement = `SELECT tranid, hash, (SELECT COUNT(*) FROM tbl_Activity WHERE userid=$1) FROM tbl_Activity
+		WHERE userid=$1 ORDER BY tranid DESC LIMIT 1`
+	chainStatement = `SELECT tranid, iscredit, amount, COALESCE(usercreditid, 0), COALESCE(reversaloftranid, 0), COALESCE(event, ''),
+			COALESCE(merchant, ''), COALESCE(category, ''), COALESCE(overdraftid, 0), created, prevhash, hash FROM tbl_Activity WHERE userid=$1 ORDER BY tranid ASC`
+)
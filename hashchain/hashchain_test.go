@@ -0,0 +1,61 @@
+package hashchain
+
+import (
+	"testing"
+	"time"
+)
+
+//test case to pin the canonical form of a link, migration 0015 hashes the existing rows the same way
+func TestLinkHash(t *testing.T) {
+	created := time.Date(2021, 2, 4, 21, 22, 18, 856783000, time.UTC)
+	link := Link{UserId: "7507decb-0f2d-4510-8202-c78699ed3153", TranId: 1, IsCredit: true, Amount: 5, UserCreditId: 1, Created: created,
+		PrevHash: Genesis}
+	expected := "9e1cecbb7f8591f0fd9c45ad2d80855219d02ee8a0d700793491e3d8a36fa5b8"
+	if hash := link.Hash(); hash != expected {
+		t.Errorf("Expected hash %s. Got %s", expected, hash)
+	}
+
+	//the same uuid written another way, an amount with float noise and created in another zone hash the same
+	same := link
+	same.UserId, same.Amount = "{7507DECB0F2D45108202C78699ED3153}", 4.999999999
+	same.Created = created.In(time.FixedZone("CET", 3600))
+	if same.Hash() != expected {
+		t.Errorf("Expected the canonical uuid, amount in cents and created in UTC to be hashed. Got %s", same.Hash())
+	}
+
+	edited := link
+	edited.Amount = 50
+	rechained := link
+	rechained.PrevHash = expected
+	event := link
+	event.Event = "status:frozen"
+	category := link
+	category.Category = "food"
+	overdraft := link
+	overdraft.OverdraftId = 1
+	backdated := link
+	backdated.Created = created.Add(-time.Microsecond)
+	for name, l := range map[string]Link{"amount": edited, "previous hash": rechained, "event": event, "category": category,
+		"overdraft": overdraft, "created": backdated} {
+		if l.Hash() == expected {
+			t.Errorf("Expected the hash to change with the %s", name)
+		}
+	}
+}
+
+//test case to verify a value cannot pass for another field, every field is length prefixed
+func TestLinkHashFieldBoundaries(t *testing.T) {
+	link := Link{UserId: "7507decb-0f2d-4510-8202-c78699ed3153", TranId: 2, Amount: 1, UserCreditId: 1, PrevHash: Genesis}
+	split, joined := link, link
+	split.Category, split.Merchant = "food", "x"
+	joined.Category = "food|merchant=x"
+	if split.Hash() == joined.Hash() {
+		t.Error("Expected a category holding a merchant to hash differently from a category and a merchant")
+	}
+	moved := link
+	moved.Merchant = "food"
+	split.Merchant = ""
+	if split.Hash() == moved.Hash() {
+		t.Error("Expected the same value in the category and in the merchant to hash differently")
+	}
+}
@@ -0,0 +1,218 @@
+package journal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+)
+
+//system accounts, every user also has a wallet account(see WalletAccount)
+const (
+	//source of the credits granted to users
+	AccountPromotional = "promotional_liability"
+	//credits lost by users when they expire
+	AccountForfeit = "expired_forfeit"
+	//credits spent by users
+	AccountRevenue = "revenue"
+)
+
+//kinds of journal entries
+const (
+	KindCredit     = "credit"
+	KindDebit      = "debit"
+	KindExpiry     = "expiry"
+	KindReversal   = "reversal"
+	KindCorrection = "correction"
+)
+
+//returned by Post for an entry whose lines do not balance
+var ErrUnbalanced = errors.New("journal entry is not balanced")
+
+//Line debits or credits a single account of an entry, exactly one of Debit and Credit is set
+type Line struct {
+	Account string
+	Debit   float64
+	Credit  float64
+}
+
+//Entry is a movement of money, the sum of the debits of its lines equals the sum of the credits
+type Entry struct {
+	Kind         string
+	UserId       string
+	TranId       uint64
+	UserCreditId uint64
+	Lines        []Line
+}
+
+//WalletAccount returns the code of the wallet account of the user
+func WalletAccount(userid string) string {
+	return "wallet:" + userid
+}
+
+//Transfer returns an entry of the user moving amount from the debited account to the credited one,
+//a negative amount moves it the other way
+func Transfer(kind, userid string, tranid, usercreditid uint64, amount float64, debited, credited string) Entry {
+	if amount < 0 {
+		amount, debited, credited = -amount, credited, debited
+	}
+	return Entry{
+		Kind:         kind,
+		UserId:       userid,
+		TranId:       tranid,
+		UserCreditId: usercreditid,
+		Lines: []Line{
+			{Account: debited, Debit: amount},
+			{Account: credited, Credit: amount},
+		},
+	}
+}
+
+//Credit returns the entry of a credit granted to the user: Dr promotional liability, Cr wallet
+func Credit(userid string, tranid, usercreditid uint64, amount float64) Entry {
+	return Transfer(KindCredit, userid, tranid, usercreditid, amount, AccountPromotional, WalletAccount(userid))
+}
+
+//Debit returns the entry of a debit of the user consuming amount of a credit: Dr wallet, Cr revenue
+func Debit(userid string, tranid, usercreditid uint64, amount float64) Entry {
+	return Transfer(KindDebit, userid, tranid, usercreditid, amount, WalletAccount(userid), AccountRevenue)
+}
+
+//Expiry returns the entry of the amount left on a credit when it expires: Dr wallet, Cr expired/forfeit
+func Expiry(userid string, usercreditid uint64, amount float64) Entry {
+	return Transfer(KindExpiry, userid, 0, usercreditid, amount, WalletAccount(userid), AccountForfeit)
+}
+
+//Reversal returns the entry of a debit given back to the user: Dr revenue, Cr wallet
+func Reversal(userid string, tranid, usercreditid uint64, amount float64) Entry {
+	return Transfer(KindReversal, userid, tranid, usercreditid, amount, AccountRevenue, WalletAccount(userid))
+}
+
+//Correction returns the entry of a credit whose amount was corrected by difference: Dr promotional liability, Cr wallet
+//when the amount was raised
+func Correction(userid string, usercreditid uint64, difference float64) Entry {
+	return Transfer(KindCorrection, userid, 0, usercreditid, difference, AccountPromotional, WalletAccount(userid))
+}
+
+//Validate checks that every line moves a positive amount on one side only and that the entry balances
+func (e Entry) Validate() error {
+	if len(e.Lines) < 2 {
+		return fmt.Errorf("%w: an entry needs at least two lines", ErrUnbalanced)
+	}
+	var balance int64
+	for _, line := range e.Lines {
+		debit, credit := cents(line.Debit), cents(line.Credit)
+		if debit < 0 || credit < 0 || (debit == 0) == (credit == 0) {
+			return fmt.Errorf("%w: line of account %s must have either a positive debit or a positive credit", ErrUnbalanced, line.Account)
+		}
+		balance += debit - credit
+	}
+	if balance != 0 {
+		return fmt.Errorf("%w: debits and credits differ by %.2f", ErrUnbalanced, float64(balance)/100)
+	}
+	return nil
+}
+
+//Post writes the entry in tx, creating the accounts it uses when missing. an entry moving no money is not written
+//and gets 0 as its id
+func Post(ctx context.Context, tx *sql.Tx, entry Entry) (uint64, error) {
+	if isEmpty(entry) {
+		return 0, nil
+	}
+	if err := entry.Validate(); err != nil {
+		return 0, err
+	}
+
+	var entryId uint64
+	err := tx.QueryRowContext(ctx, entryInsertStatement, entry.Kind, nullString(entry.UserId), nullId(entry.TranId),
+		nullId(entry.UserCreditId)).Scan(&entryId)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range entry.Lines {
+		accountId, err := account(ctx, tx, line.Account, entry.UserId)
+		if err != nil {
+			return 0, err
+		}
+		if _, err = tx.ExecContext(ctx, lineInsertStatement, entryId, accountId, line.Debit, line.Credit); err != nil {
+			return 0, err
+		}
+	}
+	return entryId, nil
+}
+
+//AccountBalance is the line of an account in the trial balance, Balance is debits minus credits
+type AccountBalance struct {
+	Account string  `json:"account"`
+	Debit   float64 `json:"debit"`
+	Credit  float64 `json:"credit"`
+	Balance float64 `json:"balance"`
+}
+
+//TrialBalance lists the total debits and credits of every account, with the wallets of all the users summed up
+//as a single "wallet" account. the journal is consistent when the debits of all the accounts equal their credits
+func TrialBalance(ctx context.Context, db *sql.DB) ([]AccountBalance, error) {
+	rows, err := db.QueryContext(ctx, trialBalanceStatement)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := make([]AccountBalance, 0)
+	for rows.Next() {
+		var b AccountBalance
+		if err := rows.Scan(&b.Account, &b.Debit, &b.Credit, &b.Balance); err != nil {
+			return nil, err
+		}
+		balances = append(balances, b)
+	}
+	return balances, rows.Err()
+}
+
+const (
+	entryInsertStatement = `INSERT INTO tbl_JournalEntries(kind, userid, tranid, usercreditid) VALUES ($1, $2, $3, $4) RETURNING entryid`
+	lineInsertStatement  = `INSERT INTO tbl_JournalLines(entryid, accountid, debit, credit) VALUES ($1, $2, $3, $4)`
+	//inserting only when missing leaves existing accounts untouched, so concurrent postings never conflict on them
+	accountStatement = `WITH created AS (
+		INSERT INTO tbl_Accounts(code, userid) VALUES ($1, $2) ON CONFLICT (code) DO NOTHING RETURNING accountid)
+		SELECT accountid FROM created UNION ALL SELECT accountid FROM tbl_Accounts WHERE code=$1`
+	trialBalanceStatement = `SELECT CASE WHEN a.userid IS NULL THEN a.code ELSE 'wallet' END AS account,
+		SUM(l.debit), SUM(l.credit), SUM(l.debit) - SUM(l.credit)
+		FROM tbl_JournalLines l JOIN tbl_Accounts a ON a.accountid=l.accountid GROUP BY 1 ORDER BY 1`
+)
+
+//function to get the id of the account with the given code, creating it when missing. wallets belong to the user
+//of the entry, system accounts to nobody
+func account(ctx context.Context, tx *sql.Tx, code string, userid string) (uint64, error) {
+	owner := sql.NullString{}
+	if code == WalletAccount(userid) {
+		owner = nullString(userid)
+	}
+	var accountId uint64
+	err := tx.QueryRowContext(ctx, accountStatement, code, owner).Scan(&accountId)
+	return accountId, err
+}
+
+//function to detect an entry moving no money, e.g. a credit of zero amount
+func isEmpty(entry Entry) bool {
+	for _, line := range entry.Lines {
+		if cents(line.Debit) != 0 || cents(line.Credit) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+//function to convert an amount to cents, amounts are stored with two decimals
+func cents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: len(s) > 0}
+}
+
+func nullId(id uint64) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
@@ -0,0 +1,47 @@
+package journal
+
+import (
+	"errors"
+	"testing"
+)
+
+//test case to verify that the entries built for every kind of movement balance and debit the expected account
+func TestEntriesBalance(t *testing.T) {
+	user := "7507decb-0f2d-4510-8202-c78699ed3153"
+	entries := []struct {
+		entry   Entry
+		debited string
+	}{
+		{Credit(user, 1, 1, 5), AccountPromotional},
+		{Debit(user, 2, 1, 1.25), WalletAccount(user)},
+		{Expiry(user, 1, 3.75), WalletAccount(user)},
+		{Reversal(user, 3, 1, 1.25), AccountRevenue},
+		{Correction(user, 1, -0.5), WalletAccount(user)},
+	}
+	for _, e := range entries {
+		if err := e.entry.Validate(); err != nil {
+			t.Errorf("Expected %s entry to balance. Got %v", e.entry.Kind, err)
+		}
+		if e.entry.Lines[0].Account != e.debited || e.entry.Lines[0].Debit <= 0 {
+			t.Errorf("Expected %s entry to debit %s. Got %+v", e.entry.Kind, e.debited, e.entry.Lines)
+		}
+	}
+}
+
+//test case to verify that unbalanced entries and lines with both or neither side set are rejected
+func TestValidateRejectsUnbalanced(t *testing.T) {
+	invalid := []Entry{
+		{Lines: []Line{{Account: "a", Debit: 1}}},
+		{Lines: []Line{{Account: "a", Debit: 1}, {Account: "b", Credit: 0.99}}},
+		{Lines: []Line{{Account: "a", Debit: 1, Credit: 1}, {Account: "b", Credit: 0}}},
+		{Lines: []Line{{Account: "a", Debit: -1}, {Account: "b", Credit: -1}}},
+	}
+	for _, entry := range invalid {
+		if err := entry.Validate(); !errors.Is(err, ErrUnbalanced) {
+			t.Errorf("Expected ErrUnbalanced for %+v. Got %v", entry.Lines, err)
+		}
+	}
+	if !isEmpty(Credit("user", 1, 1, 0)) {
+		t.Error("Expected a credit of zero amount to be empty")
+	}
+}
@@ -0,0 +1,194 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Level is the severity of a log entry, entries below the configured level are dropped
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+//output formats supported by the logger
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	}
+	return "error"
+}
+
+//ParseLevel converts a level name(debug, info, warn or error) to a Level
+func ParseLevel(name string) (Level, error) {
+	for l := LevelDebug; l <= LevelError; l++ {
+		if strings.EqualFold(name, l.String()) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+//Redactor is implemented by values carrying personal data, the logger writes the value returned by Redact instead
+type Redactor interface {
+	Redact() interface{}
+}
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+//WithRequestID returns a copy of ctx carrying the request id, which is then added to every entry logged with it
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+//RequestID returns the request id carried by ctx, or an empty string
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+//global logger settings, changed with Init
+var (
+	mu     sync.Mutex
+	out    io.Writer = os.Stdout
+	level            = LevelInfo
+	format           = FormatJSON
+)
+
+//Init configures the level, format and destination of the log entries
+func Init(l Level, f string, w io.Writer) error {
+	if f != FormatJSON && f != FormatLogfmt {
+		return fmt.Errorf("unknown log format %q", f)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	level, format, out = l, f, w
+	return nil
+}
+
+//Enabled reports whether entries of the given level are written
+func Enabled(l Level) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return l >= level
+}
+
+//Debug logs a message with alternating key and value pairs
+func Debug(ctx context.Context, msg string, keyvals ...interface{}) {
+	write(ctx, LevelDebug, msg, keyvals)
+}
+
+//Info logs a message with alternating key and value pairs
+func Info(ctx context.Context, msg string, keyvals ...interface{}) {
+	write(ctx, LevelInfo, msg, keyvals)
+}
+
+//Warn logs a message with alternating key and value pairs
+func Warn(ctx context.Context, msg string, keyvals ...interface{}) {
+	write(ctx, LevelWarn, msg, keyvals)
+}
+
+//Error logs a message with alternating key and value pairs
+func Error(ctx context.Context, msg string, keyvals ...interface{}) {
+	write(ctx, LevelError, msg, keyvals)
+}
+
+//function to format and write a single entry, fields keep the order in which they were given
+func write(ctx context.Context, l Level, msg string, keyvals []interface{}) {
+	if !Enabled(l) {
+		return
+	}
+	fields := []interface{}{"time", time.Now().UTC().Format(time.RFC3339Nano), "level", l.String(), "msg", msg}
+	if ctx != nil {
+		if id := RequestID(ctx); len(id) > 0 {
+			fields = append(fields, "request_id", id)
+		}
+	}
+	if len(keyvals)%2 != 0 {
+		keyvals = append(keyvals, "(missing)")
+	}
+	fields = append(fields, keyvals...)
+
+	mu.Lock()
+	defer mu.Unlock()
+	var line string
+	if format == FormatLogfmt {
+		line = logfmt(fields)
+	} else {
+		line = jsonLine(fields)
+	}
+	io.WriteString(out, line+"\n")
+}
+
+//function to convert a field value into something safe to write
+func value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case Redactor:
+		return t.Redact()
+	case error:
+		return t.Error()
+	case time.Duration:
+		return t.String()
+	}
+	return v
+}
+
+func jsonLine(fields []interface{}) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(fields[i]))
+		val, err := json.Marshal(value(fields[i+1]))
+		if err != nil {
+			val, _ = json.Marshal(fmt.Sprintf("%+v", fields[i+1]))
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(val)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func logfmt(fields []interface{}) string {
+	var b strings.Builder
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(fmt.Sprint(fields[i]))
+		b.WriteByte('=')
+		val := fmt.Sprintf("%+v", value(fields[i+1]))
+		if len(val) == 0 || strings.ContainsAny(val, " =\"\t\n") {
+			val = strconv.Quote(val)
+		}
+		b.WriteString(val)
+	}
+	return b.String()
+}
@@ -0,0 +1,62 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/a0rana/UserAccountService/models"
+)
+
+//test case to verify json entries carry the request id, the fields and redacted personal data
+func TestJSONEntry(t *testing.T) {
+	var buf bytes.Buffer
+	Init(LevelDebug, FormatJSON, &buf)
+	defer Init(LevelInfo, FormatJSON, os.Stdout)
+
+	user := models.User{UserId: "user-1", FirstName: "John", Email: "john.doe@gmail.com"}
+	Info(WithRequestID(context.Background(), "req-1"), "fetching history", "user", user, "error", errors.New("boom"))
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a json entry. Got %s", buf.String())
+	}
+	if entry["level"] != "info" || entry["msg"] != "fetching history" || entry["request_id"] != "req-1" || entry["error"] != "boom" {
+		t.Errorf("Expected level, msg, request_id and error fields. Got %v", entry)
+	}
+	if strings.Contains(buf.String(), "john.doe") || !strings.Contains(buf.String(), "user-1") {
+		t.Errorf("Expected personal data to be redacted and user id kept. Got %s", buf.String())
+	}
+}
+
+//test case to verify logfmt quoting and level filtering
+func TestLogfmtEntry(t *testing.T) {
+	var buf bytes.Buffer
+	Init(LevelWarn, FormatLogfmt, &buf)
+	defer Init(LevelInfo, FormatJSON, os.Stdout)
+
+	Info(context.Background(), "dropped")
+	Warn(context.Background(), "slow query", "query", "SELECT 1", "rows", 3)
+
+	line := buf.String()
+	if strings.Contains(line, "dropped") {
+		t.Errorf("Expected info entry to be dropped at warn level. Got %s", line)
+	}
+	if !strings.Contains(line, `level=warn msg="slow query" query="SELECT 1" rows=3`) {
+		t.Errorf("Expected logfmt fields. Got %s", line)
+	}
+}
+
+//test case to verify parsing of level names
+func TestParseLevel(t *testing.T) {
+	if l, err := ParseLevel("DEBUG"); err != nil || l != LevelDebug {
+		t.Errorf("Expected debug level. Got %v, %v", l, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+}
@@ -1,16 +1,131 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"github.com/a0rana/UserAccountService/config"
+	"github.com/a0rana/UserAccountService/database"
+	"github.com/a0rana/UserAccountService/logger"
+	"github.com/a0rana/UserAccountService/middleware"
+	"github.com/a0rana/UserAccountService/migrations"
 	"github.com/a0rana/UserAccountService/router"
+	"github.com/a0rana/UserAccountService/scheduledjob"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
-	r := router.Router()
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = cfg.InitLogger(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
 
-	fmt.Println("Starting server on the port 8080...")
+	db, err := database.Open(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
 
-	log.Fatal(http.ListenAndServe(":8080", r))
+	//"migrate" subcommand runs the schema migrations and exits instead of starting the server
+	if len(cfg.Args) > 0 && cfg.Args[0] == "migrate" {
+		if err = migrate(context.Background(), db, cfg.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if cfg.Database.MigrateOnStart {
+		if _, err = migrations.Up(context.Background(), db); err != nil {
+			log.Fatal(err)
+		}
+	}
+	//refuse to start on a schema this build was not written for
+	if err = migrations.Check(context.Background(), db); err != nil {
+		log.Fatal(err)
+	}
+
+	database.RegisterMetrics(db)
+
+	if err = middleware.Init(cfg, db); err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.ExpiryJob.Enabled {
+		stopJob := scheduledjob.Start(db, cfg.ExpiryJob.Interval)
+		defer func() { stopJob <- true }()
+	}
+	if cfg.ReconcileJob.Enabled {
+		stopReconcile := scheduledjob.StartReconciliation(db, cfg.ReconcileJob.Interval, cfg.ReconcileJob.Correct)
+		defer func() { stopReconcile <- true }()
+	}
+
+	server := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           router.Router(),
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		logger.Error(context.Background(), "unable to listen", "port", cfg.Port, "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-stop
+		logger.Info(context.Background(), "shutdown signal received", "signal", sig.String())
+		cancel()
+	}()
+
+	logger.Info(context.Background(), "starting server", "port", cfg.Port)
+	if err = serve(ctx, server, listener, cfg.Server); err != nil {
+		logger.Error(context.Background(), "server stopped unexpectedly", "error", err)
+	}
+}
+
+//function to serve the requests accepted on listener until ctx is done, then to stop the server gracefully.
+//returns the error of a server that stopped on its own, nil once it was shut down
+func serve(ctx context.Context, server *http.Server, listener net.Listener, cfg config.ServerConfig) error {
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serverErr:
+		return err
+	case <-ctx.Done():
+	}
+	shutdown(server, cfg)
+	return nil
+}
+
+//function to stop the server gracefully: readiness fails first so no new traffic is routed here, then the listener
+//is closed and in-flight requests(debit transactions) are given until the shutdown timeout to complete
+func shutdown(server *http.Server, cfg config.ServerConfig) {
+	middleware.SetDraining()
+	time.Sleep(cfg.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error(ctx, "in-flight requests did not complete before the shutdown timeout", "error", err)
+		server.Close()
+		return
+	}
+	logger.Info(ctx, "server stopped gracefully")
 }
@@ -2,23 +2,52 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
+	"github.com/a0rana/UserAccountService/auth"
+	"github.com/a0rana/UserAccountService/config"
+	"github.com/a0rana/UserAccountService/database"
+	"github.com/a0rana/UserAccountService/journal"
+	"github.com/a0rana/UserAccountService/middleware"
+	"github.com/a0rana/UserAccountService/migrations"
+	"github.com/a0rana/UserAccountService/models"
 	"github.com/a0rana/UserAccountService/router"
-	"github.com/joho/godotenv"
+	"github.com/a0rana/UserAccountService/scheduledjob"
+	"io/ioutil"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 var db *sql.DB
 
-//integration test cases for the rest-api
+//secret signing the bearer tokens of the test requests
+const testJWTSecret = "integration-test-secret-0123456789abcdef"
+
+//integration test cases for the rest-api, the database is taken from the config(POSTGRES_DBNAME or POSTGRES_DSN)
+//so make sure it points to the sandbox database before running them
 func TestMain(m *testing.M) {
-	db = createConnection()
+	os.Setenv("AUTH_ENABLED", "true")
+	os.Setenv("JWT_HS256_SECRET", testJWTSecret)
+	//the test cases send requests on the same users faster than any production limit would allow
+	os.Setenv("RATE_LIMIT_BACKEND", "none")
+	cfg, err := config.Load(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if db, err = database.Open(cfg.Database); err != nil {
+		log.Fatal(err)
+	}
+	if err = middleware.Init(cfg, db); err != nil {
+		log.Fatal(err)
+	}
 	ensureTableExists()
 	clearTable()
 	createUser()
@@ -68,6 +97,21 @@ func TestPostUserDebit(t *testing.T) {
 	}
 }
 
+//test case to verify credits and debits of a user that does not exist are rejected as not found
+func TestUnknownUser(t *testing.T) {
+	for path, body := range map[string]string{
+		"/credit": `{"userid":"00000000-0000-0000-0000-000000000000","amount":5,"transactiontype":"Refund","priority":5,"expiry":"2099-01-01 00:00:00"}`,
+		"/debit":  `{"userid":"00000000-0000-0000-0000-000000000000","amount":5}`,
+	} {
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer([]byte(body)))
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusNotFound, response.Code)
+		if !strings.Contains(response.Body.String(), `"code":"user_not_found"`) {
+			t.Errorf("Expected %s of an unknown user to be rejected. Got %s", path, response.Body.String())
+		}
+	}
+}
+
 //test case to verify if user activity is generated after processing credits and debits
 func TestUserActivity(t *testing.T) {
 	userid := getUser()
@@ -82,67 +126,461 @@ func TestUserActivity(t *testing.T) {
 	}
 }
 
-//----------------------------- helper methods ------------------------------------
-//function to execute the http request, after invoking the matched route's handler
-func executeRequest(req *http.Request) *httptest.ResponseRecorder {
+//test case to verify the balance breakdown keeps the original and consumed amounts of the credits
+func TestUserBalance(t *testing.T) {
+	userid := getUser()
+	var jsonStr = []byte(fmt.Sprint(`{"userid":"`, userid, `"}`))
+	req, _ := http.NewRequest("GET", "/balance", bytes.NewBuffer(jsonStr))
+	response := executeRequest(req)
+
+	checkResponseCode(t, http.StatusOK, response.Code)
+	if body := response.Body.String(); !strings.Contains(body, `"original_amount":5`) || !strings.Contains(body, `"consumed_amount"`) {
+		t.Errorf("Expected original and consumed amounts of the user's credit. Got %s", body)
+	}
+}
+
+//test case to verify the credit made by TestPostUserCredit is audited with its actor and state, and that only
+//admins can read the audit log
+func TestAuditLog(t *testing.T) {
+	req, _ := http.NewRequest("GET", fmt.Sprint("/admin/audit?action=credit&userid=", getUser()), nil)
+	response := executeRequest(req)
+
+	checkResponseCode(t, http.StatusOK, response.Code)
+	if body := response.Body.String(); !strings.Contains(body, `"actor":"jwt:integration-test"`) || !strings.Contains(body, `"after":{"amount":5`) {
+		t.Errorf("Expected the credit of the user to be audited. Got %s", body)
+	}
+
+	req, _ = http.NewRequest("GET", "/admin/audit", nil)
+	checkResponseCode(t, http.StatusForbidden, executeRequestAs(req, auth.Claims{Subject: "app", Scope: auth.ScopeRead}).Code)
+}
+
+//test case to verify the activity of the user is hash chained and its head is exposed
+func TestActivityChain(t *testing.T) {
+	userid := getUser()
+	req, _ := http.NewRequest("GET", "/chain/head", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `"}`))))
+	response := executeRequest(req)
+
+	checkResponseCode(t, http.StatusOK, response.Code)
+	if body := response.Body.String(); !strings.Contains(body, `"hash":"`) || strings.Contains(body, `"length":0`) {
+		t.Errorf("Expected the chain head of the user. Got %s", body)
+	}
+	report, err := middleware.VerifyChain(context.Background(), userid)
+	if err != nil || !report.Intact || report.Length == 0 {
+		t.Errorf("Expected an intact chain. Got %+v, %v", report, err)
+	}
+}
+
+//test case to verify a spending limit override set through the admin api blocks the debits going beyond it
+func TestSpendingLimits(t *testing.T) {
+	userid := getUser()
+	req, _ := http.NewRequest("POST", "/admin/limits", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","max_single_debit":1,"reason":"fraud review"}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+
+	req, _ = http.NewRequest("POST", "/debit", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","amount":2}`))))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusUnprocessableEntity, response.Code)
+	if body := response.Body.String(); !strings.Contains(body, `"code":"spending_limit_exceeded"`) {
+		t.Errorf("Expected the debit to be blocked by the spending limit. Got %s", body)
+	}
+
+	req, _ = http.NewRequest("GET", fmt.Sprint("/admin/limits?userid=", userid), nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	if body := response.Body.String(); !strings.Contains(body, `"user_override":{"userid":"`) || !strings.Contains(body, `"limits":{"max_single_debit":1,`) {
+		t.Errorf("Expected the override of the user. Got %s", body)
+	}
+
+	req, _ = http.NewRequest("POST", "/admin/limits", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `"}`))))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/admin/limits", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","reason":"review closed"}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/admin/limits", bytes.NewBuffer([]byte(`{"segment":"enterprise","max_daily_total":-1,"reason":"contract"}`)))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req).Code)
+
+	for path, body := range map[string]string{
+		"/admin/limits":  `{"userid":"00000000-0000-0000-0000-000000000000","max_single_debit":1,"reason":"fraud review"}`,
+		"/admin/segment": `{"userid":"00000000-0000-0000-0000-000000000000","segment":"enterprise","reason":"contract"}`,
+	} {
+		req, _ = http.NewRequest("POST", path, bytes.NewBuffer([]byte(body)))
+		response = executeRequest(req)
+		checkResponseCode(t, http.StatusNotFound, response.Code)
+		if !strings.Contains(response.Body.String(), `"code":"user_not_found"`) {
+			t.Errorf("Expected %s to reject an unknown user. Got %s", path, response.Body.String())
+		}
+	}
+}
+
+//test case to verify a frozen account cannot be debited but can still be credited, and the change is in its history
+func TestFrozenAccount(t *testing.T) {
+	userid := getUser()
+	req, _ := http.NewRequest("POST", "/admin/status", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","status":"frozen"}`))))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/admin/status", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","status":"frozen","reason":"suspected fraud"}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+
+	req, _ = http.NewRequest("POST", "/debit", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","amount":1}`))))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusConflict, response.Code)
+	if body := response.Body.String(); !strings.Contains(body, `"code":"account_frozen"`) {
+		t.Errorf("Expected the debit of a frozen account to be rejected. Got %s", body)
+	}
+	req, _ = http.NewRequest("POST", "/credit", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","amount":1,"transactiontype":"Refund","priority":1,"expiry":"2099-01-01 00:00:00"}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/transactions", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `"}`))))
+	if body := executeRequest(req).Body.String(); !strings.Contains(body, `"event":"status:frozen"`) {
+		t.Errorf("Expected the freeze in the activity of the user. Got %s", body)
+	}
+	if report, err := middleware.VerifyChain(context.Background(), userid); err != nil || !report.Intact {
+		t.Errorf("Expected the status change to be chained. Got %+v, %v", report, err)
+	}
+
+	req, _ = http.NewRequest("POST", "/admin/status", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","status":"active","reason":"cleared"}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+
+	req, _ = http.NewRequest("POST", "/admin/status", bytes.NewBuffer([]byte(`{"userid":"00000000-0000-0000-0000-000000000000","status":"frozen","reason":"suspected fraud"}`)))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+	if body := response.Body.String(); !strings.Contains(body, `"code":"user_not_found"`) {
+		t.Errorf("Expected the status of an unknown user to be rejected. Got %s", body)
+	}
+}
+
+//test case to verify a credit restricted to a category is only consumed by debits made in it
+func TestCreditCategories(t *testing.T) {
+	userid := getUser()
+	req, _ := http.NewRequest("POST", "/credit", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","amount":10,"transactiontype":"Promo","priority":9,"expiry":"2099-01-01 00:00:00","allowed_categories":["Food"]}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+
+	req, _ = http.NewRequest("POST", "/debit", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","amount":5,"category":"travel"}`))))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusUnprocessableEntity, response.Code)
+	if body := response.Body.String(); !strings.Contains(body, `"code":"ineligible_credit"`) {
+		t.Errorf("Expected the promo credit not to be spent on travel. Got %s", body)
+	}
+	req, _ = http.NewRequest("POST", "/debit", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","amount":5,"category":"food","merchant":"Corner Bistro"}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/transactions", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `"}`))))
+	if body := executeRequest(req).Body.String(); !strings.Contains(body, `"merchant":"Corner Bistro","category":"food"`) {
+		t.Errorf("Expected the debit to keep its merchant and category. Got %s", body)
+	}
+}
+
+//test case to verify a user with an overdraft limit can debit beyond its credits and the next credit repays it first
+func TestOverdraft(t *testing.T) {
+	userid := getUser()
+	req, _ := http.NewRequest("POST", "/admin/overdraft", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","limit":5,"reason":"enterprise agreement"}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+
+	balance, err := middleware.GetUserBalance(context.Background(), userid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ = http.NewRequest("POST", "/debit", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","amount":`, balance.Available+5.01, `,"category":"food"}`))))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusUnprocessableEntity, response.Code)
+	if body := response.Body.String(); !strings.Contains(body, `"code":"insufficient_credit"`) {
+		t.Errorf("Expected a debit beyond the overdraft limit to be rejected. Got %s", body)
+	}
+	req, _ = http.NewRequest("POST", "/debit", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","amount":`, balance.Available+3, `,"category":"food"}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+	if balance, err = middleware.GetUserBalance(context.Background(), userid); err != nil || balance.Available != 0 || balance.Overdrawn != 3 {
+		t.Errorf("Expected the user to be 3 overdrawn. Got %+v, %v", balance, err)
+	}
+
+	req, _ = http.NewRequest("POST", "/credit", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","amount":5,"transactiontype":"Refund","priority":1,"expiry":"2099-01-01 00:00:00"}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+	if balance, err = middleware.GetUserBalance(context.Background(), userid); err != nil || balance.Available != 2 || balance.Overdrawn != 0 {
+		t.Errorf("Expected the credit to repay the overdraft first. Got %+v, %v", balance, err)
+	}
+
+	ledger, err := middleware.GetLedger(context.Background(), userid)
+	var overdrafts int
+	for _, activity := range ledger.Activities {
+		if activity.OverdraftId != 0 {
+			overdrafts++
+		}
+	}
+	if err != nil || overdrafts != 2 {
+		t.Errorf("Expected the overdraft and its repayment in the activity of the user. Got %d, %v", overdrafts, err)
+	}
+	if report, err := middleware.VerifyChain(context.Background(), userid); err != nil || !report.Intact {
+		t.Errorf("Expected the overdraft and its repayment to be chained. Got %+v, %v", report, err)
+	}
+
+	//a credit granted already expired cannot repay, one about to expire repays as it is granted
+	req, _ = http.NewRequest("POST", "/debit", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","amount":4}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/credit", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","amount":5,"transactiontype":"Refund","priority":1,"expiry":"2000-01-01 00:00:00"}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+	if balance, err = middleware.GetUserBalance(context.Background(), userid); err != nil || balance.Overdrawn != 2 {
+		t.Errorf("Expected an expired credit not to repay the overdraft. Got %+v, %v", balance, err)
+	}
+	expiry := time.Now().UTC().Add(time.Minute).Format("2006-01-02 15:04:05")
+	req, _ = http.NewRequest("POST", "/credit", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","amount":1,"transactiontype":"Refund","priority":1,"expiry":"`, expiry, `"}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+	if balance, err = middleware.GetUserBalance(context.Background(), userid); err != nil || balance.Overdrawn != 1 {
+		t.Errorf("Expected a credit about to expire to repay the overdraft in part. Got %+v, %v", balance, err)
+	}
+	req, _ = http.NewRequest("POST", "/credit", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","amount":1,"transactiontype":"Refund","priority":1,"expiry":"2099-01-01 00:00:00"}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+
+	req, _ = http.NewRequest("POST", "/admin/overdraft", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","limit":0,"reason":"agreement ended"}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+
+	req, _ = http.NewRequest("POST", "/admin/overdraft", bytes.NewBuffer([]byte(`{"userid":"00000000-0000-0000-0000-000000000000","limit":5,"reason":"enterprise agreement"}`)))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+	if body := response.Body.String(); !strings.Contains(body, `"code":"user_not_found"`) {
+		t.Errorf("Expected the overdraft of an unknown user to be rejected. Got %s", body)
+	}
+}
+
+//test case to verify the api rejects requests without credentials
+func TestUnauthenticated(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/debit", bytes.NewBuffer([]byte(`{"userid":"","amount":5}`)))
 	rr := httptest.NewRecorder()
 	router.Router().ServeHTTP(rr, req)
 
-	return rr
+	checkResponseCode(t, http.StatusUnauthorized, rr.Code)
 }
 
-//function to match expected and actual response code after running the test case
-func checkResponseCode(t *testing.T, expected, actual int) {
-	if expected != actual {
-		t.Errorf("Expected response code %d. Got %d\n", expected, actual)
+//test case to verify user-scoped tokens can debit their own user but not credit it or read another user
+func TestUserScopedToken(t *testing.T) {
+	claims := auth.Claims{Subject: "app", Scope: "ledger:read ledger:debit", UserId: getUser()}
+
+	req, _ := http.NewRequest("POST", "/credit", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, getUser(), `","amount":5}`))))
+	checkResponseCode(t, http.StatusForbidden, executeRequestAs(req, claims).Code)
+
+	req, _ = http.NewRequest("GET", "/transactions", bytes.NewBuffer([]byte(`{"userid":"2a1f5c1e-8d3b-4f0a-9c55-1b7f0e4d2c6a"}`)))
+	checkResponseCode(t, http.StatusForbidden, executeRequestAs(req, claims).Code)
+
+	req, _ = http.NewRequest("GET", "/transactions", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, getUser(), `"}`))))
+	checkResponseCode(t, http.StatusOK, executeRequestAs(req, claims).Code)
+}
+
+//test case to verify the service reports itself ready once database, schema and cache are set up
+func TestReadiness(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	response := executeRequest(req)
+
+	checkResponseCode(t, http.StatusOK, response.Code)
+	if body := response.Body.String(); !strings.Contains(body, `"status":"ok"`) {
+		t.Errorf("Expected service to be ready. Got %s", body)
+	}
+}
+
+//test case to verify the reconciliation reports a credit whose amount does not match its activity, leaves it
+//untouched in a dry run and corrects it otherwise
+func TestReconcile(t *testing.T) {
+	userid := createTestUser()
+	req, _ := http.NewRequest("POST", "/credit", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","amount":5,"transactiontype":"Refund","priority":1,"expiry":"2099-01-01 00:00:00"}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/debit", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","amount":2}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+
+	//the activity of the credit adds up to 5 - 2 + 0 = 3
+	var usercreditid uint64
+	if err := db.QueryRow(`UPDATE tbl_UserCredits SET amount=4 WHERE userid=$1 RETURNING usercreditid`, userid).Scan(&usercreditid); err != nil {
+		t.Fatal(err)
+	}
+	find := func(discrepancies []models.CreditDiscrepancy) *models.CreditDiscrepancy {
+		for i := range discrepancies {
+			if discrepancies[i].UserCreditId == usercreditid {
+				return &discrepancies[i]
+			}
+		}
+		return nil
+	}
+	stored := func() (amount float64, corrections int) {
+		db.QueryRow(`SELECT amount FROM tbl_UserCredits WHERE usercreditid=$1`, usercreditid).Scan(&amount)
+		db.QueryRow(`SELECT COUNT(*) FROM tbl_LedgerCorrections WHERE usercreditid=$1`, usercreditid).Scan(&corrections)
+		return amount, corrections
+	}
+	opts := scheduledjob.ReconcileOptions{Correct: true, Actor: "integration-test", Reason: "reconciliation test"}
+
+	discrepancies, err := scheduledjob.Reconcile(database.WithDryRun(context.Background()), db, opts)
+	d := find(discrepancies)
+	if err != nil || d == nil || d.UserId != userid || d.Amount != 4 || d.Expected != 3 || d.Original != 5 || d.Debited != 2 || d.Reversed != 0 {
+		t.Fatalf("Expected the credit to be reported with an expected amount of 3. Got %+v, %v", d, err)
+	}
+	if amount, corrections := stored(); amount != 4 || corrections != 0 {
+		t.Errorf("Expected a dry run to leave the credit untouched. Got an amount of %v and %d corrections", amount, corrections)
+	}
+
+	discrepancies, err = scheduledjob.Reconcile(context.Background(), db, opts)
+	if d = find(discrepancies); err != nil || d == nil || d.CorrectionId == 0 {
+		t.Fatalf("Expected the credit to be corrected. Got %+v, %v", d, err)
+	}
+	var previous, corrected float64
+	var actor, reason string
+	err = db.QueryRow(`SELECT previousamount, correctedamount, actor, reason FROM tbl_LedgerCorrections WHERE correctionid=$1`,
+		d.CorrectionId).Scan(&previous, &corrected, &actor, &reason)
+	if err != nil || previous != 4 || corrected != 3 || actor != opts.Actor || reason != opts.Reason {
+		t.Errorf("Expected the correction to be recorded. Got %v, %v, %s, %s, %v", previous, corrected, actor, reason, err)
+	}
+	if amount, corrections := stored(); amount != 3 || corrections != 1 {
+		t.Errorf("Expected the credit to be corrected once. Got an amount of %v and %d corrections", amount, corrections)
+	}
+
+	if discrepancies, err = scheduledjob.Reconcile(context.Background(), db, scheduledjob.ReconcileOptions{}); err != nil || find(discrepancies) != nil {
+		t.Errorf("Expected the corrected credit to match its activity. Got %+v, %v", find(discrepancies), err)
 	}
 }
 
-// create connection with postgres db
-func createConnection() *sql.DB {
-	// load .env file
-	err := godotenv.Load(".env")
+//test case to verify a credit, a debit and its reversal are posted to the journal as balanced entries, leaving the
+//wallet of the user with its balance and the trial balance at zero
+func TestJournal(t *testing.T) {
+	userid := createTestUser()
+	req, _ := http.NewRequest("POST", "/credit", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","amount":5,"transactiontype":"Refund","priority":1,"expiry":"2099-01-01 00:00:00"}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/debit", bytes.NewBuffer([]byte(fmt.Sprint(`{"userid":"`, userid, `","amount":2}`))))
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+	ledger, err := middleware.GetLedger(context.Background(), userid)
+	if err != nil || len(ledger.Activities) != 2 {
+		t.Fatalf("Expected the credit and the debit of the user. Got %+v, %v", ledger.Activities, err)
+	}
+	debit := ledger.Activities[0]
+	if debit.IsCredit {
+		debit = ledger.Activities[1]
+	}
+	if _, err = middleware.ReverseDebit(context.Background(), userid, debit.TranId, "journal test"); err != nil {
+		t.Fatal(err)
+	}
 
+	rows, err := db.Query(`SELECT e.kind, SUM(l.debit), SUM(l.credit) FROM tbl_JournalEntries e JOIN tbl_JournalLines l ON l.entryid=e.entryid
+		WHERE e.userid=$1 GROUP BY e.entryid, e.kind ORDER BY e.entryid`, userid)
 	if err != nil {
-		log.Fatalf("Error loading .env file")
+		t.Fatal(err)
+	}
+	kinds := make([]string, 0)
+	for rows.Next() {
+		var kind string
+		var debits, credits float64
+		rows.Scan(&kind, &debits, &credits)
+		if debits != credits {
+			t.Errorf("Expected the %s entry to balance. Got %v debited and %v credited", kind, debits, credits)
+		}
+		kinds = append(kinds, kind)
+	}
+	rows.Close()
+	if strings.Join(kinds, ",") != "credit,debit,reversal" {
+		t.Errorf("Expected a credit, a debit and a reversal entry. Got %v", kinds)
 	}
 
-	connectionString := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=TestUserAccount sslmode=%s",
-		os.Getenv("POSTGRES_HOST"), os.Getenv("POSTGRES_PORT"), os.Getenv("POSTGRES_USER"),
-		os.Getenv("POSTGRES_PASSWORD"), os.Getenv("POSTGRES_SSLMODE"))
+	var wallet float64
+	err = db.QueryRow(`SELECT SUM(l.credit) - SUM(l.debit) FROM tbl_JournalLines l JOIN tbl_Accounts a ON a.accountid=l.accountid WHERE a.code=$1`,
+		journal.WalletAccount(userid)).Scan(&wallet)
+	if err != nil || wallet != 5 {
+		t.Errorf("Expected the wallet of the user to hold the reversed debit. Got %v, %v", wallet, err)
+	}
 
-	// Open the connection
-	db, err := sql.Open("postgres", connectionString)
+	balances, err := journal.TrialBalance(context.Background(), db)
+	var debits, credits float64
+	for _, b := range balances {
+		debits += b.Debit
+		credits += b.Credit
+	}
+	if err != nil || len(balances) == 0 || math.Round(debits*100) != math.Round(credits*100) {
+		t.Errorf("Expected the trial balance to net to zero. Got %+v, %v", balances, err)
+	}
+}
 
+//test case to verify a request in flight when the shutdown starts completes while new connections are refused.
+//the service is left draining, so this test case runs after the ones checking readiness
+func TestGracefulShutdown(t *testing.T) {
+	started, release := make(chan bool), make(chan bool)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+		w.Write([]byte("completed"))
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- serve(ctx, server, listener, config.ServerConfig{ShutdownTimeout: 5 * time.Second})
+	}()
 
-	// check the connection
-	err = db.Ping()
+	inFlight := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			inFlight <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		inFlight <- string(body)
+	}()
+	<-started
+	cancel()
 
+	refused := false
+	for deadline := time.Now().Add(5 * time.Second); !refused && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if refused = err != nil; !refused {
+			conn.Close()
+		}
+	}
+	if !refused {
+		t.Error("Expected new connections to be refused once the shutdown started")
+	}
+
+	close(release)
+	if body := <-inFlight; body != "completed" {
+		t.Errorf("Expected the in-flight request to complete. Got %s", body)
+	}
+	if err = <-stopped; err != nil {
+		t.Errorf("Expected the server to be shut down. Got %v", err)
+	}
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	checkResponseCode(t, http.StatusServiceUnavailable, executeRequest(req).Code)
+}
+
+//----------------------------- helper methods ------------------------------------
+//function to execute the http request as an admin, after invoking the matched route's handler
+func executeRequest(req *http.Request) *httptest.ResponseRecorder {
+	return executeRequestAs(req, auth.Claims{Subject: "integration-test", Scope: auth.ScopeAdmin})
+}
+
+//function to execute the http request with a token carrying the given claims
+func executeRequestAs(req *http.Request, claims auth.Claims) *httptest.ResponseRecorder {
+	claims.ExpiresAt = time.Now().Add(time.Minute).Unix()
+	token, err := auth.SignHS256(claims, []byte(testJWTSecret))
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	router.Router().ServeHTTP(rr, req)
 
-	fmt.Println("Successfully connected to the database!")
-	// return the connection
-	return db
+	return rr
 }
 
-//function to check for tables in db, if not present then create same, if present then ignore
+//function to match expected and actual response code after running the test case
+func checkResponseCode(t *testing.T, expected, actual int) {
+	if expected != actual {
+		t.Errorf("Expected response code %d. Got %d\n", expected, actual)
+	}
+}
+
+//function to bring the test database schema to the latest version
 func ensureTableExists() {
-	for _, query := range getTableCreationQueries() {
-		if _, err := db.Exec(query); err != nil {
-			log.Fatal(err)
-		}
+	if _, err := migrations.Up(context.Background(), db); err != nil {
+		log.Fatal(err)
 	}
 }
 
 //function to fetch the singular user created while executing the test cases
 func getUser() string {
 	var userid string
-	rows, err := db.Query(`SELECT userid FROM tbl_users LIMIT 1`)
+	rows, err := db.Query(`SELECT userid FROM tbl_users WHERE email='john.doe@gmail.com' LIMIT 1`)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -162,52 +600,32 @@ func createUser() {
 	}
 }
 
+//function to insert another user for the test cases that need one of their own, returns its id
+func createTestUser() string {
+	var userid string
+	err := db.QueryRow(`INSERT INTO tbl_Users(fname, lname, email, dob, mobile) VALUES('Jane', 'Doe', 'jane.doe@gmail.com', '1990-04-22', '9994447879') RETURNING userid`).Scan(&userid)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return userid
+}
+
 //function to delete the tables and reset the sequences for the auto increment ids
 func clearTable() {
+	db.Exec("DELETE FROM tbl_journallines")
+	db.Exec("DELETE FROM tbl_journalentries")
+	db.Exec("DELETE FROM tbl_accounts")
+	db.Exec("DELETE FROM tbl_ledgercorrections")
+	db.Exec("DELETE FROM tbl_apikeys")
+	db.Exec("DELETE FROM tbl_spendinglimits")
 	db.Exec("DELETE FROM tbl_activity")
+	db.Exec("DELETE FROM tbl_overdrafts")
 	db.Exec("DELETE FROM tbl_usercredits")
 	db.Exec("DELETE FROM tbl_Users")
 	db.Exec("ALTER SEQUENCE tbl_users_userid_seq RESTART")
 	db.Exec("ALTER SEQUENCE tbl_usercredits_usercreditid_seq RESTART")
 	db.Exec("ALTER SEQUENCE tbl_activity_tranid_seq RESTART")
-}
-
-//function to fetch create table queries
-func getTableCreationQueries() []string {
-	tableCreationQuery := make([]string, 3)
-	tableCreationQuery[0] = `CREATE TABLE IF NOT EXISTS tbl_Users
-	(
-		userid UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-		fname  VARCHAR(20),
-		lname  VARCHAR(20),
-		email  VARCHAR(30),
-		dob    DATE,
-		mobile VARCHAR(10)
-	)`
-	tableCreationQuery[1] = `CREATE TABLE IF NOT EXISTS tbl_UserCredits
-	(
-		userid          UUID REFERENCES tbl_Users (userid),
-		usercreditid    BIGSERIAL UNIQUE,
-		updated         TIMESTAMP WITHOUT TIME ZONE DEFAULT (NOW() AT TIME ZONE 'UTC'),
-		created         TIMESTAMP WITHOUT TIME ZONE DEFAULT (NOW() AT TIME ZONE 'UTC'),
-		amount          NUMERIC(10, 2) NOT NULL,
-		transactiontype VARCHAR(10),
-		priority        INTEGER,
-		expiry          TIMESTAMP WITHOUT TIME ZONE NOT NULL,
-		isexpired       BOOLEAN DEFAULT FALSE,
-		PRIMARY KEY (userid, usercreditid)
-	)`
-	tableCreationQuery[2] = `CREATE TABLE IF NOT EXISTS tbl_Activity
-	(
-		userid       UUID REFERENCES tbl_Users (userid),
-		tranid       BIGSERIAL,
-		created      TIMESTAMP WITHOUT TIME ZONE DEFAULT (NOW() AT TIME ZONE 'UTC'),
-		iscredit     BOOLEAN DEFAULT TRUE,
-		amount       NUMERIC(10, 2) NOT NULL,
-		usercreditid BIGINT REFERENCES tbl_UserCredits (usercreditid),
-		PRIMARY KEY (userid, tranid)
-	)`
-	return tableCreationQuery
+	db.Exec("ALTER SEQUENCE tbl_overdrafts_overdraftid_seq RESTART")
 }
 
 //function to fetch query to insert a single user in table
@@ -0,0 +1,269 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//DefaultBuckets are the histogram buckets used for latencies, in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+//collector is implemented by every metric, it writes its samples in the prometheus text format
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+//Registry holds the metrics exposed by Handler
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+//Default is the registry used by the package level constructors and by Handler
+var Default = &Registry{}
+
+//function to add a collector, registering the same name twice is a programming error
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.collectors {
+		if existing.name() == c.name() {
+			panic(fmt.Sprintf("metrics: %s registered twice", c.name()))
+		}
+	}
+	r.collectors = append(r.collectors, c)
+}
+
+//Write writes all the metrics in the prometheus text exposition format, sorted by name
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+	sort.Slice(collectors, func(i, j int) bool { return collectors[i].name() < collectors[j].name() })
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+//Handler serves the metrics of the Default registry
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Default.Write(w)
+	})
+}
+
+//desc holds the name, help and label names shared by all the metric types
+type desc struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+}
+
+func (d *desc) name() string {
+	return d.metricName
+}
+
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.metricName, d.help, d.metricName, d.kind)
+}
+
+//function to format label pairs, extra is appended as is(used for the histogram "le" label)
+func (d *desc) labelString(values []string, extra string) string {
+	pairs := make([]string, 0, len(values)+1)
+	for i, value := range values {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, d.labels[i], labelEscaper.Replace(value)))
+	}
+	if len(extra) > 0 {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.metricName, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+//escapes label values as defined by the prometheus text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+//CounterVec is a set of counters partitioned by label values
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+	labels map[string][]string
+}
+
+//Counter is a single series of a CounterVec
+type Counter struct {
+	vec *CounterVec
+	key string
+}
+
+//NewCounterVec creates and registers a counter in the Default registry
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name, help, "counter", labels}, values: make(map[string]float64), labels: make(map[string][]string)}
+	Default.register(c)
+	return c
+}
+
+//WithLabelValues returns the counter for the given label values, in the order of the label names
+func (c *CounterVec) WithLabelValues(values ...string) Counter {
+	key := c.key(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.values[key]; !ok {
+		c.values[key] = 0
+		c.labels[key] = append([]string(nil), values...)
+	}
+	return Counter{vec: c, key: key}
+}
+
+func (c Counter) Inc() {
+	c.Add(1)
+}
+
+//Add increases the counter, negative values are ignored as counters only go up
+func (c Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	c.vec.mu.Lock()
+	c.vec.values[c.key] += v
+	c.vec.mu.Unlock()
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.header(w)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, c.labelString(c.labels[key], ""), formatFloat(c.values[key]))
+	}
+}
+
+//HistogramVec is a set of histograms partitioned by label values
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+//Histogram is a single series of a HistogramVec
+type Histogram struct {
+	vec *HistogramVec
+	key string
+}
+
+//NewHistogramVec creates and registers a histogram in the Default registry, buckets must be sorted
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{desc: desc{name, help, "histogram", labels}, buckets: buckets, series: make(map[string]*histogramSeries)}
+	Default.register(h)
+	return h
+}
+
+//WithLabelValues returns the histogram for the given label values, in the order of the label names
+func (h *HistogramVec) WithLabelValues(values ...string) Histogram {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.series[key]; !ok {
+		h.series[key] = &histogramSeries{labels: append([]string(nil), values...), counts: make([]uint64, len(h.buckets))}
+	}
+	return Histogram{vec: h, key: key}
+}
+
+//Observe adds a single observation to the histogram
+func (h Histogram) Observe(v float64) {
+	h.vec.mu.Lock()
+	defer h.vec.mu.Unlock()
+	s := h.vec.series[h.key]
+	for i, bound := range h.vec.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.header(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			le := fmt.Sprintf(`le="%s"`, formatFloat(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labelString(s.labels, le), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labelString(s.labels, `le="+Inf"`), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.labelString(s.labels, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.labelString(s.labels, ""), s.count)
+	}
+}
+
+//valueFunc is a metric without labels whose value is read when the metrics are collected
+type valueFunc struct {
+	desc
+	fn func() float64
+}
+
+//NewGaugeFunc registers a gauge whose value is returned by fn at collection time
+func NewGaugeFunc(name string, help string, fn func() float64) {
+	Default.register(&valueFunc{desc: desc{name, help, "gauge", nil}, fn: fn})
+}
+
+//NewCounterFunc registers a counter whose value is returned by fn at collection time, fn must never decrease
+func NewCounterFunc(name string, help string, fn func() float64) {
+	Default.register(&valueFunc{desc: desc{name, help, "counter", nil}, fn: fn})
+}
+
+func (v *valueFunc) write(w io.Writer) {
+	v.header(w)
+	fmt.Fprintf(w, "%s %s\n", v.metricName, formatFloat(v.fn()))
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+//test case to verify the text exposition format of every metric type
+func TestHandlerOutput(t *testing.T) {
+	requests := NewCounterVec("test_requests_total", "Test requests.", "route", "code")
+	requests.WithLabelValues("/debit", "200").Inc()
+	requests.WithLabelValues("/debit", "200").Add(2)
+	requests.WithLabelValues(`/a"b`, "500").Inc()
+	latency := NewHistogramVec("test_latency_seconds", "Test latency.", []float64{0.1, 1})
+	latency.WithLabelValues().Observe(0.05)
+	latency.WithLabelValues().Observe(0.5)
+	NewGaugeFunc("test_connections", "Test connections.", func() float64 { return 4 })
+
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body := rr.Body.String()
+
+	for _, line := range []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{route="/debit",code="200"} 3`,
+		`test_requests_total{route="/a\"b",code="500"} 1`,
+		"# TYPE test_latency_seconds histogram",
+		`test_latency_seconds_bucket{le="0.1"} 1`,
+		`test_latency_seconds_bucket{le="1"} 2`,
+		`test_latency_seconds_bucket{le="+Inf"} 2`,
+		"test_latency_seconds_sum 0.55",
+		"test_latency_seconds_count 2",
+		"# TYPE test_connections gauge",
+		"test_connections 4",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected line %q. Got\n%s", line, body)
+		}
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Expected prometheus content type. Got %s", ct)
+	}
+}
+
+//test case to verify registering a metric name twice panics instead of exposing duplicates
+func TestDuplicateRegistration(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic on duplicate registration")
+		}
+	}()
+	NewCounterVec("test_duplicate_total", "Test duplicate.")
+	NewCounterVec("test_duplicate_total", "Test duplicate.")
+}
+
+//test case to verify output is sorted by metric name
+func TestRegistryOrder(t *testing.T) {
+	r := &Registry{}
+	r.register(&valueFunc{desc: desc{"b_metric", "B.", "gauge", nil}, fn: func() float64 { return 1 }})
+	r.register(&valueFunc{desc: desc{"a_metric", "A.", "gauge", nil}, fn: func() float64 { return 2 }})
+	var buf bytes.Buffer
+	r.Write(&buf)
+	if strings.Index(buf.String(), "a_metric") > strings.Index(buf.String(), "b_metric") {
+		t.Errorf("Expected metrics sorted by name. Got\n%s", buf.String())
+	}
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/a0rana/UserAccountService/audit"
+	"github.com/a0rana/UserAccountService/database"
+	"github.com/a0rana/UserAccountService/logger"
+	"github.com/a0rana/UserAccountService/models"
+	"github.com/a0rana/UserAccountService/spending"
+)
+
+//error code of requests made for a user that does not exist
+const CodeUserNotFound = "user_not_found"
+
+//whether frozen accounts are kept from receiving credits as well as from spending, set by Init
+var frozenBlocksCredits bool
+
+//errors returned when the status of the account does not allow an operation
+var (
+	errAccountFrozen = errors.New("the account of the given user is frozen, it has to be unfrozen before it can be used again")
+	errAccountClosed = errors.New("the account of the given user is closed")
+	errUserNotFound  = spending.ErrUserNotFound
+)
+
+//request to change the status of an account, the reason is required
+type requestStatus struct {
+	UserId string `json:"userid"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+//statusState is the status of an account recorded in the audit log, TranId is the activity row logging the change
+type statusState struct {
+	Status string `json:"status"`
+	TranId uint64 `json:"tranid,omitempty"`
+}
+
+//response of the account status endpoint
+type responseStatus struct {
+	Success bool   `json:"success"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+	TranId  uint64 `json:"tranid,omitempty"`
+}
+
+//UpdateAccountStatus freezes, unfreezes or closes the account of a user, e.g. to stop spending when fraud is suspected
+func UpdateAccountStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req requestStatus
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err == nil {
+		err = req.validate()
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprint("Unable to process the account status request. ", err.Error()))
+		return
+	}
+
+	tranId, err := SetAccountStatus(r.Context(), req.UserId, req.Status, req.Reason)
+	if err != nil {
+		status, code := errorStatus(err)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(responseStatus{Success: false, Code: code,
+			Message: fmt.Sprint("Unable to change the account status. ", err.Error())})
+		return
+	}
+
+	//the change is part of the history of the user
+	InvalidateCache(r.Context(), req.UserId)
+
+	json.NewEncoder(w).Encode(responseStatus{Success: true, TranId: tranId,
+		Message: fmt.Sprint("Account status has been set to ", req.Status)})
+}
+
+//function to check the fields of an account status request
+func (req requestStatus) validate() error {
+	switch {
+	case len(req.UserId) == 0:
+		return errors.New("userid is required")
+	case req.Status != models.StatusActive && req.Status != models.StatusFrozen && req.Status != models.StatusClosed:
+		return fmt.Errorf("status must be one of %s, %s or %s, got %q", models.StatusActive, models.StatusFrozen, models.StatusClosed, req.Status)
+	case len(req.Reason) == 0:
+		return errors.New("reason is required")
+	}
+	return nil
+}
+
+//SetAccountStatus changes the status of the account of the user, logs the change as an activity row with the event
+//"status:<status>" and records it in the audit log along with reason. a closed account cannot be reopened and setting
+//the current status changes nothing. returns the tranid of the activity row, 0 when nothing changed
+func SetAccountStatus(ctx context.Context, userid string, status string, reason string) (_ uint64, err error) {
+	if err = (requestStatus{UserId: userid, Status: status, Reason: reason}).validate(); err != nil {
+		return 0, err
+	}
+
+	//bound the whole transaction by its own timeout on top of the caller context
+	ctx, cancel := context.WithTimeout(ctx, txTimeout)
+	defer cancel()
+	defer func() { err = timeoutError(ctx, err) }()
+
+	tx, err := getConnection().BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return 0, err
+	}
+
+	//the lock waits for the debits and credits in flight, none starts before the change is committed
+	var previous string
+	err = tx.QueryRowContext(ctx, models.UserStatusLockStatement, userid).Scan(&previous)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, rollback(tx, errUserNotFound)
+	case err != nil:
+		return 0, rollback(tx, err)
+	case previous == status:
+		return 0, rollback(tx, nil)
+	case previous == models.StatusClosed:
+		return 0, rollback(tx, errAccountClosed)
+	}
+
+	if _, err = tx.ExecContext(ctx, models.UserStatusUpdateStatement, status, reason, userid); err != nil {
+		return 0, rollback(tx, err)
+	}
+
+	tranId, err := insertActivity(ctx, tx, models.UserActivity{UserId: userid, Event: "status:" + status})
+	if err != nil {
+		return 0, rollback(tx, err)
+	}
+
+	_, err = audit.Record(ctx, tx, audit.Event{Action: audit.ActionStatusSet, UserId: userid, Entity: audit.EntityUser,
+		Reason: reason, Before: statusState{Status: previous}, After: statusState{Status: status, TranId: tranId}})
+	if err != nil {
+		return 0, rollback(tx, err)
+	}
+
+	if err = database.Commit(ctx, tx); err != nil {
+		return 0, err
+	}
+
+	logger.Info(ctx, "account status changed", "userid", userid, "status", status, "previous", previous, "tranid", tranId)
+
+	return tranId, nil
+}
+
+//function to check within tx that the account of the user can be debited, or credited when credit is set. the user
+//row is share locked so that a status change waits for the transaction. an unknown user is errUserNotFound
+func checkAccountStatus(ctx context.Context, tx *sql.Tx, userid string, credit bool) error {
+	var status string
+	err := tx.QueryRowContext(ctx, models.UserStatusSelectStatement, userid).Scan(&status)
+	switch {
+	case err == sql.ErrNoRows:
+		return errUserNotFound
+	case err != nil:
+		return err
+	case status == models.StatusClosed:
+		return errAccountClosed
+	case status == models.StatusFrozen && (!credit || frozenBlocksCredits):
+		return errAccountFrozen
+	}
+	return nil
+}
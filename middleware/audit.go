@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/a0rana/UserAccountService/audit"
+)
+
+//creditState is the state of a credit recorded in the audit log, TranIds are the activity rows written for it
+type creditState struct {
+	UserCreditId    uint64   `json:"usercreditid,omitempty"`
+	Amount          float64  `json:"amount"`
+	TransactionType string   `json:"transactiontype,omitempty"`
+	Priority        int      `json:"priority,omitempty"`
+	Expiry          string   `json:"expiry,omitempty"`
+	Categories      []string `json:"allowed_categories,omitempty"`
+	Repaid          float64  `json:"repaid,omitempty"`
+	TranIds         []uint64 `json:"tranids,omitempty"`
+}
+
+//debitState is the state of the credits consumed by a debit recorded in the audit log, Amount is the amount
+//debited and is only set after the debit, along with the overdraft drawn for the part beyond the credits
+type debitState struct {
+	Amount    float64         `json:"amount,omitempty"`
+	Merchant  string          `json:"merchant,omitempty"`
+	Category  string          `json:"category,omitempty"`
+	Credits   []creditState   `json:"credits"`
+	Overdraft *overdraftState `json:"overdraft,omitempty"`
+}
+
+//overdraftState is an overdraft drawn by a debit recorded in the audit log, TranId is the activity row written for it
+type overdraftState struct {
+	OverdraftId uint64  `json:"overdraftid"`
+	Amount      float64 `json:"amount"`
+	TranId      uint64  `json:"tranid"`
+}
+
+//reversalState is the state of a reversed debit recorded in the audit log
+type reversalState struct {
+	TranId         uint64  `json:"tranid"`
+	UserCreditId   uint64  `json:"usercreditid"`
+	CreditAmount   float64 `json:"credit_amount"`
+	Reversed       bool    `json:"reversed"`
+	ReversalTranId uint64  `json:"reversal_tranid,omitempty"`
+}
+
+//response of the audit log endpoint
+type responseAudit struct {
+	Success bool          `json:"success"`
+	Code    string        `json:"code,omitempty"`
+	Message string        `json:"message,omitempty"`
+	Entries []audit.Entry `json:"entries,omitempty"`
+}
+
+//GetAuditLog lists the audit log newest first, filtered by the userid, action, actor, requestid, since and until
+//query parameters(since and until in RFC 3339 format). at most limit entries are returned, before is the auditid
+//the listing continues after
+func GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	filter, err := auditFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(responseAudit{Success: false, Code: CodeInvalidRequest,
+			Message: fmt.Sprint("Unable to process the audit log request. ", err.Error())})
+		return
+	}
+
+	//bound the query by its own timeout on top of the request context
+	ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+	defer cancel()
+	entries, err := audit.List(ctx, getConnection(), filter)
+	if err = timeoutError(ctx, err); err != nil {
+		status, code := errorStatus(err)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(responseAudit{Success: false, Code: code,
+			Message: fmt.Sprint("Unable to get the audit log. ", err.Error())})
+		return
+	}
+	json.NewEncoder(w).Encode(responseAudit{Success: true, Entries: entries})
+}
+
+//function to read the filter of the audit log from the query parameters
+func auditFilter(r *http.Request) (audit.Filter, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		UserId:    query.Get("userid"),
+		Action:    query.Get("action"),
+		Actor:     query.Get("actor"),
+		RequestId: query.Get("requestid"),
+		Limit:     50,
+	}
+	var err error
+	if value := query.Get("limit"); len(value) > 0 {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 || filter.Limit > 500 {
+			return filter, fmt.Errorf("limit must be a number between 1 and 500, got %q", value)
+		}
+	}
+	if value := query.Get("before"); len(value) > 0 {
+		if filter.BeforeId, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return filter, fmt.Errorf("before must be an auditid, got %q", value)
+		}
+	}
+	for _, t := range []struct {
+		name  string
+		value *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if value := query.Get(t.name); len(value) > 0 {
+			if *t.value, err = time.Parse(time.RFC3339, value); err != nil {
+				return filter, fmt.Errorf("%s must be in RFC 3339 format, got %q", t.name, value)
+			}
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/a0rana/UserAccountService/audit"
+	"github.com/a0rana/UserAccountService/auth"
+	"github.com/a0rana/UserAccountService/config"
+	"github.com/a0rana/UserAccountService/logger"
+)
+
+//error codes of requests rejected for missing or invalid credentials and for credentials not allowing the operation
+const (
+	CodeUnauthorized = "unauthorized"
+	CodeForbidden    = "forbidden"
+)
+
+//returned when user-scoped credentials are used on another user
+var errForbidden = errors.New("the credentials of the request are not allowed to access the given user")
+
+//authenticator of the api requests and whether credentials are required, set by Init. requests are rejected
+//while no authenticator is set so that a missing Init never leaves the api open
+var authenticator auth.Authenticator
+var authRequired = true
+
+//response format of requests rejected before reaching a handler
+type responseError struct {
+	Success bool   `json:"success"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+//function to build the authenticator accepting the credentials enabled in the auth config
+func newAuthenticator(cfg config.AuthConfig, database *sql.DB) (auth.Authenticator, error) {
+	var chain auth.Chain
+	if cfg.APIKeys {
+		chain = append(chain, auth.APIKeys{DB: database})
+	}
+	jwt := auth.JWT{HMACSecret: []byte(cfg.JWTHMACSecret), Issuer: cfg.JWTIssuer, Audience: cfg.JWTAudience, Leeway: cfg.JWTLeeway}
+	if len(cfg.JWTRSAPublicKeyFile) > 0 {
+		data, err := ioutil.ReadFile(cfg.JWTRSAPublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if jwt.RSAPublicKey, err = auth.ParseRSAPublicKey(data); err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", cfg.JWTRSAPublicKeyFile, err)
+		}
+	}
+	if len(jwt.HMACSecret) > 0 || jwt.RSAPublicKey != nil {
+		chain = append(chain, jwt)
+	}
+	return chain, nil
+}
+
+//Authenticate rejects requests without valid credentials and puts the authenticated principal in the request
+//context for the handlers, it does nothing when authentication is disabled
+func Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authRequired {
+			next.ServeHTTP(w, r)
+			return
+		}
+		err := auth.ErrNoCredentials
+		var principal *auth.Principal
+		if authenticator != nil {
+			principal, err = authenticator.Authenticate(r)
+		}
+		if err != nil {
+			status, code := http.StatusUnauthorized, CodeUnauthorized
+			if !errors.Is(err, auth.ErrNoCredentials) && !errors.Is(err, auth.ErrInvalidCredentials) {
+				status, code = errorStatus(err)
+			}
+			logger.Warn(r.Context(), "request not authenticated", "path", r.URL.Path, "error", err)
+			writeError(w, status, code, fmt.Sprint("Unable to authenticate the request. ", err.Error()))
+			return
+		}
+		logger.Debug(r.Context(), "request authenticated", "subject", principal.Subject, "method", principal.Method)
+		//changes made by the request are audited as made by the principal
+		ctx := audit.WithActor(auth.WithPrincipal(r.Context(), principal), principal.Method+":"+principal.Subject)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+//function to send an error response from a middleware
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer, ApiKey`)
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(responseError{Success: false, Code: code, Message: message})
+}
+
+//RequireScope rejects requests whose principal was not granted scope before they reach next, routes are declared
+//with the scope they need in router.Router()
+func RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authRequired {
+			if principal, ok := auth.FromContext(r.Context()); !ok || !principal.HasScope(scope) {
+				logger.Warn(r.Context(), "request not authorized", "path", r.URL.Path, "scope", scope)
+				writeError(w, http.StatusForbidden, CodeForbidden, fmt.Sprint("The credentials of the request do not grant the ", scope, " scope."))
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+//function to check that the principal of the request may act on userid, requests without a principal were let
+//through with authentication disabled
+func authorizeUser(ctx context.Context, userid string) error {
+	if principal, ok := auth.FromContext(ctx); ok && !principal.CanAccessUser(userid) {
+		logger.Warn(ctx, "user-scoped credentials used on another user", "subject", principal.Subject, "userid", userid)
+		return errForbidden
+	}
+	return nil
+}
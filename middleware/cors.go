@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/a0rana/UserAccountService/config"
+)
+
+//cross-origin policy set by Init, the zero value allows no origin
+var cors config.CORSConfig
+
+//CORS applies the cross-origin policy to every request. responses to allowed origins carry the
+//Access-Control-Allow-* headers and OPTIONS requests are answered here without reaching authentication or the
+//handlers, a preflight that is not allowed gets no Access-Control-Allow-* headers so the browser blocks the request
+func CORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		allowed := len(origin) > 0 && originAllowed(origin)
+		if allowed {
+			if containsFold(cors.AllowedOrigins, "*") && !cors.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if cors.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		if !preflight {
+			if allowed && len(cors.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(cors.ExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if allowed && methodAllowed(r.Header.Get("Access-Control-Request-Method")) &&
+			headersAllowed(r.Header.Get("Access-Control-Request-Headers")) {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(cors.AllowedMethods, ", "))
+			if len(cors.AllowedHeaders) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(cors.AllowedHeaders, ", "))
+			}
+			if cors.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cors.MaxAge.Seconds())))
+			}
+		} else {
+			w.Header().Del("Access-Control-Allow-Origin")
+			w.Header().Del("Access-Control-Allow-Credentials")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+//function to check the origin against the allowed ones, origins are compared case insensitively
+func originAllowed(origin string) bool {
+	for _, allowed := range cors.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+//function to check the method of a preflight request, simple methods are always allowed by browsers
+func methodAllowed(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost:
+		return true
+	}
+	for _, allowed := range cors.AllowedMethods {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+//function to check that every header named by a preflight request is allowed
+func headersAllowed(requested string) bool {
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if len(header) > 0 && !containsFold(cors.AllowedHeaders, header) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/a0rana/UserAccountService/spending"
+	"github.com/lib/pq"
+)
+
+//error codes sent in the "code" field of error responses
+const (
+	CodeInvalidRequest = "invalid_request"
+	CodeDBTimeout      = "db_timeout"
+	CodeDBUnavailable  = "db_unavailable"
+	CodeInternal       = "internal_error"
+)
+
+//returned by data access functions whose query or transaction ran past its timeout
+var errQueryTimeout = errors.New("database operation timed out")
+
+//function to report the error of an operation whose context deadline expired as errQueryTimeout,
+//it must run before the operation context is cancelled
+func timeoutError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && !errors.Is(err, errQueryTimeout) {
+		return fmt.Errorf("%w: %v", errQueryTimeout, err)
+	}
+	return err
+}
+
+//function to map an error to the http status and error code of the response. credentials not allowed on the user
+//are a 403, rate limited requests a 429, timeouts a 504, an unreachable database a 503 and everything else is an
+//internal error. debits refused by the credits or a spending limit of the user are a 422 carrying their own code, the
+//request was understood but is not allowed, and a debit amount that is not positive a 400. operations the status of
+//the account does not allow are a 409, unknown users a 404 and categories that are too long an invalid request
+func errorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, errForbidden):
+		return http.StatusForbidden, CodeForbidden
+	case errors.Is(err, spending.ErrLimitExceeded), errors.Is(err, errNoCredits), errors.Is(err, errCreditsExpired),
+		errors.Is(err, errInsufficientCredit), errors.Is(err, errIneligibleCredit):
+		return http.StatusUnprocessableEntity, debitOutcome(err)
+	case errors.Is(err, errInvalidDebitAmount):
+		return http.StatusBadRequest, debitOutcome(err)
+	case errors.Is(err, errAccountFrozen), errors.Is(err, errAccountClosed):
+		return http.StatusConflict, debitOutcome(err)
+	case errors.Is(err, errUserNotFound):
+		return http.StatusNotFound, CodeUserNotFound
+	case errors.Is(err, errInvalidCategory):
+		return http.StatusBadRequest, CodeInvalidRequest
+	case errors.Is(err, errRateLimited):
+		return http.StatusTooManyRequests, CodeRateLimited
+	case errors.Is(err, errQueryTimeout):
+		return http.StatusGatewayTimeout, CodeDBTimeout
+	case isUnavailable(err):
+		return http.StatusServiceUnavailable, CodeDBUnavailable
+	}
+	return http.StatusInternalServerError, CodeInternal
+}
+
+//function to detect errors caused by the database being unreachable rather than by the request
+func isUnavailable(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		//connection exceptions, too many connections, server shutting down or starting up
+		return pqErr.Code.Class() == "08" || pqErr.Code == "53300" || pqErr.Code == "57P01" || pqErr.Code == "57P03"
+	}
+	return false
+}
@@ -8,91 +8,162 @@ import (
 	"encoding/json" // package to encode and decode the json into struct and vice versa
 	"errors"
 	"fmt"
+	"github.com/a0rana/UserAccountService/audit"
+	"github.com/a0rana/UserAccountService/cache"
+	"github.com/a0rana/UserAccountService/config"
+	"github.com/a0rana/UserAccountService/database"
+	"github.com/a0rana/UserAccountService/hashchain"
+	"github.com/a0rana/UserAccountService/journal"
+	"github.com/a0rana/UserAccountService/logger"
 	"github.com/a0rana/UserAccountService/models" // models package where User schema is defined
-	"github.com/allegro/bigcache"
-	_ "github.com/allegro/bigcache"
-	"github.com/joho/godotenv" // package used to read the .env file
-	_ "github.com/lib/pq"      // postgres golang driver
+	"github.com/a0rana/UserAccountService/ratelimit"
+	"github.com/a0rana/UserAccountService/spending"
+	"github.com/lib/pq" // postgres golang driver
 	"log"
 	"math"
 	"net/http" // used to access the request and response object of the api
-	"os"       // used to read the environment variable
 	"strings"
+	"sync"
 	"time"
 )
 
-//cache variable
-var cache *bigcache.BigCache
+//postgres connection pool shared by all the requests, set by Init
+var db *sql.DB
+
+//cache variable, backend is selected by the cache config given to Init
+var responseCache cache.Cache
+var cacheOnce sync.Once
+
+//lifetime of cached history and of cached empty history(negative caching), set by Init
+var cacheTTL = 10 * time.Minute
+var cacheNegativeTTL = 30 * time.Second
+
+//timeouts of a single read query and of a credit/debit transaction, set by Init
+var queryTimeout = 5 * time.Second
+var txTimeout = 10 * time.Second
+
+//counts hits, misses and corrupt entries of the transaction history cache
+var responseCacheStats cache.Stats
+
+//version written in front of every cached activity slice, bump it whenever models.UserActivity changes
+//so that entries encoded by an older release are treated as a miss instead of being decoded wrongly
+const activityCacheVersion byte = 6
+
+//returned when a cached entry was written with another activityCacheVersion
+var errCacheVersion = errors.New("cached entry has an unsupported version")
+
+//errors returned when a debit cannot be processed, also used to classify the debit outcome
+var (
+	errInvalidDebitAmount = errors.New("please provide debit amount greater than zero")
+	errCreditsExpired     = errors.New("some or all the credits have expired for the given user, cannot process further debits. please allocate new credit(s) for the user to resolve this issue")
+	errNoCredits          = errors.New("trying to make a debit call before any credits are transacted for the given user. please allocate new credit(s) for the user to resolve this issue")
+	errInsufficientCredit = errors.New("cannot debit more amount than currently present as credit for the given user. please either create more credits or reduce the debit amount to resolve this issue")
+	errIneligibleCredit   = errors.New("the credits of the given user that can be spent in the category of the debit are not enough, the others are restricted to other categories")
+	errInvalidCategory    = errors.New("categories must be at most 50 characters long and the merchant at most 100")
+)
 
 //response format for Credit
 type responseCredit struct {
 	ID      uint64 `json:"id,omitempty"`
 	Success bool   `json:"success"`
+	Code    string `json:"code,omitempty"`
 	Message string `json:"message,omitempty"`
 }
 
 //response format for Debit
 type responseDebit struct {
 	Success bool   `json:"success"`
+	Code    string `json:"code,omitempty"`
 	Message string `json:"message,omitempty"`
 }
 
-//response format for Activity
-type responseActivity struct {
-	Success bool   `json:"success"`
-	Message string `json:"message,omitempty"`
+//response format for Balance
+type responseBalance struct {
+	Success bool                `json:"success"`
+	Code    string              `json:"code,omitempty"`
+	Message string              `json:"message,omitempty"`
+	Balance *models.UserBalance `json:"balance,omitempty"`
 }
 
-// create connection with postgres db
-func createConnection() *sql.DB {
-	// load .env file
-	err := godotenv.Load(".env")
-
-	if err != nil {
-		log.Fatalf("Error loading .env file")
-	}
-
-	connectionString := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		os.Getenv("POSTGRES_HOST"), os.Getenv("POSTGRES_PORT"), os.Getenv("POSTGRES_USER"),
-		os.Getenv("POSTGRES_PASSWORD"), os.Getenv("POSTGRES_DBNAME"), os.Getenv("POSTGRES_SSLMODE"))
+//response format for ChainHead
+type responseChainHead struct {
+	Success bool                 `json:"success"`
+	Code    string               `json:"code,omitempty"`
+	Message string               `json:"message,omitempty"`
+	Head    *hashchain.ChainHead `json:"head,omitempty"`
+}
 
-	// Open the connection
-	db, err := sql.Open("postgres", connectionString)
+//response format for Activity, activities is always a list(empty when the user has no history yet)
+//except for errors where it is null
+type responseActivity struct {
+	Success    bool                  `json:"success"`
+	Code       string                `json:"code,omitempty"`
+	Message    string                `json:"message,omitempty"`
+	Activities []models.UserActivity `json:"activities"`
+}
 
+//Init sets up the database pool and the cache used by the handlers, it must be called before serving requests
+func Init(cfg *config.Config, database *sql.DB) error {
+	c, err := cache.New(cache.Options{
+		Backend:       cfg.Cache.Backend,
+		TTL:           cfg.Cache.TTL,
+		RedisAddr:     cfg.Cache.RedisAddr,
+		RedisPassword: cfg.Cache.RedisPassword,
+		RedisDB:       cfg.Cache.RedisDB,
+	})
 	if err != nil {
-		panic(err)
-	}
-
-	// check the connection
-	err = db.Ping()
+		return err
+	}
+	db = database
+	responseCache = c
+	cacheTTL = cfg.Cache.TTL
+	cacheNegativeTTL = cfg.Cache.NegativeTTL
+	queryTimeout = cfg.Database.QueryTimeout
+	txTimeout = cfg.Database.TxTimeout
+	authRequired = cfg.Auth.Enabled
+	cors = cfg.CORS
+	addressLimit = cfg.RateLimit.Address
+	clientLimits = cfg.RateLimit.Client
+	userLimits = cfg.RateLimit.User
+	spendingLimits = cfg.Spending
+	frozenBlocksCredits = cfg.Account.FrozenBlocksCredits
+	if limiter, err = ratelimit.New(cfg.RateLimit.Backend); err != nil {
+		return err
+	}
+	if authenticator, err = newAuthenticator(cfg.Auth, database); err != nil {
+		return err
+	}
+	return nil
+}
 
-	if err != nil {
-		panic(err)
+//get the shared postgres connection pool
+func getConnection() *sql.DB {
+	if db == nil {
+		panic("middleware: Init must be called before accessing the database")
 	}
-
-	fmt.Println("Successfully connected to the database!")
-	// return the connection
 	return db
 }
 
-//create cache instance and return BigCache type
-func createCache() *bigcache.BigCache {
-	if cache == nil {
+//get the cache instance, a default process local cache is created when Init has not set one
+func createCache() cache.Cache {
+	cacheOnce.Do(func() {
+		if responseCache != nil {
+			return
+		}
 		var initErr error
-		cache, initErr = bigcache.NewBigCache(bigcache.DefaultConfig(10 * time.Minute))
+		responseCache, initErr = cache.New(cache.Options{TTL: cacheTTL})
 		if initErr != nil {
 			log.Fatalf("Error creating cache %v", initErr)
 		}
-	}
-	return cache
+	})
+	return responseCache
 }
 
 // Fetches activity of the user's debits and credits
 func GetAllTransactions(w http.ResponseWriter, r *http.Request) {
-	cache = createCache()
+	responseCache := createCache()
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	var user models.User
 	var res responseActivity
@@ -103,63 +174,76 @@ func GetAllTransactions(w http.ResponseWriter, r *http.Request) {
 	//used for creating the key in the cache
 	url := r.URL.String()
 
-	fmt.Println(fmt.Sprint("URL: ", url, ", param limit: ", limit, ", param offset: ", offset))
+	logger.Debug(r.Context(), "fetching transaction history", "url", url, "limit", limit, "offset", offset)
 
 	// decode the json request to user
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprint("Unable to process the user's transaction history request. ", err.Error()))
+		return
+	}
+
+	//user-scoped credentials may only read their own history, within the rate limit of the user
+	if err = admitUser(w, r, user.UserId); err != nil {
+		status, code := errorStatus(err)
 		res = responseActivity{
 			Success: false,
+			Code:    code,
 			Message: fmt.Sprint("Unable to process the user's transaction history request. ", err.Error()),
 		}
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(status)
 		json.NewEncoder(w).Encode(res)
 		return
 	}
 
-	//check for an entry in cache first to reduce database load, format of the key is "userid_relative api url"
-	entry, cacheErr := cache.Get(fmt.Sprint(user.UserId, "_", url))
-	if cacheErr == nil {
-		fmt.Println("Found key in cache: ", fmt.Sprint(user.UserId, "_", url))
-		json.NewEncoder(w).Encode(decodeToUserActivity(entry))
+	//key is built once, before the database read, so an invalidation racing with this request can never be overwritten by stale data
+	key, cacheErr := cacheKey(user.UserId, url)
+	if cacheErr != nil {
+		logger.Warn(r.Context(), "unable to read cache generation, bypassing cache", "error", cacheErr)
+	}
+
+	//check for an entry in cache first to reduce database load
+	if cached, found := readCachedActivities(r.Context(), responseCache, key); found {
+		logger.Debug(r.Context(), "found key in cache", "key", key)
+		json.NewEncoder(w).Encode(activityResponse(cached))
 	} else {
 		// get all the activities from the db
-		activities, err := getAllActivities(user, limit, offset)
+		activities, err := GetAllActivities(r.Context(), user, limit, offset)
 
 		if err != nil {
+			status, code := errorStatus(err)
 			res = responseActivity{
 				Success: false,
+				Code:    code,
 				Message: fmt.Sprint("Unable to process the user's transaction history request. ", err.Error()),
 			}
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(status)
 			json.NewEncoder(w).Encode(res)
 			return
 		}
-		//set cache back using the key, for improving latency on subsequent calls
-		cache.Set(fmt.Sprint(user.UserId, "_", url), encodeToBytes(activities))
-		fmt.Println("Setting cache with key: ", fmt.Sprint(user.UserId, "_", url))
-
-		if len(activities) == 0 {
-			res = responseActivity{
-				Success: true,
-				Message: fmt.Sprint("Cannot find any transaction history for given user. "),
+		//set cache back using the key, for improving latency on subsequent calls.
+		//empty history is cached for a shorter time as the first credit is usually not far away
+		if cacheErr == nil {
+			ttl := cacheTTL
+			if len(activities) == 0 {
+				ttl = cacheNegativeTTL
+			}
+			if entry, err := encodeToBytes(activities); err != nil {
+				logger.Error(r.Context(), "unable to encode activities for the cache", "error", err)
+			} else {
+				responseCache.Set(key, entry, ttl)
+				logger.Debug(r.Context(), "setting cache", "key", key, "ttl", ttl)
 			}
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(res)
-			return
 		}
-		// send all the users as response
-		json.NewEncoder(w).Encode(activities)
+
+		// send all the activities as response
+		json.NewEncoder(w).Encode(activityResponse(activities))
 	}
 }
 
 // CreateUserCredit create a user-credit in the postgres db
 func CreateUserCredit(w http.ResponseWriter, r *http.Request) {
-	// Allow all origin to handle cors issue
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
 	// create an empty user of type models.User
 	var userCredit models.UserCredit
@@ -169,33 +253,38 @@ func CreateUserCredit(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&userCredit)
 
 	if err != nil {
-		res = responseCredit{
-			ID:      0,
-			Success: false,
-			Message: fmt.Sprint("Unable to process the user's credit. ", err.Error()),
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(res)
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprint("Unable to process the user's credit. ", err.Error()))
+		return
+	}
+
+	//categories that are too long are rejected before a transaction is opened
+	if _, err = normalizeCategories(userCredit.AllowedCategories); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprint("Unable to process the user's credit. ", err.Error()))
 		return
 	}
 
-	// call insert user function and pass the user
-	insertID, err := insertUserCredit(userCredit)
+	// call insert user function and pass the user, user-scoped credentials may only credit their own user
+	//and a user can only be credited so often
+	var insertID uint64
+	if err = admitUser(w, r, userCredit.UserId); err == nil {
+		insertID, err = InsertUserCredit(r.Context(), userCredit)
+	}
 
 	if err != nil {
+		status, code := errorStatus(err)
 		res = responseCredit{
 			ID:      insertID,
 			Success: false,
+			Code:    code,
 			Message: fmt.Sprint("Unable to process the user's credit. ", err.Error()),
 		}
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(status)
 		json.NewEncoder(w).Encode(res)
 		return
 	}
 
-	fmt.Println("\nCalling invalidate cache from CreateUserCredit")
 	//invalidate the cache as new credit has been processed
-	invalidateCache(userCredit.UserId)
+	InvalidateCache(r.Context(), userCredit.UserId)
 
 	// format a response object
 	res = responseCredit{
@@ -210,11 +299,7 @@ func CreateUserCredit(w http.ResponseWriter, r *http.Request) {
 
 //Process debit transaction for a user and log same in the activity table for future reporting
 func CreateUserDebit(w http.ResponseWriter, r *http.Request) {
-	// Allow all origin to handle cors issue
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
 	// create an empty user of type models.User
 	var userDebit models.UserDebit
@@ -225,31 +310,39 @@ func CreateUserDebit(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&userDebit)
 
 	if err != nil {
-		res = responseDebit{
-			Success: false,
-			Message: fmt.Sprint("Unable to process the user's debit. ", err.Error()),
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(res)
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprint("Unable to process the user's debit. ", err.Error()))
 		return
 	}
 
-	// call insert debit function and pass the user
-	err = insertUserDebit(userDebit)
+	//a category or merchant that is too long is rejected before a transaction is opened
+	if err = validateDebitCategory(&userDebit); err != nil {
+		observeDebit(err, 0)
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprint("Unable to process the user's debit. ", err.Error()))
+		return
+	}
+
+	// call insert debit function and pass the user, user-scoped credentials may only debit their own user
+	//and a user can only be debited so often
+	if err = admitUser(w, r, userDebit.UserId); err == nil {
+		start := time.Now()
+		err = InsertUserDebit(r.Context(), userDebit)
+		observeDebit(err, time.Since(start))
+	}
 
 	if err != nil {
+		status, code := errorStatus(err)
 		res = responseDebit{
 			Success: false,
+			Code:    code,
 			Message: fmt.Sprint("Unable to process user's debit request. ", err.Error()),
 		}
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(status)
 		json.NewEncoder(w).Encode(res)
 		return
 	}
 
-	fmt.Println("\nCalling invalidate cache from CreateUserDebit")
 	//invalidate the cache as new debit has been processed
-	invalidateCache(userDebit.UserId)
+	InvalidateCache(r.Context(), userDebit.UserId)
 
 	// format a response object
 	res = responseDebit{
@@ -260,17 +353,94 @@ func CreateUserDebit(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
+// GetBalance fetches the balance breakdown of the user with every credit, its original and consumed amounts
+func GetBalance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var user models.User
+	var res responseBalance
+
+	// decode the json request to user
+	err := json.NewDecoder(r.Body).Decode(&user)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprint("Unable to process the user's balance request. ", err.Error()))
+		return
+	}
+
+	//user-scoped credentials may only read their own balance, within the rate limit of the user
+	var balance models.UserBalance
+	if err = admitUser(w, r, user.UserId); err == nil {
+		balance, err = GetUserBalance(r.Context(), user.UserId)
+	}
+	if err != nil {
+		status, code := errorStatus(err)
+		res = responseBalance{
+			Success: false,
+			Code:    code,
+			Message: fmt.Sprint("Unable to get the user's balance. ", err.Error()),
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	res = responseBalance{
+		Success: true,
+		Balance: &balance,
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// GetActivityChainHead fetches the head of the hash chain of the user's activity, meant to be published so that the
+// history up to it can be proven unchanged later
+func GetActivityChainHead(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var user models.User
+	var res responseChainHead
+
+	// decode the json request to user
+	err := json.NewDecoder(r.Body).Decode(&user)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprint("Unable to process the user's chain head request. ", err.Error()))
+		return
+	}
+
+	//user-scoped credentials may only read their own chain, within the rate limit of the user
+	var head hashchain.ChainHead
+	if err = admitUser(w, r, user.UserId); err == nil {
+		head, err = GetChainHead(r.Context(), user.UserId)
+	}
+	if err != nil {
+		status, code := errorStatus(err)
+		res = responseChainHead{
+			Success: false,
+			Code:    code,
+			Message: fmt.Sprint("Unable to get the user's chain head. ", err.Error()),
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	res = responseChainHead{
+		Success: true,
+		Head:    &head,
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
 //------------------------- handler functions ---------------------
 
 //get all activities for the user
-func getAllActivities(user models.User, limit string, offset string) ([]models.UserActivity, error) {
-	// create the postgres db connection
-	db := createConnection()
-
-	// close the db connection
-	defer db.Close()
+func GetAllActivities(ctx context.Context, user models.User, limit string, offset string) (activities []models.UserActivity, err error) {
+	//bound the query by its own timeout on top of the request context
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	defer func() { err = timeoutError(ctx, err) }()
 
-	var activities []models.UserActivity
+	// get the postgres db connection pool
+	db := getConnection()
 
 	if len(offset) == 0 {
 		offset = "0"
@@ -285,10 +455,10 @@ func getAllActivities(user models.User, limit string, offset string) ([]models.U
 	}
 
 	// execute the sql statement
-	rows, err := db.Query(models.UserActivitySelectStatement, user.UserId, offset, limit)
+	rows, err := db.QueryContext(ctx, models.UserActivitySelectStatement, user.UserId, offset, limit)
 
 	if err != nil {
-		return activities, errors.New(fmt.Sprint("Unable to execute the query. ", err.Error()))
+		return activities, fmt.Errorf("Unable to execute the query. %w", err)
 	}
 
 	// close the statement
@@ -299,10 +469,12 @@ func getAllActivities(user models.User, limit string, offset string) ([]models.U
 		var userActivity models.UserActivity
 
 		// unmarshal the row object to user
-		err = rows.Scan(&userActivity.UserId, &userActivity.Created, &userActivity.IsCredit, &userActivity.Amount)
+		err = rows.Scan(&userActivity.UserId, &userActivity.TranId, &userActivity.Created, &userActivity.IsCredit, &userActivity.Amount,
+			&userActivity.UserCreditId, &userActivity.ReversalOfTranId, &userActivity.Event, &userActivity.Merchant, &userActivity.Category, &userActivity.OverdraftId,
+			&userActivity.PrevHash, &userActivity.Hash)
 
 		if err != nil {
-			return activities, errors.New(fmt.Sprint("Unable to scan the row. ", err.Error()))
+			return activities, fmt.Errorf("Unable to scan the row. %w", err)
 		}
 
 		// append the user in the users slice
@@ -311,78 +483,134 @@ func getAllActivities(user models.User, limit string, offset string) ([]models.U
 	}
 
 	// return empty user on error
-	return activities, err
+	return activities, rows.Err()
 }
 
 // inserts credit in the DB
-func insertUserCredit(userCredit models.UserCredit) (uint64, error) {
-	// create the postgres db connection
-	db := createConnection()
+func InsertUserCredit(ctx context.Context, userCredit models.UserCredit) (_ uint64, err error) {
+	//bound the whole transaction by its own timeout on top of the request context
+	ctx, cancel := context.WithTimeout(ctx, txTimeout)
+	defer cancel()
+	defer func() { err = timeoutError(ctx, err) }()
 
-	// close the db connection
-	defer db.Close()
+	// get the postgres db connection pool
+	db := getConnection()
 
 	// the inserted id will store in this id
 	var userCreditId uint64
 
-	// Create a new context, and begin a transaction
-	ctx := context.Background()
+	// begin a transaction bound to the request context
+	//categories are stored in the form they are compared in
+	categories, err := normalizeCategories(userCredit.AllowedCategories)
+	if err != nil {
+		return 0, err
+	}
+
 	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
 	if err != nil {
-		return 0, errors.New(err.Error())
+		return 0, err
 	}
-	err = tx.QueryRow(models.UserCreditInsertStatement, userCredit.UserId, userCredit.Amount, userCredit.TransactionType,
-		userCredit.Priority, userCredit.Expiry).Scan(&userCreditId)
+
+	//closed accounts, and frozen ones when configured, cannot receive credits
+	if err = checkAccountStatus(ctx, tx, userCredit.UserId, true); err != nil {
+		return 0, rollback(tx, err)
+	}
+
+	//a credit granted already past its expiry cannot be spent, nor repay overdrafts
+	var spendable bool
+	err = tx.QueryRowContext(ctx, models.UserCreditInsertStatement, userCredit.UserId, userCredit.Amount, userCredit.TransactionType,
+		userCredit.Priority, userCredit.Expiry, pq.Array(categories)).Scan(&userCreditId, &spendable)
 
 	if err != nil {
-		tx.Rollback()
-		return 0, errors.New(err.Error())
+		return 0, rollback(tx, err)
 	}
 
 	// The next query is handled similarly
-	_, err = tx.ExecContext(ctx, models.UserActivityInsertStatement, userCredit.UserId, true, userCredit.Amount, userCreditId)
+	tranId, err := insertActivity(ctx, tx, models.UserActivity{UserId: userCredit.UserId, IsCredit: true, Amount: userCredit.Amount,
+		UserCreditId: userCreditId})
 	if err != nil {
-		tx.Rollback()
-		return 0, errors.New(err.Error())
+		return 0, rollback(tx, err)
+	}
+
+	//post the credit to the journal
+	if _, err = journal.Post(ctx, tx, journal.Credit(userCredit.UserId, tranId, userCreditId, userCredit.Amount)); err != nil {
+		return 0, rollback(tx, err)
 	}
 
-	err = tx.Commit()
+	//the outstanding overdrafts of the user are repaid first, only from credits that can be spent in any category
+	var repaid float64
+	tranIds := []uint64{tranId}
+	if len(categories) == 0 && spendable {
+		var repayments []uint64
+		if repaid, repayments, err = repayOverdrafts(ctx, tx, userCredit.UserId, userCreditId, userCredit.Amount); err != nil {
+			return 0, rollback(tx, err)
+		}
+		tranIds = append(tranIds, repayments...)
+	}
+
+	//record who granted the credit and why
+	_, err = audit.Record(ctx, tx, audit.Event{
+		Action:   audit.ActionCredit,
+		UserId:   userCredit.UserId,
+		Entity:   audit.EntityUserCredit,
+		EntityId: userCreditId,
+		Reason:   userCredit.Reason,
+		After: creditState{Amount: userCredit.Amount, TransactionType: userCredit.TransactionType, Priority: userCredit.Priority,
+			Expiry: userCredit.Expiry, Categories: categories, Repaid: repaid, TranIds: tranIds},
+	})
 	if err != nil {
-		return 0, errors.New(err.Error())
+		return 0, rollback(tx, err)
 	}
 
-	fmt.Printf("Inserted a single record with id: %v and logged activity", userCreditId)
+	err = database.Commit(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	logger.Info(ctx, "user credit created and activity logged", "userid", userCredit.UserId, "usercreditid", userCreditId, "amount", userCredit.Amount,
+		"repaid", repaid)
 
 	// return the inserted id
 	return userCreditId, err
 }
 
 //process debit and insert transaction in the activity table
-func insertUserDebit(userDebit models.UserDebit) error {
+func InsertUserDebit(ctx context.Context, userDebit models.UserDebit) (err error) {
 	if userDebit.Amount <= 0.0 {
-		return errors.New("please provide debit amount greater than zero")
+		return errInvalidDebitAmount
+	}
+	if err = validateDebitCategory(&userDebit); err != nil {
+		return err
 	}
-	// create the postgres db connection
-	db := createConnection()
+	//bound the whole transaction by its own timeout on top of the request context
+	ctx, cancel := context.WithTimeout(ctx, txTimeout)
+	defer cancel()
+	defer func() { err = timeoutError(ctx, err) }()
 
-	// close the db connection
-	defer db.Close()
+	// get the postgres db connection pool
+	db := getConnection()
 
-	var rollbackError error
-	// Create a new context, and begin a transaction
-	ctx := context.Background()
+	// begin a transaction bound to the request context
 	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
 	if err != nil {
-		return errors.New(err.Error())
+		return err
+	}
+
+	//frozen and closed accounts cannot spend
+	if err = checkAccountStatus(ctx, tx, userDebit.UserId, false); err != nil {
+		return rollback(tx, err)
+	}
+
+	//check the spending limits of the user within the transaction, so that concurrent debits cannot go beyond them
+	if err = spending.Evaluate(ctx, tx, userDebit.UserId, userDebit.Amount, spendingLimits); err != nil {
+		return rollback(tx, err)
 	}
+
 	var rows *sql.Rows
-	rows, err = tx.Query(models.UserCreditSelectStatement, userDebit.UserId)
+	rows, err = tx.QueryContext(ctx, models.UserCreditSelectStatement, userDebit.UserId)
 
 	if err != nil {
-		if rollbackError = tx.Rollback(); rollbackError != nil {
-			return errors.New(fmt.Sprint("unable to rollback. ", rollbackError.Error()))
-		}
-		return errors.New(err.Error())
+		return rollback(tx, err)
 	}
 	defer rows.Close()
 
@@ -392,20 +620,15 @@ func insertUserDebit(userDebit models.UserDebit) error {
 
 	for rows.Next() {
 		var credit models.UserCredit
-		if err := rows.Scan(&credit.UserId, &credit.UserCreditId, &credit.Amount, &credit.TransactionType, &credit.Priority, &credit.Expiry); err != nil {
-			if rollbackError = tx.Rollback(); rollbackError != nil {
-				return errors.New(fmt.Sprint("unable to rollback. ", rollbackError.Error()))
-			}
-			return errors.New(err.Error())
+		if err := rows.Scan(&credit.UserId, &credit.UserCreditId, &credit.Amount, &credit.TransactionType, &credit.Priority, &credit.Expiry,
+			pq.Array(&credit.AllowedCategories)); err != nil {
+			return rollback(tx, err)
 		}
 		//check for expiry of the credit
 		var t time.Time
 		t, err = time.Parse(time.RFC3339, credit.Expiry)
 		if err != nil {
-			if rollbackError = tx.Rollback(); rollbackError != nil {
-				return errors.New(fmt.Sprint("unable to rollback. ", rollbackError.Error()))
-			}
-			return errors.New(err.Error())
+			return rollback(tx, err)
 		}
 		//if the expiry on credit is before or equal to current datetime, then ignore it
 		if t.Before(time.Now()) || t.Equal(time.Now()) {
@@ -415,88 +638,151 @@ func insertUserDebit(userDebit models.UserDebit) error {
 		m = append(m, credit)
 	}
 
-	if len(m) == 0 && hasExpiredCredits {
-		if rollbackError = tx.Rollback(); rollbackError != nil {
-			return errors.New(fmt.Sprint("unable to rollback. ", rollbackError.Error()))
-		}
-		return errors.New("some or all the credits have expired for the given user, cannot process further debits. please allocate new credit(s) for the user to resolve this issue")
+	//how much the user may still go below zero, 0 without an overdraft facility
+	var overdraft float64
+	if overdraft, err = availableOverdraft(ctx, tx, userDebit.UserId); err != nil {
+		return rollback(tx, err)
 	}
 
-	if len(m) == 0 {
-		if rollbackError = tx.Rollback(); rollbackError != nil {
-			return errors.New(fmt.Sprint("unable to rollback. ", rollbackError.Error()))
-		}
-		return errors.New("trying to make a debit call before any credits are transacted for the given user. please allocate new credit(s) for the user to resolve this issue")
+	if len(m) == 0 && overdraft == 0 && hasExpiredCredits {
+		return rollback(tx, errCreditsExpired)
+	}
+
+	if len(m) == 0 && overdraft == 0 {
+		return rollback(tx, errNoCredits)
 	}
 
-	fmt.Println("Debug | insertUserDebit | data in the slice: ", m)
+	logger.Debug(ctx, "available credits for debit", "userid", userDebit.UserId, "credits", len(m), "available", getTotalAmountInUserCredits(m),
+		"overdraft", overdraft)
 
-	canConsume, credits := canConsumeCredits(userDebit, m)
+	canConsume, credits, overdrawn := canConsumeCredits(userDebit, m, overdraft)
 
-	fmt.Println("Debug | insertUserDebit | can consume: ", canConsume)
-	fmt.Println("Debug | insertUserDebit | credits: ", credits)
+	logger.Debug(ctx, "credits evaluated for debit", "userid", userDebit.UserId, "amount", userDebit.Amount, "category", userDebit.Category,
+		"canconsume", canConsume, "overdrawn", overdrawn)
 
+	if !canConsume && getTotalAmountInUserCredits(m)+overdraft >= userDebit.Amount {
+		return rollback(tx, errIneligibleCredit)
+	}
 	if !canConsume {
-		if rollbackError = tx.Rollback(); rollbackError != nil {
-			return errors.New(fmt.Sprint("unable to rollback. ", rollbackError.Error()))
-		}
-		return errors.New("cannot debit more amount than currently present as credit for the given user. please either create more credits or reduce the debit amount to resolve this issue")
+		return rollback(tx, errInsufficientCredit)
 	}
 
 	var stmt *sql.Stmt
 	stmt, err = tx.PrepareContext(ctx, models.UserCreditUpdateStatement)
 	if err != nil {
-		return errors.New(err.Error())
+		return rollback(tx, err)
 	}
 	defer stmt.Close()
 
+	//amount and consumed_amount are moved by the consumed value in the same statement, so they always add up
 	for _, credit := range credits {
-		if _, err = stmt.ExecContext(ctx, credit.Amount, credit.UserId, credit.UserCreditId); err != nil {
-			if rollbackError = tx.Rollback(); rollbackError != nil {
-				return errors.New(fmt.Sprint("unable to rollback. ", rollbackError.Error()))
-			}
-			return errors.New(err.Error())
+		if credit.Consumed == 0.0 {
+			continue
+		}
+		if _, err = stmt.ExecContext(ctx, credit.Consumed, credit.UserId, credit.UserCreditId); err != nil {
+			return rollback(tx, err)
 		}
 	}
 
-	stmt, err = tx.PrepareContext(ctx, models.UserActivityInsertStatement)
-	if err != nil {
-		return errors.New(err.Error())
+	//every row written below belongs to this debit, velocity limits count debits by it
+	var debitId uint64
+	if err = tx.QueryRowContext(ctx, models.DebitNextIdStatement).Scan(&debitId); err != nil {
+		return rollback(tx, err)
 	}
-	defer stmt.Close()
 
+	var before, after debitState
 	for _, credit := range credits {
 		if credit.Consumed == 0.0 {
 			continue
 		}
-		if _, err = stmt.ExecContext(ctx, credit.UserId, false, credit.Consumed, credit.UserCreditId); err != nil {
-			if rollbackError = tx.Rollback(); rollbackError != nil {
-				return errors.New(fmt.Sprint("unable to rollback. ", rollbackError.Error()))
-			}
-			return errors.New(err.Error())
+		var tranId uint64
+		tranId, err = insertActivity(ctx, tx, models.UserActivity{UserId: credit.UserId, Amount: credit.Consumed, UserCreditId: credit.UserCreditId,
+			Merchant: userDebit.Merchant, Category: userDebit.Category, DebitId: debitId})
+		if err != nil {
+			return rollback(tx, err)
 		}
+		//post the consumed part of the credit to the journal
+		if _, err = journal.Post(ctx, tx, journal.Debit(credit.UserId, tranId, credit.UserCreditId, credit.Consumed)); err != nil {
+			return rollback(tx, err)
+		}
+		before.Credits = append(before.Credits, creditState{UserCreditId: credit.UserCreditId, Amount: credit.Amount})
+		after.Credits = append(after.Credits, creditState{UserCreditId: credit.UserCreditId, Amount: roundCents(credit.Amount - credit.Consumed),
+			TranIds: []uint64{tranId}})
+	}
+
+	//the part of the debit beyond the credits is drawn on a new overdraft, repaid by the next credits
+	if overdrawn > 0 {
+		var overdraftId, tranId uint64
+		if err = tx.QueryRowContext(ctx, models.OverdraftInsertStatement, userDebit.UserId, overdrawn).Scan(&overdraftId); err != nil {
+			return rollback(tx, err)
+		}
+		tranId, err = insertActivity(ctx, tx, models.UserActivity{UserId: userDebit.UserId, Amount: overdrawn, OverdraftId: overdraftId,
+			Merchant: userDebit.Merchant, Category: userDebit.Category, DebitId: debitId})
+		if err != nil {
+			return rollback(tx, err)
+		}
+		if _, err = journal.Post(ctx, tx, journal.Debit(userDebit.UserId, tranId, 0, overdrawn)); err != nil {
+			return rollback(tx, err)
+		}
+		after.Overdraft = &overdraftState{OverdraftId: overdraftId, Amount: overdrawn, TranId: tranId}
 	}
 
-	err = tx.Commit()
+	//record who debited the user, where and the credits consumed
+	after.Amount, after.Merchant, after.Category = userDebit.Amount, userDebit.Merchant, userDebit.Category
+	_, err = audit.Record(ctx, tx, audit.Event{Action: audit.ActionDebit, UserId: userDebit.UserId, Reason: userDebit.Reason,
+		Before: before, After: after})
 	if err != nil {
-		return errors.New(err.Error())
+		return rollback(tx, err)
 	}
 
-	fmt.Printf("Debit request processed successfully")
+	err = database.Commit(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	consumed := 0
+	for _, credit := range credits {
+		if credit.Consumed > 0.0 {
+			consumed++
+		}
+	}
+	debitCreditsConsumed.WithLabelValues().Observe(float64(consumed))
+
+	logger.Info(ctx, "user debit processed", "userid", userDebit.UserId, "amount", userDebit.Amount, "credits", consumed, "overdrawn", overdrawn)
 
 	return err
 }
 
-//function containing core logic to process debit from multiple credits based on priority and availability
-func canConsumeCredits(userDebit models.UserDebit, m []models.UserCredit) (bool, []models.UserCredit) {
+//function to roll back a failed transaction and return the error that caused it. when the request context is
+//cancelled database/sql has already rolled the transaction back, so sql.ErrTxDone is not a rollback failure
+func rollback(tx *sql.Tx, err error) error {
+	if rollbackError := tx.Rollback(); rollbackError != nil && rollbackError != sql.ErrTxDone {
+		return errors.New(fmt.Sprint("unable to rollback. ", rollbackError.Error()))
+	}
+	return err
+}
+
+//function containing core logic to process debit from multiple credits based on priority and availability. up to
+//overdraft may be debited beyond the eligible credits, which are then all consumed, the amount overdrawn is returned
+func canConsumeCredits(userDebit models.UserDebit, m []models.UserCredit, overdraft float64) (bool, []models.UserCredit, float64) {
+	//only the credits that may be spent in the category of the debit are consumed
+	m = eligibleCredits(userDebit.Category, m)
 	//processedCredits := make([]models.UserCredit, 0)
 	debitAmount := userDebit.Amount
 	totalAmount := getTotalAmountInUserCredits(m)
 
 	remainingAmount := userDebit.Amount
 
+	if roundCents(debitAmount-totalAmount) > overdraft {
+		return false, m, 0
+	}
 	if debitAmount > totalAmount {
-		return false, m
+		for i, credit := range m {
+			credit.Consumed = credit.Amount
+			credit.Amount = 0.0
+			m[i] = credit
+		}
+		return true, m, roundCents(debitAmount - totalAmount)
 	}
 	//loop to consume credit amount(when one or more credits are involved)
 	/*
@@ -525,7 +811,45 @@ func canConsumeCredits(userDebit models.UserDebit, m []models.UserCredit) (bool,
 			break
 		}
 	}
-	return true, m
+	return true, m, 0
+}
+
+//function to keep the credits that may be spent in category, in the same order
+func eligibleCredits(category string, m []models.UserCredit) []models.UserCredit {
+	eligible := make([]models.UserCredit, 0, len(m))
+	for _, credit := range m {
+		if credit.AllowsCategory(category) {
+			eligible = append(eligible, credit)
+		}
+	}
+	return eligible
+}
+
+//function to normalize the category and the merchant of a debit and check they are not too long
+func validateDebitCategory(userDebit *models.UserDebit) error {
+	userDebit.Category = models.NormalizeCategory(userDebit.Category)
+	userDebit.Merchant = strings.TrimSpace(userDebit.Merchant)
+	if len(userDebit.Category) > 50 || len(userDebit.Merchant) > 100 {
+		return errInvalidCategory
+	}
+	return nil
+}
+
+//function to normalize the allowed categories of a credit and drop the empty and repeated ones
+func normalizeCategories(categories []string) ([]string, error) {
+	var normalized []string
+	seen := make(map[string]bool)
+	for _, category := range categories {
+		category = models.NormalizeCategory(category)
+		if len(category) > 50 {
+			return nil, errInvalidCategory
+		}
+		if len(category) > 0 && !seen[category] {
+			seen[category] = true
+			normalized = append(normalized, category)
+		}
+	}
+	return normalized, nil
 }
 
 //function to calculate total amount present in credits(expired one's are already filtered out)
@@ -537,48 +861,95 @@ func getTotalAmountInUserCredits(m []models.UserCredit) float64 {
 	return totalAmount
 }
 
-//function to convert UserActivity slice to byte slice
-func encodeToBytes(activity []models.UserActivity) []byte {
+//function to round an amount to cents, so that debits, overdrafts and recorded states do not show floating point noise
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+//function to build the transaction history response, identical whether activities come from the cache or the database
+func activityResponse(activities []models.UserActivity) responseActivity {
+	res := responseActivity{
+		Success:    true,
+		Activities: activities,
+	}
+	if len(activities) == 0 {
+		res.Message = "Cannot find any transaction history for given user. "
+		res.Activities = []models.UserActivity{}
+	}
+	return res
+}
+
+//ResponseCacheStats returns the hits, misses and corrupt entries seen while reading the transaction history cache
+func ResponseCacheStats() cache.StatsSnapshot {
+	return responseCacheStats.Snapshot()
+}
+
+//function to read activities from the cache, an entry that cannot be decoded is evicted and reported as a miss.
+//an empty key means the cache is bypassed for this request
+func readCachedActivities(ctx context.Context, responseCache cache.Cache, key string) ([]models.UserActivity, bool) {
+	if len(key) == 0 {
+		return nil, false
+	}
+	entry, err := responseCache.Get(key)
+	if err != nil {
+		responseCacheStats.Miss()
+		return nil, false
+	}
+	activities, err := decodeToUserActivity(entry)
+	if err != nil {
+		responseCacheStats.Corrupt()
+		logger.Warn(ctx, "evicting corrupt cache entry", "key", key, "error", err)
+		responseCache.Delete(key)
+		return nil, false
+	}
+	responseCacheStats.Hit()
+	return activities, true
+}
+
+//function to convert UserActivity slice to byte slice, prefixed with activityCacheVersion
+func encodeToBytes(activity []models.UserActivity) ([]byte, error) {
 	buf := bytes.Buffer{}
+	buf.WriteByte(activityCacheVersion)
 	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(activity)
-	if err != nil {
-		log.Fatal(err)
+	if err := enc.Encode(activity); err != nil {
+		return nil, err
 	}
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
 //function to convert []byte back to the []models.UserActivity
-func decodeToUserActivity(s []byte) []models.UserActivity {
+func decodeToUserActivity(s []byte) ([]models.UserActivity, error) {
+	if len(s) == 0 || s[0] != activityCacheVersion {
+		return nil, errCacheVersion
+	}
 	var activities []models.UserActivity
-	dec := gob.NewDecoder(bytes.NewReader(s))
-	err := dec.Decode(&activities)
+	dec := gob.NewDecoder(bytes.NewReader(s[1:]))
+	if err := dec.Decode(&activities); err != nil {
+		return nil, err
+	}
+	return activities, nil
+}
+
+//function to build the cache key, format of the key is "userid_generation_relative api url"
+func cacheKey(user string, url string) (string, error) {
+	generation, err := createCache().Generation(user)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-	return activities
+	return fmt.Sprint(user, "_", generation, "_", url), nil
 }
 
 //function to invalidate the cache whenever we receive a POST call for credit or debit
-//as we need to pull the latest activities in subsequent transactions call
-func invalidateCache(user string) bool {
+//as we need to pull the latest activities in subsequent transactions call.
+//bumping the user's generation is O(1), entries of older generations are never read again and age out of the cache
+func InvalidateCache(ctx context.Context, user string) bool {
 	if len(user) == 0 {
 		return false
 	}
-	cache = createCache()
-	//create iterator for keys and values in cache
-	iterator := cache.Iterator()
-	for iterator.SetNext() {
-		current, err := iterator.Value()
-		if err != nil {
-			return false
-		}
-		//if cache key contains specific user id then remove it
-		if strings.HasPrefix(current.Key(), user) {
-			fmt.Println(fmt.Sprint("debug | invalidateCache | user: ", user, " removing key: ", current.Key()))
-			cache.Delete(current.Key())
-			continue
-		}
+	if err := createCache().Invalidate(user); err != nil {
+		logger.Error(ctx, "unable to bump cache generation", "userid", user, "error", err)
+		return false
 	}
+	logger.Debug(ctx, "bumped cache generation", "userid", user)
 	return true
 }
@@ -0,0 +1,564 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a0rana/UserAccountService/auth"
+	"github.com/a0rana/UserAccountService/cache"
+	"github.com/a0rana/UserAccountService/config"
+	"github.com/a0rana/UserAccountService/logger"
+	"github.com/a0rana/UserAccountService/models"
+	"github.com/a0rana/UserAccountService/ratelimit"
+	"github.com/a0rana/UserAccountService/spending"
+	"github.com/lib/pq"
+)
+
+//test case to verify that invalidating a user's cache makes previously built keys unreachable
+func TestInvalidateCacheChangesKey(t *testing.T) {
+	user := "7507decb-0f2d-4510-8202-c78699ed3153"
+	before, err := cacheKey(user, "/transactions")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !InvalidateCache(context.Background(), user) {
+		t.Fatal("Expected cache invalidation to succeed")
+	}
+	if after, _ := cacheKey(user, "/transactions"); after == before {
+		t.Errorf("Expected a new cache key after invalidation. Got %s for both", after)
+	}
+}
+
+//test case to verify that parallel invalidations are neither lost nor racing with key creation
+func TestInvalidateCacheConcurrent(t *testing.T) {
+	user := "b1a1f1c2-5d2e-4a7b-9c3d-0e1f2a3b4c5d"
+	//generations are drawn from a counter shared by every user, an invalidation of another user before and after
+	//tells how many were drawn in between
+	probe := "probe-" + user
+	InvalidateCache(context.Background(), probe)
+	first, _ := createCache().Generation(probe)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			InvalidateCache(context.Background(), user)
+		}()
+		go func() {
+			defer wg.Done()
+			cacheKey(user, "/transactions")
+		}()
+	}
+	wg.Wait()
+
+	InvalidateCache(context.Background(), probe)
+	last, _ := createCache().Generation(probe)
+	if key, _ := cacheKey(user, "/transactions"); last-first != 51 || key != fmt.Sprint(user, "_", last-1, "_/transactions") {
+		t.Errorf("Expected generation %d after 50 invalidations. Got %s", first+50, key)
+	}
+}
+
+//test case to verify an empty user id is rejected
+func TestInvalidateCacheEmptyUser(t *testing.T) {
+	if InvalidateCache(context.Background(), "") {
+		t.Error("Expected invalidation of an empty user id to fail")
+	}
+}
+
+//test case to verify cached activities survive an encode/decode round trip
+func TestActivityCacheRoundTrip(t *testing.T) {
+	activities := []models.UserActivity{{UserId: "user", Created: "2021-02-04T21:22:18Z", IsCredit: true, Amount: 5}}
+	entry, err := encodeToBytes(activities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeToUserActivity(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 1 || decoded[0] != activities[0] {
+		t.Errorf("Expected %v after round trip. Got %v", activities, decoded)
+	}
+}
+
+//test case to verify entries of another version or with a corrupt payload are rejected instead of crashing
+func TestActivityCacheRejectsBadEntries(t *testing.T) {
+	entry, _ := encodeToBytes([]models.UserActivity{{UserId: "user", Amount: 5}})
+	stale := append([]byte{activityCacheVersion + 1}, entry[1:]...)
+
+	for name, bad := range map[string][]byte{"empty": nil, "version": stale, "truncated": entry[:len(entry)/2]} {
+		if _, err := decodeToUserActivity(bad); err == nil {
+			t.Errorf("Expected decode error for %s entry", name)
+		}
+	}
+}
+
+//test case to verify a corrupt entry is evicted and counted, and reading it again is a plain miss
+func TestReadCachedActivitiesEvictsCorruptEntry(t *testing.T) {
+	c, err := cache.NewBigCache(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Set("corrupt", []byte{activityCacheVersion, 0xff, 0x00}, 0)
+	before := ResponseCacheStats()
+
+	if _, found := readCachedActivities(context.Background(), c, "corrupt"); found {
+		t.Error("Expected corrupt entry to be reported as a miss")
+	}
+	if _, err := c.Get("corrupt"); err != cache.ErrNotFound {
+		t.Errorf("Expected corrupt entry to be evicted. Got %v", err)
+	}
+	readCachedActivities(context.Background(), c, "corrupt")
+
+	after := ResponseCacheStats()
+	if after.Corrupt-before.Corrupt != 1 || after.Misses-before.Misses != 2 {
+		t.Errorf("Expected 1 corrupt entry and 2 misses. Got %+v, before %+v", after, before)
+	}
+}
+
+//test case to verify that empty and non-empty history share the same response shape
+func TestActivityResponseShape(t *testing.T) {
+	for _, activities := range [][]models.UserActivity{nil, {}, {{UserId: "user", Amount: 5}}} {
+		body, err := json.Marshal(activityResponse(activities))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res map[string]json.RawMessage
+		json.Unmarshal(body, &res)
+		if string(res["success"]) != "true" || !bytes.HasPrefix(res["activities"], []byte("[")) {
+			t.Errorf("Expected success and an activities list. Got %s", body)
+		}
+	}
+}
+
+//test case to verify a request id is generated when missing, kept when valid and put in the request context
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logger.RequestID(r.Context())
+	}))
+
+	for header, keep := range map[string]bool{"": false, "abc-123": true, "forged\nline": false} {
+		req := httptest.NewRequest("GET", "/transactions", nil)
+		req.Header.Set(RequestIDHeader, header)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if len(seen) == 0 || rr.Header().Get(RequestIDHeader) != seen {
+			t.Errorf("Expected request id in context and response. Got %q and %q", seen, rr.Header().Get(RequestIDHeader))
+		}
+		if keep != (seen == header) {
+			t.Errorf("Expected caller id %q kept: %v. Got %q", header, keep, seen)
+		}
+	}
+}
+
+//test case to verify debit errors are classified for the debit metrics
+func TestDebitOutcome(t *testing.T) {
+	for err, outcome := range map[error]string{
+		nil:                                     "success",
+		errInsufficientCredit:                   "insufficient_credit",
+		fmt.Errorf("wrapped: %w", errNoCredits): "no_credits",
+		errors.New("connection refused"):        "error",
+		&spending.Violation{Limit: spending.LimitDailyTotal}: "spending_limit_exceeded",
+		errAccountFrozen:    "account_frozen",
+		errIneligibleCredit: "ineligible_credit",
+	} {
+		if got := debitOutcome(err); got != outcome {
+			t.Errorf("Expected outcome %s for %v. Got %s", outcome, err, got)
+		}
+	}
+}
+
+//test case to verify a debit only consumes the credits allowed in its category, by priority
+func TestCanConsumeCredits(t *testing.T) {
+	credits := func() []models.UserCredit {
+		return []models.UserCredit{
+			{UserCreditId: 1, Amount: 5, Priority: 9, AllowedCategories: []string{"food"}},
+			{UserCreditId: 2, Amount: 3, Priority: 5},
+			{UserCreditId: 3, Amount: 4, Priority: 1, AllowedCategories: []string{"travel", "fuel"}},
+		}
+	}
+	consumed := func(m []models.UserCredit) map[uint64]float64 {
+		c := make(map[uint64]float64)
+		for _, credit := range m {
+			if credit.Consumed > 0 {
+				c[credit.UserCreditId] = credit.Consumed
+			}
+		}
+		return c
+	}
+
+	ok, m, _ := canConsumeCredits(models.UserDebit{Amount: 7, Category: " Food"}, credits(), 0)
+	if c := consumed(m); !ok || len(c) != 2 || c[1] != 5 || c[2] != 2 {
+		t.Errorf("Expected the food credit then the unrestricted one to be consumed. Got %v, %v", ok, c)
+	}
+	ok, m, _ = canConsumeCredits(models.UserDebit{Amount: 3}, credits(), 0)
+	if c := consumed(m); !ok || len(c) != 1 || c[2] != 3 {
+		t.Errorf("Expected a debit without category to consume only the unrestricted credit. Got %v, %v", ok, c)
+	}
+	if ok, _, _ = canConsumeCredits(models.UserDebit{Amount: 8, Category: "travel"}, credits(), 0); ok {
+		t.Error("Expected the credits restricted to other categories not to be consumed")
+	}
+}
+
+//test case to verify a debit beyond the eligible credits consumes all of them and overdraws the rest, up to the
+//overdraft available
+func TestCanConsumeCreditsOverdraft(t *testing.T) {
+	credits := func() []models.UserCredit {
+		return []models.UserCredit{
+			{UserCreditId: 1, Amount: 5, Priority: 9, AllowedCategories: []string{"food"}},
+			{UserCreditId: 2, Amount: 3.3, Priority: 5},
+		}
+	}
+	ok, m, overdrawn := canConsumeCredits(models.UserDebit{Amount: 10}, credits(), 10)
+	if !ok || overdrawn != 6.7 || len(m) != 1 || m[0].Consumed != 3.3 || m[0].Amount != 0 {
+		t.Errorf("Expected the unrestricted credit to be consumed and 6.7 overdrawn. Got %v, %v, %+v", ok, overdrawn, m)
+	}
+	if ok, _, overdrawn = canConsumeCredits(models.UserDebit{Amount: 2}, credits(), 10); !ok || overdrawn != 0 {
+		t.Errorf("Expected a debit covered by the credits not to overdraw. Got %v, %v", ok, overdrawn)
+	}
+	if ok, _, _ = canConsumeCredits(models.UserDebit{Amount: 10}, credits(), 6.69); ok {
+		t.Error("Expected a debit beyond the credits and the overdraft to be rejected")
+	}
+	if ok, m, overdrawn = canConsumeCredits(models.UserDebit{Amount: 4}, nil, 4); !ok || overdrawn != 4 || len(m) != 0 {
+		t.Errorf("Expected a user without credits to overdraw the whole debit. Got %v, %v", ok, overdrawn)
+	}
+}
+
+//test case to verify a credit repays the outstanding overdrafts oldest first and the last one it reaches only in part
+func TestAllocateRepayments(t *testing.T) {
+	overdrafts := []overdraft{{id: 1, outstanding: 2.5}, {id: 2, outstanding: 4}, {id: 3, outstanding: 1}}
+	for _, tc := range []struct {
+		amount   float64
+		payments []float64
+	}{
+		{10, []float64{2.5, 4, 1}},
+		{5.1, []float64{2.5, 2.6}},
+		{2.5, []float64{2.5}},
+		{1.2, []float64{1.2}},
+		{0, nil},
+	} {
+		if payments := allocateRepayments(tc.amount, overdrafts); fmt.Sprint(payments) != fmt.Sprint(tc.payments) {
+			t.Errorf("Expected %v to repay %v. Got %v", tc.amount, tc.payments, payments)
+		}
+	}
+}
+
+//test case to verify liveness never depends on the dependencies while readiness reports each of them
+func TestHealthAndReadiness(t *testing.T) {
+	rr := httptest.NewRecorder()
+	Health(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected liveness to succeed. Got %d", rr.Code)
+	}
+
+	//no database has been set in unit tests, so readiness must fail and say why
+	rr = httptest.NewRecorder()
+	Ready(rr, httptest.NewRequest("GET", "/readyz", nil))
+	var res responseHealth
+	json.Unmarshal(rr.Body.Bytes(), &res)
+	if rr.Code != http.StatusServiceUnavailable || res.Status != "unavailable" || res.Checks["database"].Status != "fail" {
+		t.Errorf("Expected readiness to fail on the database check. Got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+//test case to verify timeouts and unreachable databases are reported with their own status and code
+func TestErrorStatus(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	timeout := timeoutError(ctx, errors.New("pq: canceling statement due to user request"))
+
+	for _, tc := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{timeout, http.StatusGatewayTimeout, CodeDBTimeout},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, http.StatusServiceUnavailable, CodeDBUnavailable},
+		{&pq.Error{Code: "57P03"}, http.StatusServiceUnavailable, CodeDBUnavailable},
+		{errInsufficientCredit, http.StatusUnprocessableEntity, "insufficient_credit"},
+		{errNoCredits, http.StatusUnprocessableEntity, "no_credits"},
+		{errCreditsExpired, http.StatusUnprocessableEntity, "credits_expired"},
+		{errIneligibleCredit, http.StatusUnprocessableEntity, "ineligible_credit"},
+		{errInvalidDebitAmount, http.StatusBadRequest, "invalid_amount"},
+		{&spending.Violation{Limit: spending.LimitSingleDebit}, http.StatusUnprocessableEntity, "spending_limit_exceeded"},
+		{errAccountFrozen, http.StatusConflict, "account_frozen"},
+		{errAccountClosed, http.StatusConflict, "account_closed"},
+		{errUserNotFound, http.StatusNotFound, CodeUserNotFound},
+		{errInvalidCategory, http.StatusBadRequest, CodeInvalidRequest},
+		{&pq.Error{Code: "23503"}, http.StatusInternalServerError, CodeInternal},
+	} {
+		if status, code := errorStatus(tc.err); status != tc.status || code != tc.code {
+			t.Errorf("Expected %d %s for %v. Got %d %s", tc.status, tc.code, tc.err, status, code)
+		}
+	}
+	if err := timeoutError(context.Background(), errInsufficientCredit); err != errInsufficientCredit {
+		t.Errorf("Expected errors of a live context to be returned as is. Got %v", err)
+	}
+}
+
+//test case to verify a malformed request body is rejected as an invalid request by every handler
+func TestHandlersRejectMalformedBody(t *testing.T) {
+	for path, handler := range map[string]http.HandlerFunc{"/balance": GetBalance, "/chain/head": GetActivityChainHead,
+		"/transactions": GetAllTransactions, "/credit": CreateUserCredit, "/debit": CreateUserDebit} {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest("GET", path, strings.NewReader(`{"userid":`)))
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), `"code":"invalid_request"`) {
+			t.Errorf("Expected %s to reject the body with 400 invalid_request. Got %d %s", path, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+//test case to verify categories and merchants that are too long are rejected as invalid requests before the
+//database is used
+func TestWriteHandlersRejectLongCategories(t *testing.T) {
+	long := strings.Repeat("x", 51)
+	for _, tc := range []struct {
+		handler http.HandlerFunc
+		body    string
+	}{
+		{CreateUserCredit, fmt.Sprint(`{"userid":"u1","amount":1,"allowed_categories":["`, long, `"]}`)},
+		{CreateUserDebit, fmt.Sprint(`{"userid":"u1","amount":1,"category":"`, long, `"}`)},
+		{CreateUserDebit, fmt.Sprint(`{"userid":"u1","amount":1,"merchant":"`, strings.Repeat(long, 2), `"}`)},
+	} {
+		rr := httptest.NewRecorder()
+		tc.handler(rr, httptest.NewRequest("POST", "/", strings.NewReader(tc.body)))
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), `"code":"invalid_request"`) {
+			t.Errorf("Expected %s to be rejected with 400 invalid_request. Got %d %s", tc.body, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+//test case to verify the admin changes recorded in the audit log are rejected without a reason
+func TestAdminRequestsRequireReason(t *testing.T) {
+	for _, tc := range []struct {
+		handler http.HandlerFunc
+		body    string
+	}{
+		{SetUserSpendingLimits, `{"userid":"u1","max_single_debit":1}`},
+		{SetUserSpendingLimits, `{"segment":"enterprise"}`},
+		{UpdateUserSegment, `{"userid":"u1","segment":"enterprise"}`},
+		{UpdateAccountStatus, `{"userid":"u1","status":"frozen"}`},
+		{UpdateOverdraftLimit, `{"userid":"u1","limit":5}`},
+	} {
+		rr := httptest.NewRecorder()
+		tc.handler(rr, httptest.NewRequest("POST", "/", strings.NewReader(tc.body)))
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "reason is required") {
+			t.Errorf("Expected %s to be rejected for its missing reason. Got %d %s", tc.body, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+//test case to verify that only credits that are neither expired nor past their expiry count towards the available
+//balance and that the original and consumed amounts of every credit are summed up
+func TestUserBalanceBreakdown(t *testing.T) {
+	now := time.Date(2021, 2, 4, 12, 0, 0, 0, time.UTC)
+	credits := []models.UserCredit{
+		{Amount: 5, OriginalAmount: 5, Expiry: "2021-03-01T00:00:00Z"},
+		{Amount: 3, OriginalAmount: 4, ConsumedAmount: 1, Expiry: "2021-03-01T00:00:00Z", IsExpired: true},
+		{Amount: 2, OriginalAmount: 2, Expiry: "2021-02-04T12:00:00Z"},
+		{Amount: 1.5, OriginalAmount: 3, ConsumedAmount: 1.5, Expiry: "2021-02-05T00:00:00Z"},
+	}
+	balance := newUserBalance("7507decb-0f2d-4510-8202-c78699ed3153", credits, now)
+	if balance.Available != 6.5 || balance.Expired != 5 || balance.Original != 14 || balance.Consumed != 2.5 {
+		t.Errorf("expected available 6.5, expired 5, original 14 and consumed 2.5, got %+v", balance)
+	}
+}
+
+//test case to verify requests without valid credentials are rejected and the principal reaches the handler
+func TestAuthenticate(t *testing.T) {
+	defer func(a auth.Authenticator) { authenticator = a }(authenticator)
+	authenticator = auth.JWT{HMACSecret: []byte("0123456789abcdef0123456789abcdef")}
+	token, _ := auth.SignHS256(auth.Claims{Subject: "ops", ExpiresAt: time.Now().Add(time.Minute).Unix()},
+		[]byte("0123456789abcdef0123456789abcdef"))
+
+	var subject string
+	handler := Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal, ok := auth.FromContext(r.Context()); ok {
+			subject = principal.Subject
+		}
+	}))
+
+	for authorization, status := range map[string]int{
+		"":                           http.StatusUnauthorized,
+		"Bearer not-a-token":         http.StatusUnauthorized,
+		fmt.Sprint("Bearer ", token): http.StatusOK,
+	} {
+		req := httptest.NewRequest("GET", "/transactions", nil)
+		req.Header.Set("Authorization", authorization)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != status {
+			t.Errorf("Expected %d for %q. Got %d %s", status, authorization, rr.Code, rr.Body.String())
+		}
+	}
+	if subject != "ops" {
+		t.Errorf("Expected principal ops in the request context. Got %q", subject)
+	}
+}
+
+//test case to verify routes reject principals without their scope and user-scoped principals acting on other users
+func TestAuthorization(t *testing.T) {
+	app := &auth.Principal{Subject: "app", Scopes: []string{auth.ScopeRead, auth.ScopeDebit}, UserId: "7507decb-0f2d-4510-8202-c78699ed3153"}
+	ctx := auth.WithPrincipal(context.Background(), app)
+
+	handler := RequireScope(auth.ScopeCredit, func(w http.ResponseWriter, r *http.Request) {})
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("POST", "/credit", nil).WithContext(ctx))
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), CodeForbidden) {
+		t.Errorf("Expected credit without the credit scope to be forbidden. Got %d %s", rr.Code, rr.Body.String())
+	}
+
+	//the check on the user happens before any database access
+	rr = httptest.NewRecorder()
+	body := strings.NewReader(`{"userid":"2a1f5c1e-8d3b-4f0a-9c55-1b7f0e4d2c6a","amount":5}`)
+	CreateUserDebit(rr, httptest.NewRequest("POST", "/debit", body).WithContext(ctx))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected debit of another user to be forbidden. Got %d %s", rr.Code, rr.Body.String())
+	}
+	if err := authorizeUser(ctx, app.UserId); err != nil {
+		t.Errorf("Expected the principal's own user to be allowed. Got %v", err)
+	}
+}
+
+//test case to verify preflight requests are answered for allowed origins only and never reach the handlers
+func TestCORS(t *testing.T) {
+	cors = config.CORSConfig{AllowedOrigins: []string{"https://app.example.com"}, AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Authorization", "Content-Type"}, ExposedHeaders: []string{RequestIDHeader}, MaxAge: 10 * time.Minute}
+	defer func() { cors = config.CORSConfig{} }()
+	reached := false
+	handler := CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true }))
+
+	preflight := func(origin, method, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", "/debit", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		req.Header.Set("Access-Control-Request-Headers", headers)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := preflight("https://app.example.com", "POST", "authorization, content-type")
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		rr.Header().Get("Access-Control-Allow-Methods") != "GET, POST" || rr.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("Expected an allowed preflight. Got %d %v", rr.Code, rr.Header())
+	}
+	for _, rr := range []*httptest.ResponseRecorder{
+		preflight("https://evil.example.com", "POST", "content-type"),
+		preflight("https://app.example.com", "DELETE", ""),
+		preflight("https://app.example.com", "POST", "X-Custom"),
+	} {
+		if rr.Code != http.StatusNoContent || len(rr.Header().Get("Access-Control-Allow-Origin")) > 0 {
+			t.Errorf("Expected a preflight without CORS headers. Got %d %v", rr.Code, rr.Header())
+		}
+	}
+	if reached {
+		t.Error("Expected preflight requests not to reach the handler")
+	}
+
+	req := httptest.NewRequest("GET", "/balance", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if !reached || rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		rr.Header().Get("Access-Control-Expose-Headers") != RequestIDHeader || rr.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("Expected the request to reach the handler with CORS headers. Got %v", rr.Header())
+	}
+}
+
+//test case to verify the address limit applies to requests failing authentication, before their credentials are checked
+func TestRateLimitAddress(t *testing.T) {
+	limiter = ratelimit.NewMemory()
+	addressLimit = ratelimit.Rate{Limit: 1, Period: time.Minute, Burst: 1}
+	defer func() {
+		limiter.Close()
+		limiter, addressLimit = nil, ratelimit.Rate{}
+	}()
+
+	handler := RateLimitAddress(Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	for i, expected := range []int{http.StatusUnauthorized, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("POST", "/debit", nil)
+		req.RemoteAddr = "192.0.2.7:1234"
+		req.Header.Set(auth.APIKeyHeader, "uas_guessed")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != expected {
+			t.Errorf("Expected status %d for request %d. Got %d", expected, i+1, rr.Code)
+		}
+	}
+}
+
+//test case to verify clients and users going beyond the limits of a route get a 429 with Retry-After
+func TestRateLimit(t *testing.T) {
+	limiter = ratelimit.NewMemory()
+	rate := ratelimit.Rate{Limit: 1, Period: time.Minute, Burst: 1}
+	clientLimits = map[string]ratelimit.Rate{"/debit": rate}
+	userLimits = map[string]ratelimit.Rate{"/debit": rate}
+	defer func() {
+		limiter.Close()
+		limiter, clientLimits, userLimits = nil, nil, nil
+	}()
+
+	handler := RateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i, expected := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("POST", "/debit", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != expected {
+			t.Errorf("Expected status %d for request %d. Got %d", expected, i+1, rr.Code)
+		}
+		if expected == http.StatusTooManyRequests && (rr.Header().Get("Retry-After") != "60" || !strings.Contains(rr.Body.String(), CodeRateLimited)) {
+			t.Errorf("Expected Retry-After and the rate_limited code. Got %v %s", rr.Header(), rr.Body.String())
+		}
+	}
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Subject: "app", Method: auth.MethodAPIKey})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/debit", nil).WithContext(ctx))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected another client to have its own limit. Got %d", rr.Code)
+	}
+
+	//the user limit is checked before any database access
+	user := "7507decb-0f2d-4510-8202-c78699ed3153"
+	if err := limitUser(httptest.NewRecorder(), httptest.NewRequest("POST", "/debit", nil), user); err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	body := strings.NewReader(`{"userid":"` + user + `","amount":5}`)
+	CreateUserDebit(rr, httptest.NewRequest("POST", "/debit", body).WithContext(ctx))
+	if rr.Code != http.StatusTooManyRequests || len(rr.Header().Get("Retry-After")) == 0 {
+		t.Errorf("Expected the second debit of the user to be rate limited. Got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+//test case to verify the audit log filter is read from the query parameters and invalid ones are rejected
+func TestAuditFilter(t *testing.T) {
+	r := httptest.NewRequest("GET", "/admin/audit?userid=u1&action=credit&limit=10&before=42&since=2021-02-04T21:22:18Z", nil)
+	filter, err := auditFilter(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.UserId != "u1" || filter.Action != "credit" || filter.Limit != 10 || filter.BeforeId != 42 || filter.Since.IsZero() || !filter.Until.IsZero() {
+		t.Errorf("Expected the filter of the query. Got %+v", filter)
+	}
+	for _, query := range []string{"limit=0", "limit=501", "before=x", "until=yesterday"} {
+		if _, err := auditFilter(httptest.NewRequest("GET", "/admin/audit?"+query, nil)); err == nil {
+			t.Errorf("Expected %s to be rejected", query)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/a0rana/UserAccountService/migrations"
+)
+
+//time given to each readiness check before it is reported as failing
+const readinessTimeout = 2 * time.Second
+
+//set to 1 once the service starts shutting down, readiness fails from then on so no new traffic is routed to it
+var draining int32
+
+//response format for the health and readiness probes
+type responseHealth struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+//status of a single dependency
+type healthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+//SetDraining marks the service as shutting down, readiness reports failure from now on
+func SetDraining() {
+	atomic.StoreInt32(&draining, 1)
+}
+
+//Health reports that the process is alive, it does not depend on anything
+func Health(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseHealth{Status: "ok"})
+}
+
+//Ready reports whether the service can handle traffic: database reachable, schema version current and cache initialised
+func Ready(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	checks := map[string]healthCheck{
+		"database": runCheck(r.Context(), checkDatabase),
+		"schema":   runCheck(r.Context(), checkSchema),
+		"cache":    runCheck(r.Context(), checkCache),
+	}
+	if atomic.LoadInt32(&draining) == 1 {
+		checks["shutdown"] = healthCheck{Status: "fail", Error: "service is shutting down"}
+	}
+
+	res := responseHealth{Status: "ok", Checks: checks}
+	for _, check := range checks {
+		if check.Status != "ok" {
+			res.Status = "unavailable"
+			w.WriteHeader(http.StatusServiceUnavailable)
+			break
+		}
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+//function to run a readiness check with its own timeout
+func runCheck(ctx context.Context, check func(ctx context.Context) error) healthCheck {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	if err := check(ctx); err != nil {
+		return healthCheck{Status: "fail", Error: err.Error()}
+	}
+	return healthCheck{Status: "ok"}
+}
+
+func checkDatabase(ctx context.Context) error {
+	if db == nil {
+		return errors.New("database is not initialised")
+	}
+	return db.PingContext(ctx)
+}
+
+//the schema must be at the version this build was written for
+func checkSchema(ctx context.Context) error {
+	if db == nil {
+		return errors.New("database is not initialised")
+	}
+	return migrations.Check(ctx, db)
+}
+
+//the cache must have been set by Init, backends able to check their server(redis) are pinged as well
+func checkCache(ctx context.Context) error {
+	if responseCache == nil {
+		return errors.New("cache is not initialised")
+	}
+	if pinger, ok := responseCache.(interface{ Ping() error }); ok {
+		return pinger.Ping()
+	}
+	return nil
+}
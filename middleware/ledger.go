@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/a0rana/UserAccountService/audit"
+	"github.com/a0rana/UserAccountService/database"
+	"github.com/a0rana/UserAccountService/hashchain"
+	"github.com/a0rana/UserAccountService/journal"
+	"github.com/a0rana/UserAccountService/logger"
+	"github.com/a0rana/UserAccountService/models"
+	"github.com/lib/pq"
+)
+
+//errors returned when a debit cannot be reversed
+var (
+	errActivityNotFound = errors.New("cannot find the given transaction for the given user")
+	errNotADebit        = errors.New("only debit transactions can be reversed")
+	errAlreadyReversed  = errors.New("the given debit transaction has already been reversed")
+	errOverdraftDebit   = errors.New("debits drawn on an overdraft and overdraft repayments cannot be reversed")
+)
+
+//GetUserBalance returns every credit of the user and the amount that can currently be debited
+func GetUserBalance(ctx context.Context, userid string) (balance models.UserBalance, err error) {
+	//bound the query by its own timeout on top of the caller context
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	defer func() { err = timeoutError(ctx, err) }()
+
+	db := getConnection()
+
+	credits, err := getUserCredits(ctx, db, userid)
+	if err != nil {
+		return balance, err
+	}
+	balance = newUserBalance(userid, credits, time.Now())
+	balance.OverdraftLimit, balance.Overdrawn, err = getOverdraft(ctx, db, userid)
+	return balance, err
+}
+
+//GetLedger returns the credits and the complete activity of the user in transaction order
+func GetLedger(ctx context.Context, userid string) (ledger models.Ledger, err error) {
+	//bound the queries by their own timeout on top of the caller context
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	defer func() { err = timeoutError(ctx, err) }()
+
+	db := getConnection()
+
+	credits, err := getUserCredits(ctx, db, userid)
+	if err != nil {
+		return ledger, err
+	}
+	ledger.UserBalance = newUserBalance(userid, credits, time.Now())
+	if ledger.OverdraftLimit, ledger.Overdrawn, err = getOverdraft(ctx, db, userid); err != nil {
+		return ledger, err
+	}
+
+	rows, err := db.QueryContext(ctx, models.UserActivityLedgerStatement, userid)
+	if err != nil {
+		return ledger, fmt.Errorf("Unable to execute the query. %w", err)
+	}
+	defer rows.Close()
+
+	ledger.Activities = make([]models.UserActivity, 0)
+	for rows.Next() {
+		var activity models.UserActivity
+		if err = rows.Scan(&activity.UserId, &activity.TranId, &activity.Created, &activity.IsCredit, &activity.Amount,
+			&activity.UserCreditId, &activity.ReversalOfTranId, &activity.Event, &activity.Merchant, &activity.Category, &activity.OverdraftId, &activity.PrevHash,
+			&activity.Hash); err != nil {
+			return ledger, fmt.Errorf("Unable to scan the row. %w", err)
+		}
+		ledger.Activities = append(ledger.Activities, activity)
+	}
+	return ledger, rows.Err()
+}
+
+//ReverseDebit gives the amount of a debit transaction back to the credit it consumed and logs the reversal as a
+//credit activity pointing at the debit, a debit can only be reversed once. reason is recorded in the audit log.
+//returns the id of the reversal transaction
+func ReverseDebit(ctx context.Context, userid string, tranid uint64, reason string) (_ uint64, err error) {
+	//bound the whole transaction by its own timeout on top of the caller context
+	ctx, cancel := context.WithTimeout(ctx, txTimeout)
+	defer cancel()
+	defer func() { err = timeoutError(ctx, err) }()
+
+	tx, err := getConnection().BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return 0, err
+	}
+
+	var isCredit, isOverdraft, reversed bool
+	var amount float64
+	var userCreditId uint64
+	err = tx.QueryRowContext(ctx, models.UserActivityDebitStatement, userid, tranid).Scan(&isCredit, &isOverdraft, &amount, &userCreditId,
+		&reversed)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, rollback(tx, errActivityNotFound)
+	case err != nil:
+		return 0, rollback(tx, err)
+	case isCredit:
+		return 0, rollback(tx, errNotADebit)
+	case isOverdraft:
+		//the overdraft may already be partly repaid by later credits
+		return 0, rollback(tx, errOverdraftDebit)
+	case reversed:
+		return 0, rollback(tx, errAlreadyReversed)
+	}
+
+	var creditAmount float64
+	if err = tx.QueryRowContext(ctx, models.UserCreditRestoreStatement, amount, userid, userCreditId).Scan(&creditAmount); err != nil {
+		return 0, rollback(tx, err)
+	}
+
+	reversalId, err := insertActivity(ctx, tx, models.UserActivity{UserId: userid, IsCredit: true, Amount: amount, UserCreditId: userCreditId,
+		ReversalOfTranId: tranid})
+	if err != nil {
+		return 0, rollback(tx, err)
+	}
+
+	if _, err = journal.Post(ctx, tx, journal.Reversal(userid, reversalId, userCreditId, amount)); err != nil {
+		return 0, rollback(tx, err)
+	}
+
+	_, err = audit.Record(ctx, tx, audit.Event{
+		Action:   audit.ActionReversal,
+		UserId:   userid,
+		Entity:   audit.EntityActivity,
+		EntityId: tranid,
+		Reason:   reason,
+		Before:   reversalState{TranId: tranid, UserCreditId: userCreditId, CreditAmount: roundCents(creditAmount - amount)},
+		After: reversalState{TranId: tranid, UserCreditId: userCreditId, CreditAmount: creditAmount, Reversed: true,
+			ReversalTranId: reversalId},
+	})
+	if err != nil {
+		return 0, rollback(tx, err)
+	}
+
+	if err = database.Commit(ctx, tx); err != nil {
+		return 0, err
+	}
+
+	logger.Info(ctx, "user debit reversed", "userid", userid, "tranid", tranid, "reversal", reversalId, "amount", amount)
+
+	return reversalId, nil
+}
+
+//GetChainHead returns the head of the hash chain of the user's activity
+func GetChainHead(ctx context.Context, userid string) (head hashchain.ChainHead, err error) {
+	//bound the query by its own timeout on top of the caller context
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	defer func() { err = timeoutError(ctx, err) }()
+
+	return hashchain.Head(ctx, getConnection(), userid)
+}
+
+//VerifyChain walks the hash chain of the user's activity and reports the rows breaking it
+func VerifyChain(ctx context.Context, userid string) (report hashchain.Report, err error) {
+	//bound the query by its own timeout on top of the caller context
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	defer func() { err = timeoutError(ctx, err) }()
+
+	report, err = hashchain.Verify(ctx, getConnection(), userid)
+	if err == nil && !report.Intact {
+		logger.Error(ctx, "activity hash chain is broken", "userid", userid, "breaks", len(report.Breaks))
+	}
+	return report, err
+}
+
+//function to write an activity row chained to the head of the user's chain, returns the tranid of the row. the
+//tranid is taken from the sequence and created from the start of the transaction first as both are covered by the hash
+func insertActivity(ctx context.Context, tx *sql.Tx, activity models.UserActivity) (uint64, error) {
+	head, err := hashchain.Head(ctx, tx, activity.UserId)
+	if err != nil {
+		return 0, err
+	}
+	var tranId uint64
+	var created time.Time
+	if err = tx.QueryRowContext(ctx, models.UserActivityNextIdStatement).Scan(&tranId, &created); err != nil {
+		return 0, err
+	}
+	link := hashchain.Link{
+		UserId:           activity.UserId,
+		TranId:           tranId,
+		IsCredit:         activity.IsCredit,
+		Amount:           activity.Amount,
+		UserCreditId:     activity.UserCreditId,
+		ReversalOfTranId: activity.ReversalOfTranId,
+		Event:            activity.Event,
+		Merchant:         activity.Merchant,
+		Category:         activity.Category,
+		OverdraftId:      activity.OverdraftId,
+		Created:          created,
+		PrevHash:         head.Hash,
+	}
+	//event rows and overdrawn debits are not tied to a credit
+	userCreditId := sql.NullInt64{Int64: int64(activity.UserCreditId), Valid: activity.UserCreditId != 0}
+	reversalOf := sql.NullInt64{Int64: int64(activity.ReversalOfTranId), Valid: activity.ReversalOfTranId != 0}
+	overdraftId := sql.NullInt64{Int64: int64(activity.OverdraftId), Valid: activity.OverdraftId != 0}
+	debitId := sql.NullInt64{Int64: int64(activity.DebitId), Valid: activity.DebitId != 0}
+	_, err = tx.ExecContext(ctx, models.UserActivityInsertStatement, tranId, activity.UserId, activity.IsCredit, activity.Amount,
+		userCreditId, reversalOf, nullString(activity.Event), nullString(activity.Merchant), nullString(activity.Category),
+		overdraftId, debitId, created, link.PrevHash, link.Hash())
+	return tranId, err
+}
+
+//function to store an empty string as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: len(s) > 0}
+}
+
+//function to list every credit of the user, expired and exhausted ones included
+func getUserCredits(ctx context.Context, db *sql.DB, userid string) ([]models.UserCredit, error) {
+	rows, err := db.QueryContext(ctx, models.UserCreditListStatement, userid)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to execute the query. %w", err)
+	}
+	defer rows.Close()
+
+	credits := make([]models.UserCredit, 0)
+	for rows.Next() {
+		var credit models.UserCredit
+		if err := rows.Scan(&credit.UserId, &credit.UserCreditId, &credit.Updated, &credit.Created, &credit.Amount,
+			&credit.OriginalAmount, &credit.ConsumedAmount, &credit.TransactionType, &credit.Priority, &credit.Expiry, &credit.IsExpired,
+			pq.Array(&credit.AllowedCategories)); err != nil {
+			return nil, fmt.Errorf("Unable to scan the row. %w", err)
+		}
+		credits = append(credits, credit)
+	}
+	return credits, rows.Err()
+}
+
+//function to build the balance breakdown of the credits at now. the amount of credits that have not expired is
+//available, the same credits a debit may consume, the amount left on the others is expired
+func newUserBalance(userid string, credits []models.UserCredit, now time.Time) models.UserBalance {
+	balance := models.UserBalance{UserId: userid, Credits: credits}
+	for _, credit := range credits {
+		balance.Original += credit.OriginalAmount
+		balance.Consumed += credit.ConsumedAmount
+		if t, err := time.Parse(time.RFC3339, credit.Expiry); credit.IsExpired || err != nil || !t.After(now) {
+			balance.Expired += credit.Amount
+			continue
+		}
+		balance.Available += credit.Amount
+	}
+	return balance
+}
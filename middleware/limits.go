@@ -0,0 +1,227 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/a0rana/UserAccountService/audit"
+	"github.com/a0rana/UserAccountService/database"
+	"github.com/a0rana/UserAccountService/logger"
+	"github.com/a0rana/UserAccountService/spending"
+)
+
+//spending limits of the users without an override, set by Init
+var spendingLimits spending.Limits
+
+//request to set the spending limits of a user or a segment, limits left out are inherited and an override without
+//any limit is removed
+type requestLimits struct {
+	spending.Override
+	Reason string `json:"reason"`
+}
+
+//request to put a user in a segment, an empty segment takes the user out of its segment
+type requestSegment struct {
+	UserId  string `json:"userid"`
+	Segment string `json:"segment"`
+	Reason  string `json:"reason"`
+}
+
+//segmentState is the segment of a user recorded in the audit log
+type segmentState struct {
+	Segment string `json:"segment"`
+}
+
+//response of the spending limits endpoints
+type responseLimits struct {
+	Success  bool               `json:"success"`
+	Code     string             `json:"code,omitempty"`
+	Message  string             `json:"message,omitempty"`
+	Settings *spending.Settings `json:"settings,omitempty"`
+	Usage    *spending.Usage    `json:"usage,omitempty"`
+}
+
+//GetUserSpendingLimits shows the spending limits applying to the user given by the userid query parameter, where
+//they come from and what the user debited over their windows
+func GetUserSpendingLimits(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userid := r.URL.Query().Get("userid")
+	if len(userid) == 0 {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Unable to process the spending limits request. userid is required")
+		return
+	}
+
+	settings, usage, err := GetSpendingLimits(r.Context(), userid)
+	if err != nil {
+		status, code := errorStatus(err)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(responseLimits{Success: false, Code: code,
+			Message: fmt.Sprint("Unable to get the spending limits. ", err.Error())})
+		return
+	}
+	json.NewEncoder(w).Encode(responseLimits{Success: true, Settings: &settings, Usage: &usage})
+}
+
+//SetUserSpendingLimits replaces the spending limits override of a user or a segment
+func SetUserSpendingLimits(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req requestLimits
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err == nil {
+		err = req.validate()
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprint("Unable to process the spending limits request. ", err.Error()))
+		return
+	}
+
+	if err = SetSpendingLimits(r.Context(), req.Override, req.Reason); err != nil {
+		status, code := errorStatus(err)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(responseLimits{Success: false, Code: code,
+			Message: fmt.Sprint("Unable to set the spending limits. ", err.Error())})
+		return
+	}
+	json.NewEncoder(w).Encode(responseLimits{Success: true, Message: "Spending limits have been set successfully"})
+}
+
+//UpdateUserSegment puts a user in the segment whose spending limits apply to it
+func UpdateUserSegment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req requestSegment
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err == nil {
+		err = req.validate()
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprint("Unable to process the segment request. ", err.Error()))
+		return
+	}
+
+	if err = SetUserSegment(r.Context(), req.UserId, req.Segment, req.Reason); err != nil {
+		status, code := errorStatus(err)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(responseLimits{Success: false, Code: code,
+			Message: fmt.Sprint("Unable to set the user's segment. ", err.Error())})
+		return
+	}
+	json.NewEncoder(w).Encode(responseLimits{Success: true, Message: "User segment has been set successfully"})
+}
+
+//function to check the fields of a spending limits request, the reason is required for the audit log
+func (req requestLimits) validate() error {
+	if err := req.Validate(); err != nil {
+		return err
+	}
+	if len(req.Reason) == 0 {
+		return errors.New("reason is required")
+	}
+	return nil
+}
+
+//function to check the fields of a segment request, the reason is required for the audit log
+func (req requestSegment) validate() error {
+	switch {
+	case len(req.UserId) == 0:
+		return errors.New("userid is required")
+	case len(req.Reason) == 0:
+		return errors.New("reason is required")
+	}
+	return nil
+}
+
+//GetSpendingLimits returns the spending limits applying to the user along with its usage of them
+func GetSpendingLimits(ctx context.Context, userid string) (settings spending.Settings, usage spending.Usage, err error) {
+	//bound the queries by their own timeout on top of the caller context
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	defer func() { err = timeoutError(ctx, err) }()
+
+	db := getConnection()
+	if settings, err = spending.Effective(ctx, db, userid, spendingLimits); err != nil {
+		return settings, usage, err
+	}
+	usage, err = spending.GetUsage(ctx, db, userid)
+	return settings, usage, err
+}
+
+//SetSpendingLimits replaces the spending limits override of a user or a segment, the previous and the new override
+//are recorded in the audit log along with reason
+func SetSpendingLimits(ctx context.Context, o spending.Override, reason string) (err error) {
+	if err = (requestLimits{Override: o, Reason: reason}).validate(); err != nil {
+		return err
+	}
+
+	//bound the whole transaction by its own timeout on top of the caller context
+	ctx, cancel := context.WithTimeout(ctx, txTimeout)
+	defer cancel()
+	defer func() { err = timeoutError(ctx, err) }()
+
+	tx, err := getConnection().BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	before, err := spending.Set(ctx, tx, o)
+	if err != nil {
+		return rollback(tx, err)
+	}
+	//nil states are recorded as NULL, not as a json null
+	var previous, after interface{}
+	if before != nil {
+		previous = before
+	}
+	if !o.Empty() {
+		after = o
+	}
+	_, err = audit.Record(ctx, tx, audit.Event{Action: audit.ActionLimitsSet, UserId: o.UserId, Entity: audit.EntityLimits,
+		Reason: reason, Before: previous, After: after})
+	if err != nil {
+		return rollback(tx, err)
+	}
+	if err = database.Commit(ctx, tx); err != nil {
+		return err
+	}
+
+	logger.Info(ctx, "spending limits set", "userid", o.UserId, "segment", o.Segment, "removed", o.Empty())
+	return nil
+}
+
+//SetUserSegment puts the user in segment(takes it out of its segment when empty), recorded in the audit log along
+//with reason
+func SetUserSegment(ctx context.Context, userid string, segment string, reason string) (err error) {
+	if err = (requestSegment{UserId: userid, Segment: segment, Reason: reason}).validate(); err != nil {
+		return err
+	}
+
+	//bound the whole transaction by its own timeout on top of the caller context
+	ctx, cancel := context.WithTimeout(ctx, txTimeout)
+	defer cancel()
+	defer func() { err = timeoutError(ctx, err) }()
+
+	tx, err := getConnection().BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	previous, err := spending.SetSegment(ctx, tx, userid, segment)
+	if err != nil {
+		return rollback(tx, err)
+	}
+	_, err = audit.Record(ctx, tx, audit.Event{Action: audit.ActionSegmentSet, UserId: userid, Entity: audit.EntityUser,
+		Reason: reason, Before: segmentState{Segment: previous}, After: segmentState{Segment: segment}})
+	if err != nil {
+		return rollback(tx, err)
+	}
+	if err = database.Commit(ctx, tx); err != nil {
+		return err
+	}
+
+	logger.Info(ctx, "user segment set", "userid", userid, "segment", segment, "previous", previous)
+	return nil
+}
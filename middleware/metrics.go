@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/a0rana/UserAccountService/metrics"
+	"github.com/a0rana/UserAccountService/spending"
+	"github.com/gorilla/mux"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("http_requests_total",
+		"Number of HTTP requests by route, method and status code.", "route", "method", "code")
+	httpDuration = metrics.NewHistogramVec("http_request_duration_seconds",
+		"Latency of HTTP requests by route and method.", metrics.DefaultBuckets, "route", "method")
+	debits = metrics.NewCounterVec("debits_total",
+		"Number of debit requests by outcome.", "outcome")
+	debitDuration = metrics.NewHistogramVec("debit_duration_seconds",
+		"Latency of processing a debit by outcome.", metrics.DefaultBuckets, "outcome")
+	debitCreditsConsumed = metrics.NewHistogramVec("debit_credits_consumed",
+		"Number of credits consumed by a successful debit.", []float64{1, 2, 3, 5, 10, 20, 50})
+)
+
+func init() {
+	metrics.NewCounterFunc("cache_hits_total", "Number of transaction history reads served from the cache.",
+		func() float64 { return float64(ResponseCacheStats().Hits) })
+	metrics.NewCounterFunc("cache_misses_total", "Number of transaction history reads served from the database.",
+		func() float64 { return float64(ResponseCacheStats().Misses) })
+	metrics.NewCounterFunc("cache_corrupt_entries_total", "Number of cache entries evicted because they could not be decoded.",
+		func() float64 { return float64(ResponseCacheStats().Corrupt) })
+}
+
+//statusRecorder keeps the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+//Metrics counts requests and measures their latency per route template
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Inc()
+	})
+}
+
+//function to record the outcome and latency of a debit, the outcome is the class of the error
+func observeDebit(err error, elapsed time.Duration) {
+	outcome := debitOutcome(err)
+	debits.WithLabelValues(outcome).Inc()
+	debitDuration.WithLabelValues(outcome).Observe(elapsed.Seconds())
+}
+
+//function to classify a debit error, unexpected errors(database, decoding) are reported as "error"
+func debitOutcome(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, errInvalidDebitAmount):
+		return "invalid_amount"
+	case errors.Is(err, errNoCredits):
+		return "no_credits"
+	case errors.Is(err, errCreditsExpired):
+		return "credits_expired"
+	case errors.Is(err, errInsufficientCredit):
+		return "insufficient_credit"
+	case errors.Is(err, errIneligibleCredit):
+		return "ineligible_credit"
+	case errors.Is(err, errInvalidCategory):
+		return "invalid_category"
+	case errors.Is(err, spending.ErrLimitExceeded):
+		return "spending_limit_exceeded"
+	case errors.Is(err, errAccountFrozen):
+		return "account_frozen"
+	case errors.Is(err, errAccountClosed):
+		return "account_closed"
+	}
+	return "error"
+}
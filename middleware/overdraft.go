@@ -0,0 +1,214 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/a0rana/UserAccountService/audit"
+	"github.com/a0rana/UserAccountService/database"
+	"github.com/a0rana/UserAccountService/logger"
+	"github.com/a0rana/UserAccountService/models"
+)
+
+//request to set how far below zero a user may go, a limit of 0 removes the overdraft facility
+type requestOverdraft struct {
+	UserId string  `json:"userid"`
+	Limit  float64 `json:"limit"`
+	Reason string  `json:"reason"`
+}
+
+//overdraftLimitState is the overdraft limit of a user recorded in the audit log
+type overdraftLimitState struct {
+	Limit float64 `json:"limit"`
+}
+
+//response of the overdraft endpoint
+type responseOverdraft struct {
+	Success bool   `json:"success"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+//querier runs a single row query, both *sql.DB and *sql.Tx implement it
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+//UpdateOverdraftLimit sets the overdraft limit agreed with a user, debits may go below zero up to it
+func UpdateOverdraftLimit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req requestOverdraft
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err == nil {
+		err = req.validate()
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprint("Unable to process the overdraft request. ", err.Error()))
+		return
+	}
+
+	if err = SetOverdraftLimit(r.Context(), req.UserId, req.Limit, req.Reason); err != nil {
+		status, code := errorStatus(err)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(responseOverdraft{Success: false, Code: code,
+			Message: fmt.Sprint("Unable to set the overdraft limit. ", err.Error())})
+		return
+	}
+
+	//the limit is part of the balance of the user
+	InvalidateCache(r.Context(), req.UserId)
+
+	json.NewEncoder(w).Encode(responseOverdraft{Success: true, Message: "Overdraft limit has been set successfully"})
+}
+
+//function to check the fields of an overdraft request
+func (req requestOverdraft) validate() error {
+	switch {
+	case len(req.UserId) == 0:
+		return errors.New("userid is required")
+	case req.Limit < 0 || math.IsNaN(req.Limit) || math.IsInf(req.Limit, 0):
+		return errors.New("limit must be zero or greater")
+	case len(req.Reason) == 0:
+		return errors.New("reason is required")
+	}
+	return nil
+}
+
+//SetOverdraftLimit sets how far below zero the user may go, recorded in the audit log along with reason. lowering
+//the limit below what the user already owes only keeps it from overdrawing further
+func SetOverdraftLimit(ctx context.Context, userid string, limit float64, reason string) (err error) {
+	if err = (requestOverdraft{UserId: userid, Limit: limit, Reason: reason}).validate(); err != nil {
+		return err
+	}
+	limit = roundCents(limit)
+
+	//bound the whole transaction by its own timeout on top of the caller context
+	ctx, cancel := context.WithTimeout(ctx, txTimeout)
+	defer cancel()
+	defer func() { err = timeoutError(ctx, err) }()
+
+	tx, err := getConnection().BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+
+	var previous float64
+	err = tx.QueryRowContext(ctx, models.UserOverdraftLockStatement, userid).Scan(&previous)
+	switch {
+	case err == sql.ErrNoRows:
+		return rollback(tx, errUserNotFound)
+	case err != nil:
+		return rollback(tx, err)
+	}
+
+	if _, err = tx.ExecContext(ctx, models.UserOverdraftUpdateStatement, limit, userid); err != nil {
+		return rollback(tx, err)
+	}
+
+	_, err = audit.Record(ctx, tx, audit.Event{Action: audit.ActionOverdraftSet, UserId: userid, Entity: audit.EntityUser,
+		Reason: reason, Before: overdraftLimitState{Limit: previous}, After: overdraftLimitState{Limit: limit}})
+	if err != nil {
+		return rollback(tx, err)
+	}
+
+	if err = database.Commit(ctx, tx); err != nil {
+		return err
+	}
+
+	logger.Info(ctx, "overdraft limit set", "userid", userid, "limit", limit, "previous", previous)
+	return nil
+}
+
+//function to get the overdraft limit of the user and the amount it owes on its overdrafts, an unknown user has
+//neither
+func getOverdraft(ctx context.Context, q querier, userid string) (limit float64, overdrawn float64, err error) {
+	err = q.QueryRowContext(ctx, models.UserOverdraftSelectStatement, userid).Scan(&limit, &overdrawn)
+	if err == sql.ErrNoRows {
+		return 0, 0, nil
+	}
+	return limit, overdrawn, err
+}
+
+//function to get how much more the user may overdraw, 0 without an overdraft facility
+func availableOverdraft(ctx context.Context, q querier, userid string) (float64, error) {
+	limit, overdrawn, err := getOverdraft(ctx, q, userid)
+	if err != nil {
+		return 0, err
+	}
+	return math.Max(0, roundCents(limit-overdrawn)), nil
+}
+
+//overdraft is an outstanding overdraft of a user
+type overdraft struct {
+	id          uint64
+	outstanding float64
+}
+
+//function to repay the outstanding overdrafts of the user within tx from the credit just granted, oldest overdraft
+//first. every repayment is a debit row with both the overdraft and the credit, and the credit is consumed by the
+//amount repaid. there is no journal entry, the wallet already went below zero when the overdraft was drawn. the
+//repayment is made as the credit is granted, while it is not expired, so its expiry only applies to what is left of
+//it. returns the amount repaid and the tranids of the repayments
+func repayOverdrafts(ctx context.Context, tx *sql.Tx, userid string, userCreditId uint64, amount float64) (float64, []uint64, error) {
+	rows, err := tx.QueryContext(ctx, models.OverdraftOutstandingStatement, userid)
+	if err != nil {
+		return 0, nil, err
+	}
+	var overdrafts []overdraft
+	for rows.Next() {
+		var o overdraft
+		if err = rows.Scan(&o.id, &o.outstanding); err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		overdrafts = append(overdrafts, o)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	var repaid float64
+	var tranIds []uint64
+	for i, payment := range allocateRepayments(amount, overdrafts) {
+		if _, err = tx.ExecContext(ctx, models.OverdraftRepayStatement, payment, overdrafts[i].id); err != nil {
+			return 0, nil, err
+		}
+		tranId, err := insertActivity(ctx, tx, models.UserActivity{UserId: userid, Amount: payment, UserCreditId: userCreditId,
+			OverdraftId: overdrafts[i].id})
+		if err != nil {
+			return 0, nil, err
+		}
+		repaid = roundCents(repaid + payment)
+		tranIds = append(tranIds, tranId)
+	}
+
+	if repaid > 0 {
+		if _, err = tx.ExecContext(ctx, models.UserCreditUpdateStatement, repaid, userid, userCreditId); err != nil {
+			return 0, nil, err
+		}
+	}
+	return repaid, tranIds, nil
+}
+
+//function to split amount over the outstanding overdrafts in their order, returns what each of the first ones is
+//repaid. an overdraft is repaid in full before the next one, the last one repaid may be repaid in part
+func allocateRepayments(amount float64, overdrafts []overdraft) []float64 {
+	var payments []float64
+	var repaid float64
+	for _, o := range overdrafts {
+		payment := math.Min(roundCents(amount-repaid), o.outstanding)
+		if payment <= 0 {
+			break
+		}
+		payments = append(payments, payment)
+		repaid = roundCents(repaid + payment)
+	}
+	return payments
+}
@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/a0rana/UserAccountService/auth"
+	"github.com/a0rana/UserAccountService/logger"
+	"github.com/a0rana/UserAccountService/metrics"
+	"github.com/a0rana/UserAccountService/ratelimit"
+	"github.com/gorilla/mux"
+)
+
+//error code of the responses to rate limited requests
+const CodeRateLimited = "rate_limited"
+
+//returned when the requests on a user go beyond the rate limit of the route
+var errRateLimited = errors.New("too many requests, please retry later")
+
+var (
+	//rate limiting backend and limits per route set by Init, nil disables rate limiting
+	limiter      ratelimit.Limiter
+	addressLimit ratelimit.Rate
+	clientLimits map[string]ratelimit.Rate
+	userLimits   map[string]ratelimit.Rate
+
+	rateLimited = metrics.NewCounterVec("rate_limited_requests_total",
+		"Number of requests rejected by the rate limits by route and limit.", "route", "limit")
+)
+
+//RateLimitAddress rejects the requests of a remote address going beyond the address rate limit with a 429, it runs
+//before Authenticate so that requests with wrong credentials, e.g. guessing api keys, are limited as well
+func RateLimitAddress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if addressLimit.Limit > 0 {
+			route := routeTemplate(r)
+			if wait, limited := takeToken(r, "address", route, "address:ip:"+clientIP(r), addressLimit); limited {
+				writeRetryAfter(w, wait)
+				writeError(w, http.StatusTooManyRequests, CodeRateLimited, "Too many requests from this address, please retry later.")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//RateLimit rejects the requests of an api client going beyond the client rate limit of the route with a 429, it
+//runs after Authenticate so that clients are told apart by their credentials rather than by their address
+func RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		if wait, limited := overLimit(r, clientLimits, "client", route, clientKey(r)); limited {
+			writeRetryAfter(w, wait)
+			writeError(w, http.StatusTooManyRequests, CodeRateLimited, fmt.Sprint("Too many requests from this client on ", route, ", please retry later."))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//function to apply the user rate limit of the route to the requests on userid, Retry-After is set on w when the
+//request is rejected with errRateLimited
+func limitUser(w http.ResponseWriter, r *http.Request, userid string) error {
+	if wait, limited := overLimit(r, userLimits, "user", routeTemplate(r), userid); limited {
+		writeRetryAfter(w, wait)
+		return errRateLimited
+	}
+	return nil
+}
+
+//function to check that the request may act on userid: its credentials must allow it and the requests on the user
+//must stay within the user rate limit of the route
+func admitUser(w http.ResponseWriter, r *http.Request, userid string) error {
+	if err := authorizeUser(r.Context(), userid); err != nil {
+		return err
+	}
+	return limitUser(w, r, userid)
+}
+
+//function to take a token from the bucket of key for the given limit of the route, a route without a limit and a
+//failing backend let the request through
+func overLimit(r *http.Request, limits map[string]ratelimit.Rate, limit string, route string, key string) (time.Duration, bool) {
+	rate, ok := limits[route]
+	if !ok {
+		return 0, false
+	}
+	return takeToken(r, limit, route, limit+":"+route+":"+key, rate)
+}
+
+//function to take a token from bucket at rate, limit and route label the rejections in the logs and metrics. no
+//limiter and a failing backend let the request through
+func takeToken(r *http.Request, limit string, route string, bucket string, rate ratelimit.Rate) (time.Duration, bool) {
+	if limiter == nil {
+		return 0, false
+	}
+	allowed, wait, err := limiter.Allow(r.Context(), bucket, rate)
+	if err != nil {
+		logger.Warn(r.Context(), "unable to check the rate limit, letting the request through", "limit", limit, "error", err)
+		return 0, false
+	}
+	if !allowed {
+		logger.Warn(r.Context(), "request rate limited", "route", route, "limit", limit, "rate", rate.String())
+		rateLimited.WithLabelValues(route, limit).Inc()
+	}
+	return wait, !allowed
+}
+
+//function to identify the api client of the request by its credentials, or by its address when it has none
+func clientKey(r *http.Request) string {
+	if principal, ok := auth.FromContext(r.Context()); ok {
+		return principal.Method + ":" + principal.Subject
+	}
+	return "ip:" + clientIP(r)
+}
+
+//function to tell the client how many whole seconds to wait before retrying
+func writeRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+//function to get the path template of the route serving the request, the path itself outside of the router
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+
+	"github.com/a0rana/UserAccountService/audit"
+	"github.com/a0rana/UserAccountService/logger"
+)
+
+//header carrying the request correlation id, accepted from the caller and always sent back
+const RequestIDHeader = "X-Request-ID"
+
+//RequestID makes sure every request has a correlation id and puts it in the request context, so that every entry
+//logged while processing the request carries it. the client address is put there too for the audit log
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := logger.WithRequestID(r.Context(), id)
+		next.ServeHTTP(w, r.WithContext(audit.WithClientIP(ctx, clientIP(r))))
+	})
+}
+
+//function to accept caller provided ids only when they are short and printable, so they cannot forge log lines
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+//function to generate a random 128 bit request id
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+//function to get the address of the client without its port. X-Forwarded-For is not trusted as any client could set it
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/a0rana/UserAccountService/migrations"
+	"strconv"
+)
+
+//usage of the migrate subcommand
+const migrateUsage = "usage: migrate up | down [steps] | status"
+
+//function to run the migrate subcommand: "up" applies pending migrations, "down" reverts the last one(or the
+//given number of steps) and "status" prints the schema version of the database and of this build
+func migrate(ctx context.Context, db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(ctx, db)
+		for _, m := range applied {
+			fmt.Printf("applied %d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return errors.New(migrateUsage)
+			}
+		}
+		reverted, err := migrations.Down(ctx, db, steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		version, err := migrations.Version(ctx, db)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("database schema version: %d, required by this build: %d\n", version, migrations.Latest())
+		return nil
+	}
+	return errors.New(migrateUsage)
+}
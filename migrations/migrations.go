@@ -0,0 +1,199 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+//sql files are named <version>_<name>.up.sql and <version>_<name>.down.sql, versions start at 1 and have no gaps
+//go:embed sql/*.sql
+var files embed.FS
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+//table recording the applied migrations, its highest version is the schema version
+const versionTable = `CREATE TABLE IF NOT EXISTS schema_migrations
+(
+    version INTEGER PRIMARY KEY,
+    name    VARCHAR(100) NOT NULL,
+    applied TIMESTAMP WITHOUT TIME ZONE DEFAULT (NOW() AT TIME ZONE 'UTC')
+)`
+
+//key of the advisory lock held while migrating, so replicas starting together do not migrate concurrently
+const lockKey = 7507
+
+//Migration is a single schema change with the statements applying and reverting it
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+//All returns the embedded migrations ordered by version
+func All() ([]Migration, error) {
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migrations: unexpected file name %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := files.ReadFile(path.Join("sql", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	all := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		all = append(all, *m)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	for i, m := range all {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migrations: expected version %d, found %d", i+1, m.Version)
+		}
+		if len(m.Up) == 0 || len(m.Down) == 0 {
+			return nil, fmt.Errorf("migrations: version %d needs both an up and a down file", m.Version)
+		}
+	}
+	return all, nil
+}
+
+//Latest returns the schema version expected by this build
+func Latest() int {
+	all, err := All()
+	if err != nil {
+		panic(err)
+	}
+	return len(all)
+}
+
+//Version returns the current schema version of the database, 0 when no migration has been applied
+func Version(ctx context.Context, db *sql.DB) (int, error) {
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil || !exists {
+		return 0, err
+	}
+	var version int
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+//Check returns an error unless the database schema is exactly at the version expected by this build
+func Check(ctx context.Context, db *sql.DB) error {
+	version, err := Version(ctx, db)
+	if err != nil {
+		return err
+	}
+	if latest := Latest(); version != latest {
+		return fmt.Errorf("schema version is %d but this build requires %d, run the migrate command", version, latest)
+	}
+	return nil
+}
+
+//Up applies every pending migration, each in its own transaction, and returns the ones applied
+func Up(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	err = locked(ctx, db, func(conn *sql.Conn, version int) error {
+		if version > len(all) {
+			return fmt.Errorf("migrations: schema version %d is newer than this build(%d)", version, len(all))
+		}
+		for _, m := range all[version:] {
+			if err := apply(ctx, conn, m.Up, `INSERT INTO schema_migrations(version, name) VALUES ($1, $2)`, m.Version, m.Name); err != nil {
+				return fmt.Errorf("migrations: applying %d_%s: %w", m.Version, m.Name, err)
+			}
+			applied = append(applied, m)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+//Down reverts the last steps migrations, newest first, and returns the ones reverted
+func Down(ctx context.Context, db *sql.DB, steps int) ([]Migration, error) {
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+	var reverted []Migration
+	err = locked(ctx, db, func(conn *sql.Conn, version int) error {
+		if version > len(all) {
+			return fmt.Errorf("migrations: schema version %d is newer than this build(%d)", version, len(all))
+		}
+		for i := 0; i < steps && version-i > 0; i++ {
+			m := all[version-i-1]
+			if err := apply(ctx, conn, m.Down, `DELETE FROM schema_migrations WHERE version=$1`, m.Version); err != nil {
+				return fmt.Errorf("migrations: reverting %d_%s: %w", m.Version, m.Name, err)
+			}
+			reverted = append(reverted, m)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+//function to run fn on a single connection holding the migration lock, with the version table created
+func locked(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn, version int) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	if _, err = conn.ExecContext(ctx, versionTable); err != nil {
+		return err
+	}
+	var version int
+	if err = conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	return fn(conn, version)
+}
+
+//function to run the statements of a migration and record it in the same transaction
+func apply(ctx context.Context, conn *sql.Conn, statements string, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, statements); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
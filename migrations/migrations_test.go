@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+)
+
+//test case to verify the embedded migrations are complete, ordered and create the tables used by the service
+func TestAll(t *testing.T) {
+	all, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) == 0 || Latest() != len(all) {
+		t.Fatalf("Expected latest version to be the number of migrations. Got %d for %d", Latest(), len(all))
+	}
+	for i, m := range all {
+		if m.Version != i+1 || len(m.Name) == 0 {
+			t.Errorf("Expected migration %d to be named and ordered. Got %+v", i+1, m)
+		}
+	}
+	for _, table := range []string{"tbl_Users", "tbl_UserCredits", "tbl_Activity"} {
+		if !strings.Contains(all[0].Up, "CREATE TABLE IF NOT EXISTS "+table) || !strings.Contains(all[0].Down, "DROP TABLE IF EXISTS "+table) {
+			t.Errorf("Expected first migration to create and drop %s", table)
+		}
+	}
+	if strings.Index(all[0].Up, "tbl_UserCredits") > strings.Index(all[0].Up, "tbl_Activity") {
+		t.Error("Expected tbl_UserCredits to be created before tbl_Activity which references it")
+	}
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS tbl_Activity;
+DROP TABLE IF EXISTS tbl_UserCredits;
+DROP TABLE IF EXISTS tbl_Users;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_activity_reversaloftranid;
+ALTER TABLE tbl_Activity DROP COLUMN IF EXISTS reversaloftranid;
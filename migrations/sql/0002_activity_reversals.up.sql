@@ -0,0 +1,3 @@
+-- a reversal is a credit row pointing at the debit row it gives back, a debit row can be reversed only once
+ALTER TABLE tbl_Activity ADD COLUMN reversaloftranid BIGINT;
+CREATE UNIQUE INDEX idx_activity_reversaloftranid ON tbl_Activity (reversaloftranid) WHERE reversaloftranid IS NOT NULL;
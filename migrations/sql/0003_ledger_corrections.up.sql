@@ -0,0 +1,13 @@
+-- corrections written by the reconciliation when a credit's amount does not match its activity, a row records the
+-- amount before and after the correction along with who made it and why
+CREATE TABLE tbl_LedgerCorrections
+(
+    correctionid    BIGSERIAL PRIMARY KEY,
+    created         TIMESTAMP WITHOUT TIME ZONE DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    userid          UUID REFERENCES tbl_Users (userid),
+    usercreditid    BIGINT REFERENCES tbl_UserCredits (usercreditid),
+    previousamount  NUMERIC(10, 2) NOT NULL,
+    correctedamount NUMERIC(10, 2) NOT NULL,
+    actor           VARCHAR(100) NOT NULL,
+    reason          TEXT NOT NULL
+);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS tbl_JournalLines;
+DROP FUNCTION IF EXISTS fn_journal_entry_balanced();
+DROP TABLE IF EXISTS tbl_JournalEntries;
+DROP TABLE IF EXISTS tbl_Accounts;
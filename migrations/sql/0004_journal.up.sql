@@ -0,0 +1,90 @@
+-- double-entry journal underneath credits and debits. every movement of money is an entry made of lines debiting and
+-- crediting accounts, the lines of an entry always balance. accounts are a wallet per user plus the system accounts
+-- promotional_liability(source of granted credits), expired_forfeit(credits lost to expiry) and revenue(debits)
+CREATE TABLE tbl_Accounts
+(
+    accountid BIGSERIAL PRIMARY KEY,
+    code      VARCHAR(100) NOT NULL UNIQUE,
+    userid    UUID REFERENCES tbl_Users (userid),
+    created   TIMESTAMP WITHOUT TIME ZONE DEFAULT (NOW() AT TIME ZONE 'UTC')
+);
+
+CREATE TABLE tbl_JournalEntries
+(
+    entryid      BIGSERIAL PRIMARY KEY,
+    created      TIMESTAMP WITHOUT TIME ZONE DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    kind         VARCHAR(20) NOT NULL,
+    userid       UUID REFERENCES tbl_Users (userid),
+    tranid       BIGINT,
+    usercreditid BIGINT REFERENCES tbl_UserCredits (usercreditid)
+);
+CREATE INDEX idx_journalentries_userid ON tbl_JournalEntries (userid, created);
+
+CREATE TABLE tbl_JournalLines
+(
+    lineid    BIGSERIAL PRIMARY KEY,
+    entryid   BIGINT NOT NULL REFERENCES tbl_JournalEntries (entryid),
+    accountid BIGINT NOT NULL REFERENCES tbl_Accounts (accountid),
+    debit     NUMERIC(12, 2) NOT NULL DEFAULT 0,
+    credit    NUMERIC(12, 2) NOT NULL DEFAULT 0,
+    CHECK (debit >= 0 AND credit >= 0 AND (debit = 0) <> (credit = 0))
+);
+CREATE INDEX idx_journallines_entryid ON tbl_JournalLines (entryid);
+CREATE INDEX idx_journallines_accountid ON tbl_JournalLines (accountid);
+
+-- checked when the transaction commits, once every line of the entry has been written
+CREATE FUNCTION fn_journal_entry_balanced() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF (SELECT SUM(debit) - SUM(credit) FROM tbl_JournalLines WHERE entryid = NEW.entryid) <> 0 THEN
+        RAISE EXCEPTION 'journal entry % is not balanced', NEW.entryid;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER trg_journal_entry_balanced
+    AFTER INSERT OR UPDATE ON tbl_JournalLines
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION fn_journal_entry_balanced();
+
+INSERT INTO tbl_Accounts (code) VALUES ('promotional_liability'), ('expired_forfeit'), ('revenue');
+INSERT INTO tbl_Accounts (code, userid) SELECT 'wallet:' || userid, userid FROM tbl_Users;
+
+-- post the existing activity and the credits already expired, so that the journal covers the whole history.
+-- an entry debits the first account and credits the second, a negative amount swaps the sides
+INSERT INTO tbl_JournalEntries (created, kind, userid, tranid, usercreditid)
+SELECT created,
+       CASE WHEN reversaloftranid IS NOT NULL THEN 'reversal' WHEN iscredit THEN 'credit' ELSE 'debit' END,
+       userid, tranid, usercreditid
+FROM tbl_Activity
+WHERE amount <> 0
+ORDER BY tranid;
+
+INSERT INTO tbl_JournalEntries (created, kind, userid, usercreditid)
+SELECT updated, 'expiry', userid, usercreditid
+FROM tbl_UserCredits
+WHERE isexpired = true AND amount <> 0
+ORDER BY usercreditid;
+
+WITH postings AS (
+    SELECT e.entryid,
+           COALESCE(a.amount, c.amount) AS amount,
+           CASE e.kind
+               WHEN 'credit' THEN 'promotional_liability'
+               WHEN 'reversal' THEN 'revenue'
+               ELSE 'wallet:' || e.userid END AS debited,
+           CASE e.kind
+               WHEN 'debit' THEN 'revenue'
+               WHEN 'expiry' THEN 'expired_forfeit'
+               ELSE 'wallet:' || e.userid END AS credited
+    FROM tbl_JournalEntries e
+             LEFT JOIN tbl_Activity a ON a.tranid = e.tranid
+             LEFT JOIN tbl_UserCredits c ON c.usercreditid = e.usercreditid AND e.kind = 'expiry'
+)
+INSERT INTO tbl_JournalLines (entryid, accountid, debit, credit)
+SELECT p.entryid, acc.accountid, GREATEST(p.amount, 0), GREATEST(-p.amount, 0)
+FROM postings p JOIN tbl_Accounts acc ON acc.code = p.debited
+UNION ALL
+SELECT p.entryid, acc.accountid, GREATEST(-p.amount, 0), GREATEST(p.amount, 0)
+FROM postings p JOIN tbl_Accounts acc ON acc.code = p.credited;
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS trg_usercredits_original_amount_immutable ON tbl_UserCredits;
+DROP FUNCTION IF EXISTS fn_usercredits_original_amount_immutable();
+ALTER TABLE tbl_UserCredits
+    DROP CONSTRAINT IF EXISTS chk_usercredits_amounts,
+    DROP COLUMN IF EXISTS consumed_amount,
+    DROP COLUMN IF EXISTS original_amount;
@@ -0,0 +1,32 @@
+-- amount is the remaining value of a credit, original_amount the value granted(never changed after the insert) and
+-- consumed_amount the value debited so far net of reversals. existing credits take their original amount from their
+-- credit activity row and are considered consumed by the difference with the remaining amount
+ALTER TABLE tbl_UserCredits
+    ADD COLUMN original_amount NUMERIC(10, 2),
+    ADD COLUMN consumed_amount NUMERIC(10, 2) NOT NULL DEFAULT 0;
+
+UPDATE tbl_UserCredits c
+SET original_amount = COALESCE((SELECT SUM(a.amount)
+                                FROM tbl_Activity a
+                                WHERE a.usercreditid = c.usercreditid
+                                  AND a.iscredit
+                                  AND a.reversaloftranid IS NULL), c.amount);
+UPDATE tbl_UserCredits SET consumed_amount = original_amount - amount;
+
+ALTER TABLE tbl_UserCredits
+    ALTER COLUMN original_amount SET NOT NULL,
+    ADD CONSTRAINT chk_usercredits_amounts CHECK (amount = original_amount - consumed_amount);
+
+CREATE FUNCTION fn_usercredits_original_amount_immutable() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF NEW.original_amount <> OLD.original_amount THEN
+        RAISE EXCEPTION 'original_amount of user credit % cannot be changed', OLD.usercreditid;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_usercredits_original_amount_immutable
+    BEFORE UPDATE OF original_amount ON tbl_UserCredits
+    FOR EACH ROW EXECUTE FUNCTION fn_usercredits_original_amount_immutable();
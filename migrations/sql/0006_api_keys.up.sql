@@ -0,0 +1,9 @@
+-- api keys accepted by the api, only the sha256 hash of a key is stored. revoked keys are kept for auditing
+CREATE TABLE tbl_ApiKeys
+(
+    keyid   BIGSERIAL PRIMARY KEY,
+    name    VARCHAR(100) NOT NULL,
+    keyhash CHAR(64)     NOT NULL UNIQUE,
+    created TIMESTAMP WITHOUT TIME ZONE DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    revoked TIMESTAMP WITHOUT TIME ZONE
+);
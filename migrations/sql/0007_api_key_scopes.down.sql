@@ -0,0 +1,3 @@
+ALTER TABLE tbl_ApiKeys
+    DROP COLUMN IF EXISTS userid,
+    DROP COLUMN IF EXISTS scopes;
@@ -0,0 +1,7 @@
+-- scopes granted to an api key and, for user-scoped keys, the only user it may act on. keys created before scopes
+-- existed keep the access they had: reading, debiting and crediting any user
+ALTER TABLE tbl_ApiKeys
+    ADD COLUMN scopes TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN userid UUID REFERENCES tbl_Users (userid);
+
+UPDATE tbl_ApiKeys SET scopes = '{ledger:read,ledger:debit,ledger:credit}';
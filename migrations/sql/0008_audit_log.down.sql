@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS tbl_AuditLog;
+DROP FUNCTION IF EXISTS fn_auditlog_append_only();
@@ -0,0 +1,36 @@
+-- append-only log of every change to the ledger and of every admin action, written in the transaction of the change.
+-- a row records who made the change(actor, request id and client address), why(reason) and the state of what was
+-- changed before and after it. userid has no foreign key so the log outlives the rows it describes
+CREATE TABLE tbl_AuditLog
+(
+    auditid   BIGSERIAL PRIMARY KEY,
+    created   TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    action    VARCHAR(50)  NOT NULL,
+    actor     VARCHAR(200) NOT NULL,
+    requestid VARCHAR(128),
+    clientip  VARCHAR(64),
+    reason    TEXT,
+    userid    UUID,
+    entity    VARCHAR(50),
+    entityid  BIGINT,
+    before    JSONB,
+    after     JSONB
+);
+
+CREATE INDEX idx_auditlog_userid ON tbl_AuditLog (userid, auditid);
+CREATE INDEX idx_auditlog_entity ON tbl_AuditLog (entity, entityid);
+
+CREATE FUNCTION fn_auditlog_append_only() RETURNS TRIGGER AS
+$$
+BEGIN
+    RAISE EXCEPTION 'tbl_AuditLog is append-only, % is not allowed', TG_OP;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_auditlog_append_only
+    BEFORE UPDATE OR DELETE ON tbl_AuditLog
+    FOR EACH ROW EXECUTE FUNCTION fn_auditlog_append_only();
+
+CREATE TRIGGER trg_auditlog_no_truncate
+    BEFORE TRUNCATE ON tbl_AuditLog
+    FOR EACH STATEMENT EXECUTE FUNCTION fn_auditlog_append_only();
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_activity_chain;
+ALTER TABLE tbl_Activity
+    DROP COLUMN IF EXISTS hash,
+    DROP COLUMN IF EXISTS prevhash;
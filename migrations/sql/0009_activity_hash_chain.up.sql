@@ -0,0 +1,42 @@
+-- every activity row carries the sha256 of its content chained to the hash of the previous row of the user(in tranid
+-- order), so that an edit made by direct SQL breaks the chain. the first row of a user is chained to 64 zeros.
+-- this is the former canonical form, migration 0015 hashes the rows again in the one of hashchain.Link.Hash
+ALTER TABLE tbl_Activity
+    ADD COLUMN prevhash CHAR(64),
+    ADD COLUMN hash     CHAR(64);
+
+DO
+$$
+    DECLARE
+        activity RECORD;
+        previous CHAR(64);
+        current  UUID;
+    BEGIN
+        FOR activity IN SELECT * FROM tbl_Activity ORDER BY userid, tranid
+            LOOP
+                IF current IS DISTINCT FROM activity.userid THEN
+                    current := activity.userid;
+                    previous := repeat('0', 64);
+                END IF;
+                UPDATE tbl_Activity
+                SET prevhash = previous,
+                    hash     = encode(sha256(convert_to(concat_ws('|', activity.userid::TEXT, activity.tranid::TEXT,
+                                                                  CASE WHEN activity.iscredit THEN 'true' ELSE 'false' END,
+                                                                  round(activity.amount * 100)::BIGINT::TEXT,
+                                                                  COALESCE(activity.usercreditid, 0)::TEXT,
+                                                                  COALESCE(activity.reversaloftranid, 0)::TEXT,
+                                                                  previous), 'UTF8')), 'hex')
+                WHERE userid = activity.userid
+                  AND tranid = activity.tranid
+                RETURNING hash INTO previous;
+            END LOOP;
+    END
+$$;
+
+ALTER TABLE tbl_Activity
+    ALTER COLUMN prevhash SET NOT NULL,
+    ALTER COLUMN hash SET NOT NULL;
+
+-- a row can only be chained to the head of the chain, two transactions appending to the chain of a user at the same
+-- time would fork it and the second one fails
+CREATE UNIQUE INDEX idx_activity_chain ON tbl_Activity (userid, prevhash);
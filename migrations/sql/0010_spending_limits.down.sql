@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_activity_userid_created;
+DROP TABLE IF EXISTS tbl_SpendingLimits;
+ALTER TABLE tbl_Users DROP COLUMN IF EXISTS segment;
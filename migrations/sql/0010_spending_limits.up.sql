@@ -0,0 +1,20 @@
+-- users can be put in a segment sharing the same spending limits, e.g. "enterprise"
+ALTER TABLE tbl_Users ADD COLUMN segment VARCHAR(50);
+
+-- spending limits overriding the configured defaults for a user or a segment, a NULL limit is inherited(by a user
+-- from its segment, by a segment from the defaults) and 0 is no limit
+CREATE TABLE tbl_SpendingLimits
+(
+    limitid          BIGSERIAL PRIMARY KEY,
+    updated          TIMESTAMP WITHOUT TIME ZONE DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    userid           UUID UNIQUE REFERENCES tbl_Users (userid),
+    segment          VARCHAR(50) UNIQUE,
+    maxsingledebit   NUMERIC(12, 2) CHECK (maxsingledebit >= 0),
+    maxdailytotal    NUMERIC(12, 2) CHECK (maxdailytotal >= 0),
+    maxweeklytotal   NUMERIC(12, 2) CHECK (maxweeklytotal >= 0),
+    maxdebitsperhour INTEGER CHECK (maxdebitsperhour >= 0),
+    CHECK ((userid IS NULL) <> (segment IS NULL))
+);
+
+-- the usage of a user is summed over its recent debits
+CREATE INDEX idx_activity_userid_created ON tbl_Activity (userid, created);
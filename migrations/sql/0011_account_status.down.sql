@@ -0,0 +1,18 @@
+-- event rows are part of the history of their users and covered by the hash chain, and a frozen or closed account
+-- would be opened again: the rollback is refused while any exists instead of removing them
+DO
+$$
+    BEGIN
+        IF EXISTS(SELECT 1 FROM tbl_Activity WHERE event IS NOT NULL) OR EXISTS(SELECT 1 FROM tbl_Users WHERE status <> 'active') THEN
+            RAISE EXCEPTION 'account status events have been recorded, 0011_account_status cannot be reverted';
+        END IF;
+    END
+$$;
+
+ALTER TABLE tbl_Activity
+    DROP CONSTRAINT IF EXISTS chk_activity_event,
+    DROP COLUMN IF EXISTS event;
+ALTER TABLE tbl_Users
+    DROP COLUMN IF EXISTS statusupdated,
+    DROP COLUMN IF EXISTS statusreason,
+    DROP COLUMN IF EXISTS status;
@@ -0,0 +1,12 @@
+-- frozen accounts cannot be debited(nor credited when ACCOUNT_FROZEN_BLOCKS_CREDITS is set), closed ones can be
+-- neither debited nor credited and cannot be reopened
+ALTER TABLE tbl_Users
+    ADD COLUMN status        VARCHAR(10) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'frozen', 'closed')),
+    ADD COLUMN statusreason  TEXT,
+    ADD COLUMN statusupdated TIMESTAMP WITHOUT TIME ZONE;
+
+-- activity rows that move no money, e.g. "status:frozen" when the account was frozen. event is covered by the hash
+-- of the row only when set, so the hashes of the existing rows stay valid
+ALTER TABLE tbl_Activity
+    ADD COLUMN event VARCHAR(20),
+    ADD CONSTRAINT chk_activity_event CHECK (event IS NULL OR (amount = 0 AND usercreditid IS NULL AND reversaloftranid IS NULL));
@@ -0,0 +1,16 @@
+-- the hash of debit rows made with a merchant or a category covers them and dropping the categories of a credit
+-- would let it be spent anywhere: the rollback is refused while any is set
+DO
+$$
+    BEGIN
+        IF EXISTS(SELECT 1 FROM tbl_Activity WHERE merchant IS NOT NULL OR category IS NOT NULL)
+            OR EXISTS(SELECT 1 FROM tbl_UserCredits WHERE allowedcategories IS NOT NULL) THEN
+            RAISE EXCEPTION 'merchants or categories have been recorded, 0012_credit_categories cannot be reverted';
+        END IF;
+    END
+$$;
+
+ALTER TABLE tbl_Activity
+    DROP COLUMN IF EXISTS category,
+    DROP COLUMN IF EXISTS merchant;
+ALTER TABLE tbl_UserCredits DROP COLUMN IF EXISTS allowedcategories;
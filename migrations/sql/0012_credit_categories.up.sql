@@ -0,0 +1,7 @@
+-- a credit may only be spent in the categories it lists, NULL allows every category. debit rows keep the merchant
+-- and the category they were made in
+ALTER TABLE tbl_UserCredits ADD COLUMN allowedcategories TEXT[];
+
+ALTER TABLE tbl_Activity
+    ADD COLUMN merchant VARCHAR(100),
+    ADD COLUMN category VARCHAR(50);
@@ -0,0 +1,16 @@
+-- the hash of overdraft and repayment rows covers the overdraft and what users owe would be forgotten: the rollback
+-- is refused while any overdraft has been drawn or agreed
+DO
+$$
+    BEGIN
+        IF EXISTS(SELECT 1 FROM tbl_Overdrafts) OR EXISTS(SELECT 1 FROM tbl_Activity WHERE overdraftid IS NOT NULL)
+            OR EXISTS(SELECT 1 FROM tbl_Users WHERE overdraftlimit > 0) THEN
+            RAISE EXCEPTION 'overdrafts have been recorded, 0013_overdrafts cannot be reverted';
+        END IF;
+    END
+$$;
+
+DROP INDEX IF EXISTS idx_activity_overdraftid;
+ALTER TABLE tbl_Activity DROP COLUMN IF EXISTS overdraftid;
+DROP TABLE IF EXISTS tbl_Overdrafts;
+ALTER TABLE tbl_Users DROP COLUMN IF EXISTS overdraftlimit;
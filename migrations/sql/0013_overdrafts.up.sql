@@ -0,0 +1,20 @@
+-- how far below zero a user may go, 0 means no overdraft facility
+ALTER TABLE tbl_Users ADD COLUMN overdraftlimit NUMERIC(12, 2) NOT NULL DEFAULT 0 CHECK (overdraftlimit >= 0);
+
+-- the part of a debit that went beyond the credits of the user, outstanding is what has not been repaid yet by the
+-- credits granted since(oldest overdraft first)
+CREATE TABLE tbl_Overdrafts
+(
+    overdraftid BIGSERIAL PRIMARY KEY,
+    userid      UUID           NOT NULL REFERENCES tbl_Users (userid),
+    created     TIMESTAMP WITHOUT TIME ZONE DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    updated     TIMESTAMP WITHOUT TIME ZONE DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    amount      NUMERIC(12, 2) NOT NULL CHECK (amount > 0),
+    outstanding NUMERIC(12, 2) NOT NULL CHECK (outstanding >= 0 AND outstanding <= amount)
+);
+CREATE INDEX idx_overdrafts_outstanding ON tbl_Overdrafts (userid, overdraftid) WHERE outstanding > 0;
+
+-- the overdrawn part of a debit is a debit row with the overdraft and no credit, the repayment of an overdraft by a
+-- credit is a debit row with both the overdraft and the credit it consumed
+ALTER TABLE tbl_Activity ADD COLUMN overdraftid BIGINT REFERENCES tbl_Overdrafts (overdraftid);
+CREATE INDEX idx_activity_overdraftid ON tbl_Activity (overdraftid) WHERE overdraftid IS NOT NULL;
@@ -0,0 +1,4 @@
+-- debitid is not covered by the hash, dropping it leaves the hash chain intact
+DROP INDEX IF EXISTS idx_activity_debitid;
+ALTER TABLE tbl_Activity DROP COLUMN IF EXISTS debitid;
+DROP SEQUENCE IF EXISTS tbl_activity_debitid_seq;
@@ -0,0 +1,17 @@
+-- the debit operation an activity row was written by, shared by the rows of the credits it consumed and of its
+-- overdraft so that velocity limits count debits rather than rows. it is not covered by the hash
+CREATE SEQUENCE tbl_activity_debitid_seq;
+ALTER TABLE tbl_Activity ADD COLUMN debitid BIGINT;
+
+-- the rows of a debit written before were inserted by a single transaction and share its start time as created
+UPDATE tbl_Activity a
+SET debitid = d.debitid
+FROM (SELECT userid, created, MIN(tranid) AS debitid
+      FROM tbl_Activity
+      WHERE NOT iscredit AND event IS NULL AND (overdraftid IS NULL OR usercreditid IS NULL)
+      GROUP BY userid, created) d
+WHERE a.userid = d.userid AND a.created = d.created
+  AND NOT a.iscredit AND a.event IS NULL AND (a.overdraftid IS NULL OR a.usercreditid IS NULL);
+SELECT setval('tbl_activity_debitid_seq', COALESCE((SELECT MAX(debitid) FROM tbl_Activity), 0) + 1, false);
+
+CREATE INDEX idx_activity_debitid ON tbl_Activity (userid, debitid) WHERE debitid IS NOT NULL;
@@ -0,0 +1,34 @@
+-- the rows are hashed again in the former canonical form, which covers neither created nor the boundaries of the
+-- optional fields
+DO
+$$
+    DECLARE
+        activity RECORD;
+        previous CHAR(64);
+        current  UUID;
+    BEGIN
+        FOR activity IN SELECT * FROM tbl_Activity ORDER BY userid, tranid
+            LOOP
+                IF current IS DISTINCT FROM activity.userid THEN
+                    current := activity.userid;
+                    previous := repeat('0', 64);
+                END IF;
+                UPDATE tbl_Activity
+                SET prevhash = previous,
+                    hash     = encode(sha256(convert_to(concat_ws('|', activity.userid::TEXT, activity.tranid::TEXT,
+                                                                  CASE WHEN activity.iscredit THEN 'true' ELSE 'false' END,
+                                                                  round(activity.amount * 100)::BIGINT::TEXT,
+                                                                  COALESCE(activity.usercreditid, 0)::TEXT,
+                                                                  COALESCE(activity.reversaloftranid, 0)::TEXT,
+                                                                  previous)
+                                                            || COALESCE('|' || NULLIF(activity.event, ''), '')
+                                                            || COALESCE('|category=' || NULLIF(activity.category, ''), '')
+                                                            || COALESCE('|merchant=' || NULLIF(activity.merchant, ''), '')
+                                                            || COALESCE('|overdraft=' || NULLIF(activity.overdraftid, 0)::TEXT, ''),
+                                                        'UTF8')), 'hex')
+                WHERE userid = activity.userid
+                  AND tranid = activity.tranid
+                RETURNING hash INTO previous;
+            END LOOP;
+    END
+$$;
@@ -0,0 +1,63 @@
+-- the hash of every activity row is computed again in the canonical form of hashchain.Link.Hash: every field as its
+-- length in bytes, a colon and its value, so that no value can pass for another field, and created covered as well.
+-- the chain of every user is checked against the former form first, a row edited by direct SQL stops the migration
+-- instead of being hashed again as if it had never been touched
+CREATE FUNCTION fn_activity_canonical(fields TEXT[]) RETURNS TEXT AS
+$$
+SELECT string_agg(octet_length(field)::TEXT || ':' || field, '' ORDER BY position)
+FROM unnest(fields) WITH ORDINALITY AS f(field, position)
+$$ LANGUAGE SQL IMMUTABLE;
+
+DO
+$$
+    DECLARE
+        activity RECORD;
+        previous CHAR(64);
+        former   CHAR(64);
+        current  UUID;
+    BEGIN
+        FOR activity IN SELECT * FROM tbl_Activity ORDER BY userid, tranid
+            LOOP
+                IF current IS DISTINCT FROM activity.userid THEN
+                    current := activity.userid;
+                    previous := repeat('0', 64);
+                    former := repeat('0', 64);
+                END IF;
+                IF activity.prevhash IS DISTINCT FROM former OR activity.hash IS DISTINCT FROM
+                    encode(sha256(convert_to(concat_ws('|', activity.userid::TEXT, activity.tranid::TEXT,
+                                                       CASE WHEN activity.iscredit THEN 'true' ELSE 'false' END,
+                                                       round(activity.amount * 100)::BIGINT::TEXT,
+                                                       COALESCE(activity.usercreditid, 0)::TEXT,
+                                                       COALESCE(activity.reversaloftranid, 0)::TEXT,
+                                                       activity.prevhash)
+                                                 || COALESCE('|' || NULLIF(activity.event, ''), '')
+                                                 || COALESCE('|category=' || NULLIF(activity.category, ''), '')
+                                                 || COALESCE('|merchant=' || NULLIF(activity.merchant, ''), '')
+                                                 || COALESCE('|overdraft=' || NULLIF(activity.overdraftid, 0)::TEXT, ''),
+                                             'UTF8')), 'hex') THEN
+                    RAISE EXCEPTION 'activity % of user % does not match its hash, the chain has to be repaired before it is hashed again',
+                        activity.tranid, activity.userid;
+                END IF;
+                former := activity.hash;
+
+                UPDATE tbl_Activity
+                SET prevhash = previous,
+                    hash     = encode(sha256(convert_to(fn_activity_canonical(ARRAY [activity.userid::TEXT, activity.tranid::TEXT,
+                        CASE WHEN activity.iscredit THEN 'true' ELSE 'false' END,
+                        round(activity.amount * 100)::BIGINT::TEXT,
+                        COALESCE(activity.usercreditid, 0)::TEXT,
+                        COALESCE(activity.reversaloftranid, 0)::TEXT,
+                        COALESCE(activity.event, ''),
+                        COALESCE(activity.category, ''),
+                        COALESCE(activity.merchant, ''),
+                        COALESCE(activity.overdraftid, 0)::TEXT,
+                        COALESCE(to_char(activity.created, 'YYYY-MM-DD HH24:MI:SS.US'), ''),
+                        previous::TEXT]), 'UTF8')), 'hex')
+                WHERE userid = activity.userid
+                  AND tranid = activity.tranid
+                RETURNING hash INTO previous;
+            END LOOP;
+    END
+$$;
+
+DROP FUNCTION fn_activity_canonical(TEXT[]);
@@ -0,0 +1,14 @@
+package models
+
+//CreditDiscrepancy is a credit whose amount differs from the amount recomputed from its activity:
+//the original credit minus its debits plus the reversals of those debits
+type CreditDiscrepancy struct {
+	UserId       string  `json:"userid"`
+	UserCreditId uint64  `json:"usercreditid"`
+	Amount       float64 `json:"amount"`
+	Expected     float64 `json:"expected"`
+	Original     float64 `json:"original"`
+	Debited      float64 `json:"debited"`
+	Reversed     float64 `json:"reversed"`
+	CorrectionId uint64  `json:"correctionid,omitempty"`
+}
@@ -1,9 +1,38 @@
 package models
 
 const (
-	UserCreditSelectStatement   string = `SELECT userid, usercreditid, amount, transactiontype, priority, expiry FROM tbl_UserCredits WHERE userid=$1 AND isexpired=false AND amount>0 ORDER BY priority DESC`
-	UserCreditInsertStatement   string = `INSERT INTO tbl_UserCredits(userid, amount, transactiontype, priority, expiry) VALUES ($1, $2, $3, $4, $5) RETURNING usercreditid`
-	UserActivityInsertStatement string = `INSERT INTO tbl_Activity(userid, iscredit, amount, usercreditid) VALUES ($1, $2, $3, $4)`
-	UserCreditUpdateStatement   string = `UPDATE tbl_UserCredits SET amount=$1, updated=(NOW() AT TIME ZONE 'UTC') WHERE userid=$2 AND usercreditid=$3`
-	UserActivitySelectStatement string = `SELECT userid, created, iscredit, amount FROM tbl_Activity WHERE userid=$1 ORDER BY iscredit DESC, created ASC OFFSET $2 LIMIT $3`
+	UserCreditSelectStatement   string = `SELECT userid, usercreditid, amount, transactiontype, priority, expiry, COALESCE(allowedcategories, '{}') FROM tbl_UserCredits WHERE userid=$1 AND isexpired=false AND amount>0 ORDER BY priority DESC`
+	UserCreditInsertStatement   string = `INSERT INTO tbl_UserCredits(userid, amount, original_amount, transactiontype, priority, expiry, allowedcategories) VALUES ($1, $2, $2, $3, $4, $5, $6) RETURNING usercreditid, expiry > (NOW() AT TIME ZONE 'UTC')`
+	UserActivityNextIdStatement string = `SELECT nextval(pg_get_serial_sequence('tbl_activity', 'tranid')), NOW() AT TIME ZONE 'UTC'`
+	DebitNextIdStatement        string = `SELECT nextval('tbl_activity_debitid_seq')`
+	UserActivityInsertStatement string = `INSERT INTO tbl_Activity(tranid, userid, iscredit, amount, usercreditid, reversaloftranid, event, merchant, category, overdraftid, debitid, created, prevhash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`
+	UserCreditUpdateStatement   string = `UPDATE tbl_UserCredits SET amount=amount-$1, consumed_amount=consumed_amount+$1, updated=(NOW() AT TIME ZONE 'UTC') WHERE userid=$2 AND usercreditid=$3`
+	UserActivitySelectStatement string = `SELECT userid, tranid, created, iscredit, amount, COALESCE(usercreditid, 0), COALESCE(reversaloftranid, 0), COALESCE(event, ''), COALESCE(merchant, ''), COALESCE(category, ''), COALESCE(overdraftid, 0), prevhash, hash FROM tbl_Activity WHERE userid=$1 ORDER BY iscredit DESC, created ASC OFFSET $2 LIMIT $3`
+
+	UserCreditListStatement     string = `SELECT userid, usercreditid, COALESCE(updated, created), created, amount, original_amount, consumed_amount, COALESCE(transactiontype, ''), COALESCE(priority, 0), expiry, isexpired, COALESCE(allowedcategories, '{}') FROM tbl_UserCredits WHERE userid=$1 ORDER BY usercreditid ASC`
+	UserActivityLedgerStatement string = `SELECT userid, tranid, created, iscredit, amount, COALESCE(usercreditid, 0), COALESCE(reversaloftranid, 0), COALESCE(event, ''), COALESCE(merchant, ''), COALESCE(category, ''), COALESCE(overdraftid, 0), prevhash, hash FROM tbl_Activity WHERE userid=$1 ORDER BY tranid ASC`
+	UserActivityDebitStatement  string = `SELECT iscredit OR event IS NOT NULL, overdraftid IS NOT NULL, amount, COALESCE(usercreditid, 0), EXISTS(SELECT 1 FROM tbl_Activity WHERE reversaloftranid=$2) FROM tbl_Activity WHERE userid=$1 AND tranid=$2 FOR UPDATE`
+	UserCreditRestoreStatement  string = `UPDATE tbl_UserCredits SET amount=amount+$1, consumed_amount=consumed_amount-$1, updated=(NOW() AT TIME ZONE 'UTC') WHERE userid=$2 AND usercreditid=$3 RETURNING amount`
+
+	UserStatusSelectStatement string = `SELECT status FROM tbl_Users WHERE userid=$1 FOR SHARE`
+	UserStatusLockStatement   string = `SELECT status FROM tbl_Users WHERE userid=$1 FOR UPDATE`
+	UserStatusUpdateStatement string = `UPDATE tbl_Users SET status=$1, statusreason=$2, statusupdated=(NOW() AT TIME ZONE 'UTC') WHERE userid=$3`
+
+	UserOverdraftSelectStatement  string = `SELECT overdraftlimit, COALESCE((SELECT SUM(outstanding) FROM tbl_Overdrafts WHERE userid=$1), 0) FROM tbl_Users WHERE userid=$1`
+	UserOverdraftLockStatement    string = `SELECT overdraftlimit FROM tbl_Users WHERE userid=$1 FOR UPDATE`
+	UserOverdraftUpdateStatement  string = `UPDATE tbl_Users SET overdraftlimit=$1 WHERE userid=$2`
+	OverdraftInsertStatement      string = `INSERT INTO tbl_Overdrafts(userid, amount, outstanding) VALUES ($1, $2, $2) RETURNING overdraftid`
+	OverdraftOutstandingStatement string = `SELECT overdraftid, outstanding FROM tbl_Overdrafts WHERE userid=$1 AND outstanding>0 ORDER BY overdraftid ASC FOR UPDATE`
+	OverdraftRepayStatement       string = `UPDATE tbl_Overdrafts SET outstanding=outstanding-$1, updated=(NOW() AT TIME ZONE 'UTC') WHERE overdraftid=$2`
+
+	LedgerReconcileStatement string = `SELECT userid, usercreditid, amount, original - debited + reversed, original, debited, reversed FROM (
+		SELECT c.userid, c.usercreditid, c.amount,
+			COALESCE(SUM(a.amount) FILTER (WHERE a.iscredit AND a.reversaloftranid IS NULL), 0) AS original,
+			COALESCE(SUM(a.amount) FILTER (WHERE NOT a.iscredit), 0) AS debited,
+			COALESCE(SUM(a.amount) FILTER (WHERE a.reversaloftranid IS NOT NULL), 0) AS reversed
+		FROM tbl_UserCredits c LEFT JOIN tbl_Activity a ON a.usercreditid=c.usercreditid
+		GROUP BY c.userid, c.usercreditid, c.amount) AS credits
+		WHERE amount <> original - debited + reversed ORDER BY userid, usercreditid`
+	UserCreditCorrectStatement      string = `UPDATE tbl_UserCredits SET amount=$1, consumed_amount=original_amount-$1, updated=(NOW() AT TIME ZONE 'UTC') WHERE usercreditid=$2 AND amount=$3`
+	LedgerCorrectionInsertStatement string = `INSERT INTO tbl_LedgerCorrections(userid, usercreditid, previousamount, correctedamount, actor, reason) VALUES ($1, $2, $3, $4, $5, $6) RETURNING correctionid`
 )
@@ -1,5 +1,12 @@
 package models
 
+//account statuses of a user, a frozen account cannot be debited and a closed one can neither be debited nor credited
+const (
+	StatusActive = "active"
+	StatusFrozen = "frozen"
+	StatusClosed = "closed"
+)
+
 type User struct {
 	UserId    string `json:"userid"`
 	FirstName string `json:"firstname"`
@@ -8,3 +15,13 @@ type User struct {
 	DOB       string `json:"dateofbirth"`
 	Mobile    string `json:"mobile"`
 }
+
+//Redact returns a copy of the user safe to be logged, only the user id is kept and personal data is masked
+func (u User) Redact() interface{} {
+	for _, field := range []*string{&u.FirstName, &u.LastName, &u.Email, &u.DOB, &u.Mobile} {
+		if len(*field) > 0 {
+			*field = "[REDACTED]"
+		}
+	}
+	return u
+}
@@ -1,10 +1,19 @@
 package models
 
 type UserActivity struct {
-	UserId       string  `json:"userid"`
-	TranId       uint64  `json:"tranid,omitempty"`
-	Created      string  `json:"created"`
-	IsCredit     bool    `json:"iscredit"`
-	Amount       float64 `json:"amount"`
-	UserCreditId uint64  `json:"usercreditid,omitempty"`
+	UserId           string  `json:"userid"`
+	TranId           uint64  `json:"tranid,omitempty"`
+	Created          string  `json:"created"`
+	IsCredit         bool    `json:"iscredit"`
+	Amount           float64 `json:"amount"`
+	UserCreditId     uint64  `json:"usercreditid,omitempty"`
+	ReversalOfTranId uint64  `json:"reversaloftranid,omitempty"`
+	Event            string  `json:"event,omitempty"`
+	Merchant         string  `json:"merchant,omitempty"`
+	Category         string  `json:"category,omitempty"`
+	OverdraftId      uint64  `json:"overdraftid,omitempty"`
+	PrevHash         string  `json:"prevhash,omitempty"`
+	Hash             string  `json:"hash,omitempty"`
+	//the debit operation the row was written by, shared by all its rows. it is not covered by the hash
+	DebitId uint64 `json:"-"`
 }
@@ -0,0 +1,21 @@
+package models
+
+//UserBalance is the spendable amount of a user along with every credit granted to them. Original and Consumed sum
+//the original and consumed amounts of all the credits, Expired is the amount left on expired credits. Overdrawn is
+//what the user owes on its overdrafts, repaid first by the next credits, and OverdraftLimit how far it may go below zero
+type UserBalance struct {
+	UserId         string       `json:"userid"`
+	Available      float64      `json:"available"`
+	Original       float64      `json:"original"`
+	Consumed       float64      `json:"consumed"`
+	Expired        float64      `json:"expired"`
+	OverdraftLimit float64      `json:"overdraft_limit"`
+	Overdrawn      float64      `json:"overdrawn"`
+	Credits        []UserCredit `json:"credits"`
+}
+
+//Ledger is the complete export of a user's credits and activity
+type Ledger struct {
+	UserBalance
+	Activities []UserActivity `json:"activities"`
+}
@@ -1,15 +1,43 @@
 package models
 
+import "strings"
+
 type UserCredit struct {
 	UserId          string  `json:"userid"`
 	UserCreditId    uint64  `json:"usercreditid"`
 	Updated         string  `json:"updated"`
 	Created         string  `json:"created"`
 	Amount          float64 `json:"amount"`
+	OriginalAmount  float64 `json:"original_amount"`
+	ConsumedAmount  float64 `json:"consumed_amount"`
 	TransactionType string  `json:"transactiontype"`
 	Priority        int     `json:"priority"`
 	Expiry          string  `json:"expiry"`
 	IsExpired       bool    `json:"isexpired"`
-	Processed       bool
-	Consumed        float64
+	Processed       bool    `json:"-"`
+	Consumed        float64 `json:"-"`
+	//why the credit is granted, recorded in the audit log and not stored with the credit
+	Reason string `json:"reason,omitempty"`
+	//categories the credit may be spent in, every category when empty
+	AllowedCategories []string `json:"allowed_categories,omitempty"`
+}
+
+//AllowsCategory tells whether the credit may be spent by a debit made in category, a credit restricted to some
+//categories cannot be spent by a debit without category
+func (c UserCredit) AllowsCategory(category string) bool {
+	if len(c.AllowedCategories) == 0 {
+		return true
+	}
+	category = NormalizeCategory(category)
+	for _, allowed := range c.AllowedCategories {
+		if allowed == category {
+			return true
+		}
+	}
+	return false
+}
+
+//NormalizeCategory returns the form categories are stored and compared in, "Food " and "food" are the same category
+func NormalizeCategory(category string) string {
+	return strings.ToLower(strings.TrimSpace(category))
 }
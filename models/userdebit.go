@@ -3,4 +3,9 @@ package models
 type UserDebit struct {
 	UserId string  `json:"userid"`
 	Amount float64 `json:"amount"`
+	//why the user is debited, recorded in the audit log
+	Reason string `json:"reason,omitempty"`
+	//where the amount is spent, only the credits allowed in the category can be consumed
+	Merchant string `json:"merchant,omitempty"`
+	Category string `json:"category,omitempty"`
 }
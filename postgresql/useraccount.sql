@@ -1,3 +1,8 @@
+-- database objects are created by the schema migrations("./migrations"), this script only creates the database
+-- and loads sample users. run it in three steps:
+--   1. create the database(below) while connected to the default "postgres" database
+--   2. apply the migrations with "go run . migrate up"
+--   3. load the sample users(below) while connected to "UserAccount"
 CREATE
 DATABASE "UserAccount"
     WITH
@@ -8,41 +13,6 @@ DATABASE "UserAccount"
     TABLESPACE = pg_default
     CONNECTION LIMIT = -1;
 
-CREATE TABLE tbl_Users
-(
-    userid UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-    fname  VARCHAR(20),
-    lname  VARCHAR(20),
-    email  VARCHAR(30),
-    dob    DATE,
-    mobile VARCHAR(10)
-);
-
-CREATE TABLE tbl_Activity
-(
-    userid       UUID REFERENCES tbl_Users (userid),
-    tranid       BIGSERIAL,
-    created      TIMESTAMP WITHOUT TIME ZONE DEFAULT (NOW() AT TIME ZONE 'UTC'),
-    iscredit     BOOLEAN DEFAULT TRUE,
-    amount       NUMERIC(10, 2) NOT NULL,
-    usercreditid BIGINT REFERENCES tbl_UserCredits (usercreditid),
-    PRIMARY KEY (userid, tranid)
-);
-
-CREATE TABLE tbl_UserCredits
-(
-    userid          UUID REFERENCES tbl_Users (userid),
-    usercreditid    BIGSERIAL UNIQUE,
-    updated         TIMESTAMP WITHOUT TIME ZONE DEFAULT (NOW() AT TIME ZONE 'UTC'),
-    created         TIMESTAMP WITHOUT TIME ZONE DEFAULT (NOW() AT TIME ZONE 'UTC'),
-    amount          NUMERIC(10, 2) NOT NULL,
-    transactiontype VARCHAR(10),
-    priority        INTEGER,
-    expiry          TIMESTAMP WITHOUT TIME ZONE NOT NULL,
-    isexpired       BOOLEAN DEFAULT FALSE,
-    PRIMARY KEY (userid, usercreditid)
-);
-
-INSERT INTO tbl_Users(fname, lanme, email, dob, mobile) VALUES('John', 'Doe', 'john.doe@gmail.com', '1987-11-10', '9994447878')
-INSERT INTO tbl_Users(fname, lanme, email, dob, mobile) VALUES('Jane', 'Doe', 'jane.doe@gmail.com', '1989-10-09', '9995557878')
-INSERT INTO tbl_Users(fname, lanme, email, dob, mobile) VALUES('Jonathan', 'Smith', 'jonathan.smith@gmail.com', '1988-08-09', '8885557878')
\ No newline at end of file
+INSERT INTO tbl_Users(fname, lname, email, dob, mobile) VALUES('John', 'Doe', 'john.doe@gmail.com', '1987-11-10', '9994447878');
+INSERT INTO tbl_Users(fname, lname, email, dob, mobile) VALUES('Jane', 'Doe', 'jane.doe@gmail.com', '1989-10-09', '9995557878');
+INSERT INTO tbl_Users(fname, lname, email, dob, mobile) VALUES('Jonathan', 'Smith', 'jonathan.smith@gmail.com', '1988-08-09', '8885557878');
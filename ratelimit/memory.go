@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+//interval between two sweeps of the buckets that filled up again
+const sweepInterval = time.Minute
+
+//Memory keeps the token buckets in the process, suited for a single replica as every replica counts on its own
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	//returns the current time, replaced by tests
+	now  func() time.Time
+	stop chan struct{}
+	once sync.Once
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	//time at which the bucket is full again, it can then be dropped as a new one would be identical
+	full time.Time
+}
+
+//NewMemory creates a process local limiter, buckets that filled up again are dropped in the background until Close
+func NewMemory() *Memory {
+	m := &Memory{buckets: make(map[string]*bucket), now: time.Now, stop: make(chan struct{})}
+	go m.sweepEvery(sweepInterval)
+	return m
+}
+
+func (m *Memory) Allow(ctx context.Context, key string, rate Rate) (bool, time.Duration, error) {
+	now := m.now()
+	perSecond := rate.perSecond()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rate.Burst), updated: now}
+		m.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(rate.Burst), b.tokens+elapsed.Seconds()*perSecond)
+		b.updated = now
+	}
+	if b.tokens < 1 {
+		return false, seconds((1 - b.tokens) / perSecond), nil
+	}
+	b.tokens--
+	b.full = now.Add(seconds((float64(rate.Burst) - b.tokens) / perSecond))
+	return true, 0, nil
+}
+
+func (m *Memory) Close() error {
+	m.once.Do(func() { close(m.stop) })
+	return nil
+}
+
+//function to drop the buckets that filled up again every interval, so idle keys do not pile up
+func (m *Memory) sweepEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.sweep()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *Memory) sweep() {
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, b := range m.buckets {
+		if !now.Before(b.full) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//backends supported by New
+const (
+	BackendMemory = "memory"
+	BackendNone   = "none"
+)
+
+//Rate lets Limit requests through every Period on average, with up to Burst requests at once
+type Rate struct {
+	Limit  int
+	Period time.Duration
+	Burst  int
+}
+
+//ParseRate reads a rate written as "<limit>/<period>[:<burst>]", the period being s, m, h or a duration like 10s.
+//the burst defaults to the limit, e.g. "20/s:40" or "100/m"
+func ParseRate(s string) (Rate, error) {
+	spec, burst := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		spec, burst = s[:i], s[i+1:]
+	}
+	parts := strings.Split(spec, "/")
+	if len(parts) != 2 {
+		return Rate{}, fmt.Errorf("rate %q must be written like 20/s or 20/s:40", s)
+	}
+	var r Rate
+	var err error
+	if r.Limit, err = strconv.Atoi(strings.TrimSpace(parts[0])); err != nil || r.Limit <= 0 {
+		return Rate{}, fmt.Errorf("limit of rate %q must be a positive number", s)
+	}
+	switch period := strings.TrimSpace(parts[1]); period {
+	case "s":
+		r.Period = time.Second
+	case "m":
+		r.Period = time.Minute
+	case "h":
+		r.Period = time.Hour
+	default:
+		if r.Period, err = time.ParseDuration(period); err != nil || r.Period <= 0 {
+			return Rate{}, fmt.Errorf("period of rate %q must be s, m, h or a positive duration", s)
+		}
+	}
+	r.Burst = r.Limit
+	if len(burst) > 0 {
+		if r.Burst, err = strconv.Atoi(strings.TrimSpace(burst)); err != nil || r.Burst <= 0 {
+			return Rate{}, fmt.Errorf("burst of rate %q must be a positive number", s)
+		}
+	}
+	return r, nil
+}
+
+//String returns the rate in the format read by ParseRate
+func (r Rate) String() string {
+	return fmt.Sprint(r.Limit, "/", r.Period, ":", r.Burst)
+}
+
+//function to get the number of tokens added to a bucket every second
+func (r Rate) perSecond() float64 {
+	return float64(r.Limit) / r.Period.Seconds()
+}
+
+//Limiter is implemented by every rate limiting backend. every key has its own token bucket, filled at the given
+//rate up to its burst, and every allowed request takes a token from it. a backend shared by the replicas of the
+//service, e.g. on redis, only has to implement this interface to be used in place of the process local one.
+//Allow returns whether the request is allowed and, when it is not, how long to wait before the next token
+type Limiter interface {
+	Allow(ctx context.Context, key string, rate Rate) (bool, time.Duration, error)
+	Close() error
+}
+
+//New creates the rate limiting backend with the given name, the memory backend is used when no name is given
+func New(backend string) (Limiter, error) {
+	switch backend {
+	case "", BackendMemory:
+		return NewMemory(), nil
+	case BackendNone:
+		return NewNoOp(), nil
+	}
+	return nil, errors.New(fmt.Sprint("ratelimit: unknown backend ", strconv.Quote(backend)))
+}
+
+//NoOp disables rate limiting, every request is allowed
+type NoOp struct{}
+
+//NewNoOp creates a limiter allowing every request
+func NewNoOp() NoOp {
+	return NoOp{}
+}
+
+func (NoOp) Allow(ctx context.Context, key string, rate Rate) (bool, time.Duration, error) {
+	return true, 0, nil
+}
+
+func (NoOp) Close() error {
+	return nil
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+//test case to verify rates are read with their default and explicit bursts and invalid ones are rejected
+func TestParseRate(t *testing.T) {
+	cases := map[string]Rate{
+		"20/s":    {Limit: 20, Period: time.Second, Burst: 20},
+		"100/m:5": {Limit: 100, Period: time.Minute, Burst: 5},
+		"3/10s":   {Limit: 3, Period: 10 * time.Second, Burst: 3},
+	}
+	for s, expected := range cases {
+		if r, err := ParseRate(s); err != nil || r != expected {
+			t.Errorf("Expected %s to be %+v. Got %+v, %v", s, expected, r, err)
+		}
+	}
+	for _, s := range []string{"", "20", "0/s", "20/d", "20/s:0", "-1/s", "20/s:x"} {
+		if _, err := ParseRate(s); err == nil {
+			t.Errorf("Expected %q to be rejected", s)
+		}
+	}
+}
+
+//test case to verify the token bucket allows bursts, refills at the rate and tells how long to wait
+func TestMemoryTokenBucket(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	m := NewMemory()
+	defer m.Close()
+	m.now = func() time.Time { return now }
+	rate := Rate{Limit: 2, Period: time.Second, Burst: 3}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := m.Allow(ctx, "client", rate); !ok {
+			t.Fatalf("Expected request %d of the burst to be allowed", i+1)
+		}
+	}
+	ok, wait, err := m.Allow(ctx, "client", rate)
+	if ok || err != nil || wait != 500*time.Millisecond {
+		t.Errorf("Expected the request after the burst to wait 500ms. Got %v, %v, %v", ok, wait, err)
+	}
+	if ok, _, _ := m.Allow(ctx, "other", rate); !ok {
+		t.Error("Expected every key to have its own bucket")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _, _ := m.Allow(ctx, "client", rate); !ok {
+		t.Error("Expected a refilled token to be allowed")
+	}
+	if ok, _, _ := m.Allow(ctx, "client", rate); ok {
+		t.Error("Expected the bucket to be empty again")
+	}
+
+	now = now.Add(time.Hour)
+	m.sweep()
+	if len(m.buckets) != 0 {
+		t.Errorf("Expected full buckets to be swept. Got %d", len(m.buckets))
+	}
+}
@@ -1,6 +1,8 @@
 package router
 
 import (
+	"github.com/a0rana/UserAccountService/auth"
+	"github.com/a0rana/UserAccountService/metrics"
 	"github.com/a0rana/UserAccountService/middleware"
 	"github.com/gorilla/mux"
 )
@@ -9,10 +11,29 @@ import (
 func Router() *mux.Router {
 
 	router := mux.NewRouter()
+	router.Use(middleware.RequestID, middleware.Metrics, middleware.CORS)
 
-	router.HandleFunc("/transactions", middleware.GetAllTransactions).Methods("GET", "OPTIONS")
-	router.HandleFunc("/credit", middleware.CreateUserCredit).Methods("POST", "OPTIONS")
-	router.HandleFunc("/debit", middleware.CreateUserDebit).Methods("POST", "OPTIONS")
+	router.Handle("/metrics", metrics.Handler()).Methods("GET")
+	router.HandleFunc("/healthz", middleware.Health).Methods("GET")
+	router.HandleFunc("/readyz", middleware.Ready).Methods("GET")
+
+	//OPTIONS is accepted on the api routes so that middleware.CORS answers preflight requests.
+	//health checks and metrics stay open for probes and scrapers, every other endpoint needs credentials.
+	//the address limit runs first so that requests failing authentication are limited too
+	api := router.NewRoute().Subrouter()
+	api.Use(middleware.RateLimitAddress, middleware.Authenticate, middleware.RateLimit)
+
+	api.HandleFunc("/transactions", middleware.RequireScope(auth.ScopeRead, middleware.GetAllTransactions)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/credit", middleware.RequireScope(auth.ScopeCredit, middleware.CreateUserCredit)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/debit", middleware.RequireScope(auth.ScopeDebit, middleware.CreateUserDebit)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/balance", middleware.RequireScope(auth.ScopeRead, middleware.GetBalance)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/chain/head", middleware.RequireScope(auth.ScopeRead, middleware.GetActivityChainHead)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/admin/audit", middleware.RequireScope(auth.ScopeAdmin, middleware.GetAuditLog)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/admin/limits", middleware.RequireScope(auth.ScopeAdmin, middleware.GetUserSpendingLimits)).Methods("GET", "OPTIONS")
+	api.HandleFunc("/admin/limits", middleware.RequireScope(auth.ScopeAdmin, middleware.SetUserSpendingLimits)).Methods("POST")
+	api.HandleFunc("/admin/status", middleware.RequireScope(auth.ScopeAdmin, middleware.UpdateAccountStatus)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/admin/segment", middleware.RequireScope(auth.ScopeAdmin, middleware.UpdateUserSegment)).Methods("POST", "OPTIONS")
+	api.HandleFunc("/admin/overdraft", middleware.RequireScope(auth.ScopeAdmin, middleware.UpdateOverdraftLimit)).Methods("POST", "OPTIONS")
 
 	return router
 }
@@ -0,0 +1,140 @@
+package scheduledjob
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/a0rana/UserAccountService/audit"
+	"github.com/a0rana/UserAccountService/database"
+	"github.com/a0rana/UserAccountService/journal"
+	"github.com/a0rana/UserAccountService/logger"
+	"github.com/a0rana/UserAccountService/metrics"
+	"github.com/a0rana/UserAccountService/models"
+	"github.com/jasonlvhit/gocron"
+	"time"
+)
+
+var (
+	expiryRuns = metrics.NewCounterVec("expiry_job_runs_total",
+		"Number of credit expiry job runs by result.", "result")
+	expiryDuration = metrics.NewHistogramVec("expiry_job_duration_seconds",
+		"Duration of the credit expiry job runs.", metrics.DefaultBuckets)
+	expiryRows = metrics.NewCounterVec("expiry_job_rows_updated_total",
+		"Number of user credits marked as expired by the credit expiry job.")
+)
+
+//actor of the expiries recorded in the audit log by the scheduled job
+const expiryJobActor = "credit-expiry-job"
+
+//Start runs the credit expiry job every interval, send on the returned channel to stop the scheduler
+func Start(db *sql.DB, interval time.Duration) chan bool {
+	s := gocron.NewScheduler()
+	s.Every(uint64(interval / time.Second)).Seconds().Do(func() {
+		if _, err := UpdateExpiredCredits(audit.WithActor(context.Background(), expiryJobActor), db); err != nil {
+			logger.Error(context.Background(), "credit expiry job failed", "error", err)
+		}
+	})
+	return s.Start()
+}
+
+//UpdateExpiredCredits sets isexpired attribute to true for expired user credits and records the run in the metrics, it returns the number of credits expired.
+//a ctx created by database.WithDryRun reports the credits that would expire without changing them
+func UpdateExpiredCredits(ctx context.Context, db *sql.DB) (int, error) {
+	start := time.Now()
+	rows, err := updateExpiredCredits(ctx, db)
+	expiryDuration.WithLabelValues().Observe(time.Since(start).Seconds())
+	if err != nil {
+		expiryRuns.WithLabelValues("failure").Inc()
+		return 0, err
+	}
+	expiryRuns.WithLabelValues("success").Inc()
+	if !database.IsDryRun(ctx) {
+		expiryRows.WithLabelValues().Add(float64(rows))
+	}
+	return rows, nil
+}
+
+//expiryState is the state of an expired credit recorded in the audit log
+type expiryState struct {
+	Amount    float64 `json:"amount"`
+	IsExpired bool    `json:"isexpired"`
+}
+
+//update isexpired attribute to true for expired user credits, returns the number of credits updated
+func updateExpiredCredits(ctx context.Context, db *sql.DB) (int, error) {
+	var rollbackError error
+	// create the insert sql query
+	// returning userid will return the id of the inserted user
+	userCreditSqlStatement := `SELECT userid, usercreditid, amount, transactiontype, priority, expiry FROM tbl_UserCredits WHERE isexpired=false AND expiry<=(NOW() AT TIME ZONE 'UTC')`
+
+	// begin a transaction bound to the caller's context
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return 0, errors.New(err.Error())
+	}
+	var rows *sql.Rows
+	rows, err = tx.QueryContext(ctx, userCreditSqlStatement)
+
+	if err != nil {
+		if rollbackError = tx.Rollback(); rollbackError != nil {
+			return 0, errors.New(fmt.Sprint("unable to rollback. ", rollbackError.Error()))
+		}
+		return 0, errors.New(err.Error())
+	}
+	defer rows.Close()
+
+	//create a slice to keep track of available credit(s) to consume
+	m := make([]models.UserCredit, 0)
+
+	for rows.Next() {
+		var credit models.UserCredit
+		if err := rows.Scan(&credit.UserId, &credit.UserCreditId, &credit.Amount, &credit.TransactionType, &credit.Priority, &credit.Expiry); err != nil {
+			if rollbackError = tx.Rollback(); rollbackError != nil {
+				return 0, errors.New(fmt.Sprint("unable to rollback. ", rollbackError.Error()))
+			}
+			return 0, errors.New(err.Error())
+		}
+		m = append(m, credit)
+	}
+
+	var stmt *sql.Stmt
+	stmt, err = tx.PrepareContext(ctx, `UPDATE tbl_UserCredits SET isexpired=true, updated=(NOW() AT TIME ZONE 'UTC') WHERE userid=$1 AND usercreditid=$2`)
+	if err != nil {
+		return 0, errors.New(err.Error())
+	}
+	defer stmt.Close()
+
+	for _, credit := range m {
+		if _, err = stmt.ExecContext(ctx, credit.UserId, credit.UserCreditId); err != nil {
+			if rollbackError = tx.Rollback(); rollbackError != nil {
+				return 0, errors.New(fmt.Sprint("unable to rollback. ", rollbackError.Error()))
+			}
+			return 0, errors.New(err.Error())
+		}
+		//the amount left on the credit is forfeited
+		if _, err = journal.Post(ctx, tx, journal.Expiry(credit.UserId, credit.UserCreditId, credit.Amount)); err != nil {
+			if rollbackError = tx.Rollback(); rollbackError != nil {
+				return 0, errors.New(fmt.Sprint("unable to rollback. ", rollbackError.Error()))
+			}
+			return 0, errors.New(err.Error())
+		}
+		_, err = audit.Record(ctx, tx, audit.Event{Action: audit.ActionExpiry, UserId: credit.UserId, Entity: audit.EntityUserCredit,
+			EntityId: credit.UserCreditId, Before: expiryState{Amount: credit.Amount}, After: expiryState{Amount: credit.Amount, IsExpired: true}})
+		if err != nil {
+			if rollbackError = tx.Rollback(); rollbackError != nil {
+				return 0, errors.New(fmt.Sprint("unable to rollback. ", rollbackError.Error()))
+			}
+			return 0, errors.New(err.Error())
+		}
+	}
+
+	err = database.Commit(ctx, tx)
+	if err != nil {
+		return 0, errors.New(err.Error())
+	}
+
+	logger.Info(ctx, "credit expiry job completed", "rows", len(m))
+
+	return len(m), err
+}
@@ -0,0 +1,161 @@
+package scheduledjob
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/a0rana/UserAccountService/audit"
+	"github.com/a0rana/UserAccountService/database"
+	"github.com/a0rana/UserAccountService/journal"
+	"github.com/a0rana/UserAccountService/logger"
+	"github.com/a0rana/UserAccountService/metrics"
+	"github.com/a0rana/UserAccountService/models"
+	"github.com/jasonlvhit/gocron"
+)
+
+var (
+	reconcileRuns = metrics.NewCounterVec("reconciliation_runs_total",
+		"Number of ledger reconciliation runs by result.", "result")
+	reconcileDiscrepancies = metrics.NewCounterVec("reconciliation_discrepancies_total",
+		"Number of credits found by the ledger reconciliation whose amount does not match their activity.")
+	reconcileCorrections = metrics.NewCounterVec("reconciliation_corrections_total",
+		"Number of credits corrected by the ledger reconciliation.")
+)
+
+//actor and reason of the corrections made by the scheduled reconciliation
+const (
+	reconcileJobActor  = "reconciliation-job"
+	reconcileJobReason = "automatic correction by the scheduled ledger reconciliation"
+)
+
+//ReconcileOptions tells Reconcile whether to correct the discrepancies it finds, Actor and Reason are recorded
+//with every correction and are required when Correct is set
+type ReconcileOptions struct {
+	Correct bool
+	Actor   string
+	Reason  string
+}
+
+//StartReconciliation runs the ledger reconciliation every interval, correcting the discrepancies when correct is set.
+//send on the returned channel to stop the scheduler
+func StartReconciliation(db *sql.DB, interval time.Duration, correct bool) chan bool {
+	opts := ReconcileOptions{Correct: correct, Actor: reconcileJobActor, Reason: reconcileJobReason}
+	s := gocron.NewScheduler()
+	s.Every(uint64(interval / time.Second)).Seconds().Do(func() {
+		if _, err := Reconcile(context.Background(), db, opts); err != nil {
+			logger.Error(context.Background(), "ledger reconciliation failed", "error", err)
+		}
+	})
+	return s.Start()
+}
+
+//Reconcile recomputes the remaining amount of every credit from tbl_Activity and returns the credits whose amount
+//differs, ordered by user and credit. with opts.Correct the amount of those credits is set to the recomputed one and
+//a tbl_LedgerCorrections row records the change, a ctx created by database.WithDryRun rolls the corrections back
+func Reconcile(ctx context.Context, db *sql.DB, opts ReconcileOptions) ([]models.CreditDiscrepancy, error) {
+	if opts.Correct && (len(opts.Actor) == 0 || len(opts.Reason) == 0) {
+		return nil, errors.New("an actor and a reason are required to correct the ledger")
+	}
+	discrepancies, err := reconcile(ctx, db, opts)
+	if err != nil {
+		reconcileRuns.WithLabelValues("failure").Inc()
+		return nil, err
+	}
+	reconcileRuns.WithLabelValues("success").Inc()
+	reconcileDiscrepancies.WithLabelValues().Add(float64(len(discrepancies)))
+
+	corrected := 0
+	for _, d := range discrepancies {
+		logger.Warn(ctx, "ledger discrepancy", "userid", d.UserId, "usercreditid", d.UserCreditId,
+			"amount", d.Amount, "expected", d.Expected, "correctionid", d.CorrectionId)
+		if d.CorrectionId != 0 {
+			corrected++
+		}
+	}
+	if !database.IsDryRun(ctx) {
+		reconcileCorrections.WithLabelValues().Add(float64(corrected))
+	}
+	logger.Info(ctx, "ledger reconciliation completed", "discrepancies", len(discrepancies), "corrected", corrected)
+
+	return discrepancies, nil
+}
+
+//find the discrepancies and correct them when asked to, in a single transaction so that the corrections are based
+//on the amounts that were compared
+func reconcile(ctx context.Context, db *sql.DB, opts ReconcileOptions) (_ []models.CreditDiscrepancy, err error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, models.LedgerReconcileStatement)
+	if err != nil {
+		return nil, err
+	}
+	discrepancies := make([]models.CreditDiscrepancy, 0)
+	for rows.Next() {
+		var d models.CreditDiscrepancy
+		if err = rows.Scan(&d.UserId, &d.UserCreditId, &d.Amount, &d.Expected, &d.Original, &d.Debited, &d.Reversed); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		discrepancies = append(discrepancies, d)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if opts.Correct {
+		for i := range discrepancies {
+			if err = correct(ctx, tx, &discrepancies[i], opts); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err = database.Commit(ctx, tx); err != nil {
+		return nil, err
+	}
+	return discrepancies, nil
+}
+
+//set the amount of the credit to the recomputed one and record the correction, a credit whose activity adds up to
+//a negative amount cannot be fixed by a correction and is only reported
+func correct(ctx context.Context, tx *sql.Tx, d *models.CreditDiscrepancy, opts ReconcileOptions) error {
+	if d.Expected < 0 {
+		return nil
+	}
+	result, err := tx.ExecContext(ctx, models.UserCreditCorrectStatement, d.Expected, d.UserCreditId, d.Amount)
+	if err != nil {
+		return err
+	}
+	if updated, err := result.RowsAffected(); err != nil || updated != 1 {
+		return errors.New("credit changed while the ledger was being reconciled, please run the reconciliation again")
+	}
+	err = tx.QueryRowContext(ctx, models.LedgerCorrectionInsertStatement, d.UserId, d.UserCreditId, d.Amount, d.Expected,
+		opts.Actor, opts.Reason).Scan(&d.CorrectionId)
+	if err != nil {
+		return err
+	}
+	if _, err = journal.Post(ctx, tx, journal.Correction(d.UserId, d.UserCreditId, d.Expected-d.Amount)); err != nil {
+		return err
+	}
+	_, err = audit.Record(ctx, tx, audit.Event{Action: audit.ActionCorrection, UserId: d.UserId, Entity: audit.EntityUserCredit,
+		EntityId: d.UserCreditId, Reason: opts.Reason, Actor: opts.Actor, Before: correctionState{Amount: d.Amount},
+		After: correctionState{Amount: d.Expected, CorrectionId: d.CorrectionId}})
+	return err
+}
+
+//correctionState is the state of a corrected credit recorded in the audit log
+type correctionState struct {
+	Amount       float64 `json:"amount"`
+	CorrectionId uint64  `json:"correctionid,omitempty"`
+}
@@ -0,0 +1,318 @@
+package spending
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+)
+
+//ErrLimitExceeded is wrapped by every Violation, a debit blocked by a spending limit matches it with errors.Is
+var ErrLimitExceeded = errors.New("spending limit exceeded")
+
+//ErrUserNotFound is returned when the user of an override or a segment change does not exist
+var ErrUserNotFound = errors.New("cannot find the given user")
+
+//names of the limits, reported by a Violation
+const (
+	LimitSingleDebit   = "max_single_debit"
+	LimitDailyTotal    = "max_daily_total"
+	LimitWeeklyTotal   = "max_weekly_total"
+	LimitDebitsPerHour = "max_debits_per_hour"
+)
+
+//Limits bound the debits of a user, a limit of zero is no limit. totals are over the last 24 hours and 7 days
+type Limits struct {
+	MaxSingleDebit   float64 `json:"max_single_debit"`
+	MaxDailyTotal    float64 `json:"max_daily_total"`
+	MaxWeeklyTotal   float64 `json:"max_weekly_total"`
+	MaxDebitsPerHour int     `json:"max_debits_per_hour"`
+}
+
+//Validate checks that no limit is negative
+func (l Limits) Validate() error {
+	if l.MaxSingleDebit < 0 || l.MaxDailyTotal < 0 || l.MaxWeeklyTotal < 0 || l.MaxDebitsPerHour < 0 {
+		return errors.New("spending limits cannot be negative, use 0 for no limit")
+	}
+	return nil
+}
+
+//Override replaces some of the limits of a user or of a segment of users, exactly one of UserId and Segment is set.
+//a nil limit is inherited, by a user from its segment and by a segment from the configured defaults. an explicit 0
+//removes the limit
+type Override struct {
+	UserId           string   `json:"userid,omitempty"`
+	Segment          string   `json:"segment,omitempty"`
+	MaxSingleDebit   *float64 `json:"max_single_debit"`
+	MaxDailyTotal    *float64 `json:"max_daily_total"`
+	MaxWeeklyTotal   *float64 `json:"max_weekly_total"`
+	MaxDebitsPerHour *int     `json:"max_debits_per_hour"`
+}
+
+//Empty tells whether the override does not replace any limit
+func (o Override) Empty() bool {
+	return o.MaxSingleDebit == nil && o.MaxDailyTotal == nil && o.MaxWeeklyTotal == nil && o.MaxDebitsPerHour == nil
+}
+
+//Validate checks that the override targets either a user or a segment and that no limit is negative
+func (o Override) Validate() error {
+	if (len(o.UserId) == 0) == (len(o.Segment) == 0) {
+		return errors.New("a spending limit override applies to either a userid or a segment")
+	}
+	if len(o.Segment) > 50 {
+		return errors.New("a segment is at most 50 characters long")
+	}
+	return o.Apply(Limits{}).Validate()
+}
+
+//Apply returns the limits with those set by the override replaced
+func (o Override) Apply(l Limits) Limits {
+	if o.MaxSingleDebit != nil {
+		l.MaxSingleDebit = *o.MaxSingleDebit
+	}
+	if o.MaxDailyTotal != nil {
+		l.MaxDailyTotal = *o.MaxDailyTotal
+	}
+	if o.MaxWeeklyTotal != nil {
+		l.MaxWeeklyTotal = *o.MaxWeeklyTotal
+	}
+	if o.MaxDebitsPerHour != nil {
+		l.MaxDebitsPerHour = *o.MaxDebitsPerHour
+	}
+	return l
+}
+
+//Usage is what a user debited over the windows of the limits, reversed debits do not count
+type Usage struct {
+	DailyTotal     float64 `json:"daily_total"`
+	WeeklyTotal    float64 `json:"weekly_total"`
+	DebitsLastHour int     `json:"debits_last_hour"`
+}
+
+//Violation is the limit a debit would go beyond, Limit is the limit and Actual what the user would reach with the debit
+type Violation struct {
+	Limit  string
+	Max    float64
+	Actual float64
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("%s: the debit would bring %s to %v, the limit is %v", ErrLimitExceeded.Error(), v.Limit, v.Actual, v.Max)
+}
+
+func (v *Violation) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+//Check returns the first limit a debit of amount would go beyond given the usage of the user, nil when it is within
+//every limit
+func Check(l Limits, u Usage, amount float64) error {
+	switch {
+	case l.MaxSingleDebit > 0 && exceeds(amount, l.MaxSingleDebit):
+		return &Violation{Limit: LimitSingleDebit, Max: l.MaxSingleDebit, Actual: amount}
+	case l.MaxDailyTotal > 0 && exceeds(u.DailyTotal+amount, l.MaxDailyTotal):
+		return &Violation{Limit: LimitDailyTotal, Max: l.MaxDailyTotal, Actual: cents(u.DailyTotal + amount)}
+	case l.MaxWeeklyTotal > 0 && exceeds(u.WeeklyTotal+amount, l.MaxWeeklyTotal):
+		return &Violation{Limit: LimitWeeklyTotal, Max: l.MaxWeeklyTotal, Actual: cents(u.WeeklyTotal + amount)}
+	case l.MaxDebitsPerHour > 0 && u.DebitsLastHour+1 > l.MaxDebitsPerHour:
+		return &Violation{Limit: LimitDebitsPerHour, Max: float64(l.MaxDebitsPerHour), Actual: float64(u.DebitsLastHour + 1)}
+	}
+	return nil
+}
+
+//Querier runs the queries of the package, both *sql.DB and *sql.Tx implement it
+type Querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+//Settings are the limits applying to a user along with where they come from
+type Settings struct {
+	UserId          string    `json:"userid"`
+	Segment         string    `json:"segment,omitempty"`
+	Defaults        Limits    `json:"defaults"`
+	SegmentOverride *Override `json:"segment_override,omitempty"`
+	UserOverride    *Override `json:"user_override,omitempty"`
+	Limits          Limits    `json:"limits"`
+}
+
+//Effective returns the limits applying to the user: the defaults replaced by the override of the segment of the user,
+//then by the override of the user itself. an unknown user gets the defaults
+func Effective(ctx context.Context, q Querier, userid string, defaults Limits) (Settings, error) {
+	settings := Settings{UserId: userid, Defaults: defaults, Limits: defaults}
+	var user, segment nullOverride
+	err := q.QueryRowContext(ctx, effectiveStatement, userid).Scan(&settings.Segment,
+		&user.id, &user.single, &user.daily, &user.weekly, &user.hourly,
+		&segment.id, &segment.single, &segment.daily, &segment.weekly, &segment.hourly)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	if segment.id.Valid {
+		settings.SegmentOverride = segment.override(Override{Segment: settings.Segment})
+		settings.Limits = settings.SegmentOverride.Apply(settings.Limits)
+	}
+	if user.id.Valid {
+		settings.UserOverride = user.override(Override{UserId: userid})
+		settings.Limits = settings.UserOverride.Apply(settings.Limits)
+	}
+	return settings, nil
+}
+
+//GetUsage returns what the user debited over the last hour, 24 hours and 7 days
+func GetUsage(ctx context.Context, q Querier, userid string) (Usage, error) {
+	var u Usage
+	err := q.QueryRowContext(ctx, usageStatement, userid).Scan(&u.DailyTotal, &u.WeeklyTotal, &u.DebitsLastHour)
+	return u, err
+}
+
+//Evaluate checks a debit of amount against the limits applying to the user, run in the debit transaction it sees
+//the debits committed before it
+func Evaluate(ctx context.Context, q Querier, userid string, amount float64, defaults Limits) error {
+	settings, err := Effective(ctx, q, userid, defaults)
+	if err != nil {
+		return err
+	}
+	if settings.Limits == (Limits{}) {
+		return nil
+	}
+	usage, err := GetUsage(ctx, q, userid)
+	if err != nil {
+		return err
+	}
+	return Check(settings.Limits, usage, amount)
+}
+
+//Set stores the override of a user or a segment in tx replacing the previous one, an empty override removes it.
+//it returns the previous override, nil when there was none
+func Set(ctx context.Context, tx *sql.Tx, o Override) (*Override, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	var previous nullOverride
+	err := tx.QueryRowContext(ctx, overrideSelectStatement, nullString(o.UserId), nullString(o.Segment)).Scan(&previous.id,
+		&previous.single, &previous.daily, &previous.weekly, &previous.hourly)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	var before *Override
+	if previous.id.Valid {
+		before = previous.override(Override{UserId: o.UserId, Segment: o.Segment})
+		if _, err := tx.ExecContext(ctx, overrideDeleteStatement, previous.id.Int64); err != nil {
+			return nil, err
+		}
+	}
+	if o.Empty() {
+		return before, nil
+	}
+	_, err = tx.ExecContext(ctx, overrideInsertStatement, nullString(o.UserId), nullString(o.Segment), nullFloat(o.MaxSingleDebit),
+		nullFloat(o.MaxDailyTotal), nullFloat(o.MaxWeeklyTotal), nullInt(o.MaxDebitsPerHour))
+	return before, err
+}
+
+//SetSegment puts the user in a segment, an empty segment takes it out of its segment. it returns the previous
+//segment of the user
+func SetSegment(ctx context.Context, tx *sql.Tx, userid string, segment string) (string, error) {
+	if len(segment) > 50 {
+		return "", errors.New("a segment is at most 50 characters long")
+	}
+	previous, err := lockUser(ctx, tx, userid)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx, segmentUpdateStatement, nullString(segment), userid)
+	return previous, err
+}
+
+//function to lock the row of the user within tx and get its segment, ErrUserNotFound when it does not exist
+func lockUser(ctx context.Context, tx *sql.Tx, userid string) (string, error) {
+	var segment string
+	err := tx.QueryRowContext(ctx, segmentSelectStatement, userid).Scan(&segment)
+	if err == sql.ErrNoRows {
+		return "", ErrUserNotFound
+	}
+	return segment, err
+}
+
+const (
+	//the override of the user and the one of its segment, NULL columns when there is none
+	effectiveStatement = `SELECT COALESCE(u.segment, ''),
+			o.limitid, o.maxsingledebit, o.maxdailytotal, o.maxweeklytotal, o.maxdebitsperhour,
+			s.limitid, s.maxsingledebit, s.maxdailytotal, s.maxweeklytotal, s.maxdebitsperhour
+		FROM tbl_Users u
+			LEFT JOIN tbl_SpendingLimits o ON o.userid = u.userid
+			LEFT JOIN tbl_SpendingLimits s ON s.segment = u.segment
+		WHERE u.userid=$1`
+	//the rows of a debit share its debitid, so distinct debitids count debits rather than the credits they consumed.
+	//rows written before debitid existed count one each by their tranid. reversed debit rows and the repayments of an
+	//overdraft by a credit(rows with both the overdraft and the credit) are left out
+	usageStatement = `SELECT COALESCE(SUM(a.amount) FILTER (WHERE a.created >= (NOW() AT TIME ZONE 'UTC') - INTERVAL '1 day'), 0),
+			COALESCE(SUM(a.amount), 0),
+			COUNT(DISTINCT COALESCE(a.debitid, a.tranid)) FILTER (WHERE a.created >= (NOW() AT TIME ZONE 'UTC') - INTERVAL '1 hour')
+		FROM tbl_Activity a
+		WHERE a.userid=$1 AND NOT a.iscredit AND a.event IS NULL AND a.created >= (NOW() AT TIME ZONE 'UTC') - INTERVAL '7 days'
+			AND (a.overdraftid IS NULL OR a.usercreditid IS NULL)
+			AND NOT EXISTS (SELECT 1 FROM tbl_Activity r WHERE r.userid = a.userid AND r.reversaloftranid = a.tranid)`
+	overrideSelectStatement = `SELECT limitid, maxsingledebit, maxdailytotal, maxweeklytotal, maxdebitsperhour
+		FROM tbl_SpendingLimits WHERE userid IS NOT DISTINCT FROM $1 AND segment IS NOT DISTINCT FROM $2 FOR UPDATE`
+	overrideDeleteStatement = `DELETE FROM tbl_SpendingLimits WHERE limitid=$1`
+	overrideInsertStatement = `INSERT INTO tbl_SpendingLimits(userid, segment, maxsingledebit, maxdailytotal, maxweeklytotal, maxdebitsperhour)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	segmentSelectStatement = `SELECT COALESCE(segment, '') FROM tbl_Users WHERE userid=$1 FOR UPDATE`
+	segmentUpdateStatement = `UPDATE tbl_Users SET segment=$1 WHERE userid=$2`
+)
+
+//nullOverride scans an override row whose columns may all be NULL(no override)
+type nullOverride struct {
+	id                    sql.NullInt64
+	single, daily, weekly sql.NullFloat64
+	hourly                sql.NullInt64
+}
+
+//function to convert the scanned columns into an override of the given user or segment
+func (n nullOverride) override(o Override) *Override {
+	o.MaxSingleDebit = floatPointer(n.single)
+	o.MaxDailyTotal = floatPointer(n.daily)
+	o.MaxWeeklyTotal = floatPointer(n.weekly)
+	if n.hourly.Valid {
+		hourly := int(n.hourly.Int64)
+		o.MaxDebitsPerHour = &hourly
+	}
+	return &o
+}
+
+//function to compare amounts in cents, so that floating point noise does not block a debit right at the limit
+func exceeds(amount float64, limit float64) bool {
+	return math.Round(amount*100) > math.Round(limit*100)
+}
+
+func cents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+func floatPointer(n sql.NullFloat64) *float64 {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Float64
+}
+
+func nullFloat(f *float64) sql.NullFloat64 {
+	if f == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: *f, Valid: true}
+}
+
+func nullInt(i *int) sql.NullInt64 {
+	if i == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(*i), Valid: true}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: len(s) > 0}
+}
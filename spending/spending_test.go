@@ -0,0 +1,60 @@
+package spending
+
+import (
+	"errors"
+	"testing"
+)
+
+//test case to verify a debit is checked against every limit set and zero limits are ignored
+func TestCheck(t *testing.T) {
+	limits := Limits{MaxSingleDebit: 50, MaxDailyTotal: 100, MaxWeeklyTotal: 300, MaxDebitsPerHour: 3}
+	cases := []struct {
+		usage  Usage
+		amount float64
+		limit  string
+	}{
+		{Usage{}, 50, ""},
+		{Usage{}, 50.01, LimitSingleDebit},
+		{Usage{DailyTotal: 60.1, WeeklyTotal: 60.1}, 39.9, ""},
+		{Usage{DailyTotal: 60.1, WeeklyTotal: 60.1}, 40, LimitDailyTotal},
+		{Usage{DailyTotal: 10, WeeklyTotal: 290}, 20, LimitWeeklyTotal},
+		{Usage{DebitsLastHour: 2}, 1, ""},
+		{Usage{DebitsLastHour: 3}, 1, LimitDebitsPerHour},
+	}
+	for _, c := range cases {
+		err := Check(limits, c.usage, c.amount)
+		var violation *Violation
+		switch {
+		case len(c.limit) == 0 && err != nil:
+			t.Errorf("Expected a debit of %v with %+v to be allowed. Got %v", c.amount, c.usage, err)
+		case len(c.limit) > 0 && (!errors.As(err, &violation) || violation.Limit != c.limit || !errors.Is(err, ErrLimitExceeded)):
+			t.Errorf("Expected a debit of %v with %+v to exceed %s. Got %v", c.amount, c.usage, c.limit, err)
+		}
+	}
+	if err := Check(Limits{}, Usage{DailyTotal: 1e6, DebitsLastHour: 1000}, 1e6); err != nil {
+		t.Errorf("Expected no limit to allow every debit. Got %v", err)
+	}
+}
+
+//test case to verify an override replaces only the limits it sets and targets either a user or a segment
+func TestOverride(t *testing.T) {
+	none, daily, hourly := 0.0, 500.0, 10
+	o := Override{UserId: "7507decb-0f2d-4510-8202-c78699ed3153", MaxSingleDebit: &none, MaxDailyTotal: &daily, MaxDebitsPerHour: &hourly}
+	limits := o.Apply(Limits{MaxSingleDebit: 50, MaxDailyTotal: 100, MaxWeeklyTotal: 300, MaxDebitsPerHour: 3})
+	if expected := (Limits{MaxDailyTotal: 500, MaxWeeklyTotal: 300, MaxDebitsPerHour: 10}); limits != expected {
+		t.Errorf("Expected limits %+v. Got %+v", expected, limits)
+	}
+	if err := o.Validate(); err != nil || o.Empty() {
+		t.Errorf("Expected a valid override. Got %v", err)
+	}
+
+	negative := -1.0
+	for _, invalid := range []Override{{}, {UserId: o.UserId, Segment: "enterprise"}, {Segment: "enterprise", MaxWeeklyTotal: &negative}} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", invalid)
+		}
+	}
+	if !(Override{Segment: "enterprise"}).Empty() {
+		t.Error("Expected an override without limits to be empty")
+	}
+}